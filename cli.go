@@ -0,0 +1,109 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/CiaranMccarthy1/boba-text/pkg/tui"
+)
+
+// cliOptions holds the parsed command line.
+type cliOptions struct {
+	recoverList bool
+	readOnly    bool
+	configPath  string
+	clean       bool
+	checkConfig bool
+	sets        stringList
+	readStdin   bool
+	dir         string
+	files       []tui.FileArg
+}
+
+// stringList is a flag that may be given more than once.
+type stringList []string
+
+func (s *stringList) String() string { return strings.Join(*s, ", ") }
+
+func (s *stringList) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+// fileLineCol matches "path:line" and "path:line:col" as printed by compilers and grep.
+var fileLineCol = regexp.MustCompile(`^(.+?):(\d+)(?::(\d+))?:?$`)
+
+// parseArgs parses flags and positional arguments. Flags may appear anywhere,
+// "+N" sets the line for the following file and "-" reads stdin.
+func parseArgs(args []string) (cliOptions, error) {
+	var opts cliOptions
+
+	fs := flag.NewFlagSet("boba-text", flag.ContinueOnError)
+	fs.BoolVar(&opts.recoverList, "r", false, "list files with recoverable swap files and exit")
+	fs.BoolVar(&opts.readOnly, "R", false, "read-only mode; writing requires :w!")
+	fs.StringVar(&opts.configPath, "config", "", "load configuration from `path`")
+	fs.BoolVar(&opts.clean, "clean", false, "skip user configuration and use built-in defaults")
+	fs.Var(&opts.sets, "set", "override a setting, e.g. --set keys.quit=ctrl+q (repeatable)")
+	fs.BoolVar(&opts.checkConfig, "check-config", false, "validate the configuration, print any problems and exit")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: boba-text [options] [+N] [file[:line[:col]] | dir | -]...\n\nOptions:\n")
+		fs.PrintDefaults()
+	}
+
+	pendingLine := 0
+	for {
+		if err := fs.Parse(args); err != nil {
+			return opts, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			break
+		}
+		arg := args[0]
+		args = args[1:]
+
+		switch {
+		case arg == "-":
+			opts.readStdin = true
+		case strings.HasPrefix(arg, "+"):
+			n, err := strconv.Atoi(arg[1:])
+			if err != nil || n < 1 {
+				return opts, fmt.Errorf("invalid line argument %q", arg)
+			}
+			pendingLine = n
+		default:
+			if info, err := os.Stat(arg); err == nil && info.IsDir() {
+				if opts.dir == "" {
+					opts.dir = arg
+				}
+				continue
+			}
+			fa := parseFileArg(arg)
+			if pendingLine > 0 && fa.Line == 0 {
+				fa.Line = pendingLine
+			}
+			pendingLine = 0
+			opts.files = append(opts.files, fa)
+		}
+	}
+	return opts, nil
+}
+
+// parseFileArg splits an optional ":line:col" suffix off arg, unless a file
+// with the literal name exists.
+func parseFileArg(arg string) tui.FileArg {
+	if _, err := os.Stat(arg); err == nil {
+		return tui.FileArg{Path: arg}
+	}
+	match := fileLineCol.FindStringSubmatch(arg)
+	if match == nil {
+		return tui.FileArg{Path: arg}
+	}
+	line, _ := strconv.Atoi(match[2])
+	col, _ := strconv.Atoi(match[3])
+	return tui.FileArg{Path: match[1], Line: line, Col: col}
+}
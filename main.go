@@ -1,51 +1,157 @@
 package main
 
-
-
 import (
-
-    "fmt"
-
-    "os"
-
-
-
-    "github.com/CiaranMccarthy1/boba-text/pkg/config"
-
-    "github.com/CiaranMccarthy1/boba-text/pkg/tui"
-
-
-
-    tea "github.com/charmbracelet/bubbletea"
-
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/CiaranMccarthy1/boba-text/pkg/config"
+	"github.com/CiaranMccarthy1/boba-text/pkg/fileio"
+	"github.com/CiaranMccarthy1/boba-text/pkg/tui"
+
+	tea "github.com/charmbracelet/bubbletea"
 )
 
-
-
 func main() {
+	opts, err := parseArgs(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(2)
+	}
+
+	if opts.recoverList {
+		os.Exit(listSwaps())
+	}
+
+	startPath := opts.dir
+	if startPath == "" {
+		startPath, err = os.Getwd()
+		if err != nil {
+			fmt.Printf("Error getting cwd: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if abs, err := filepath.Abs(startPath); err == nil {
+		startPath = abs
+	}
+
+	loadOpts := configOptions(opts, startPath)
+	loaded, err := config.Load(loadOpts)
+	if err != nil {
+		fmt.Printf("Error loading config %s: %v\n", opts.configPath, err)
+		os.Exit(1)
+	}
+	if opts.checkConfig {
+		os.Exit(reportConfig(loaded.Issues))
+	}
+
+	startup := tui.StartupOptions{
+		Files:         opts.files,
+		ReadOnly:      opts.readOnly,
+		Config:        loaded,
+		ConfigOptions: loadOpts,
+	}
+	programOpts := []tea.ProgramOption{tea.WithAltScreen(), tea.WithReportFocus()}
+	if opts.readStdin {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Printf("Error reading stdin: %v\n", err)
+			os.Exit(1)
+		}
+		text, _, err := fileio.Decode(data)
+		if err != nil {
+			fmt.Printf("Error reading stdin: %v\n", err)
+			os.Exit(1)
+		}
+		startup.Stdin = &text
+		// Stdin is the pipe, so keyboard input has to come from the terminal.
+		programOpts = append(programOpts, tea.WithInputTTY())
+	}
+
+	p := tea.NewProgram(tui.InitialModel(startPath, loaded.Config, startup), programOpts...)
+	final, err := p.Run()
+	if m, ok := final.(tui.Model); ok {
+		m.Close()
+	}
+	if err != nil {
+		fmt.Printf("Alas, there's been an error: %v", err)
+		os.Exit(1)
+	}
+}
 
-    cwd, err := os.Getwd()
-
-    if err != nil {
-
-        fmt.Printf("Error getting cwd: %v\n", err)
-
-        os.Exit(1)
-
-    }
-
-
-
-    cfg := config.Load()
-
-    p := tea.NewProgram(tui.InitialModel(cwd, cfg), tea.WithAltScreen())
-
-    if _, err := p.Run(); err != nil {
-
-        fmt.Printf("Alas, there's been an error: %v", err)
+// configOptions selects the configuration layers from the command line.
+// Untrusted project configs are offered for trust on the terminal before the
+// editor starts.
+func configOptions(opts cliOptions, startPath string) config.LoadOptions {
+	return config.LoadOptions{
+		StartPath:  startPath,
+		ConfigPath: opts.configPath,
+		Clean:      opts.clean,
+		Sets:       opts.sets,
+		TrustProject: func(path string) bool {
+			if opts.readStdin || opts.checkConfig || !isTerminal(os.Stdin) {
+				return false
+			}
+			fmt.Printf("Project config found: %s\n"+
+				"It can rebind keys and change AI settings, but not ai.key_command, ai.base_url\n"+
+				"or ai.credentials_file, which are ignored there. Trust it? [y/N] ", path)
+			answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+			answer = strings.ToLower(strings.TrimSpace(answer))
+			if answer != "y" && answer != "yes" {
+				return false
+			}
+			if err := config.Trust(path); err != nil {
+				fmt.Printf("Could not record trust: %v\n", err)
+			}
+			return true
+		},
+	}
+}
 
-        os.Exit(1)
+// isTerminal reports whether f is an interactive terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
 
-    }
+// reportConfig prints configuration problems for --check-config and returns the exit code.
+func reportConfig(issues []config.Issue) int {
+	if len(issues) == 0 {
+		fmt.Println("Config OK")
+		return 0
+	}
+	for _, issue := range issues {
+		fmt.Println(issue)
+	}
+	fmt.Printf("\n%d problem(s) found\n", len(issues))
+	return 1
+}
 
+// listSwaps prints every file with a leftover swap and returns the exit code.
+func listSwaps() int {
+	swaps, err := fileio.ListSwaps()
+	if err != nil {
+		fmt.Printf("Error reading swap files: %v\n", err)
+		return 1
+	}
+	dir, _ := fileio.SwapDir()
+	if len(swaps) == 0 {
+		fmt.Printf("No swap files found in %s\n", dir)
+		return 0
+	}
+	fmt.Printf("Swap files found in %s:\n", dir)
+	for i, s := range swaps {
+		fmt.Printf("%3d. %s\n       modified: %s  pid: %d\n",
+			i+1, s.Path, s.Modified.Format("2006-01-02 15:04:05"), s.PID)
+	}
+	fmt.Println("\nOpen a file to recover, diff or delete its swap.")
+	return 0
 }
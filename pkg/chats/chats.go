@@ -0,0 +1,299 @@
+// Package chats saves agent conversations per project so they can be
+// reopened, searched and exported later.
+package chats
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/CiaranMccarthy1/boba-text/pkg/fileio"
+	"github.com/CiaranMccarthy1/boba-text/pkg/llm"
+)
+
+// Chat is a saved conversation with the agent.
+type Chat struct {
+	ID      string    `json:"id"`
+	Title   string    `json:"title"`
+	Project string    `json:"project"`
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+	// Provider and Model record what the conversation was held with.
+	Provider     string           `json:"provider,omitempty"`
+	Model        string           `json:"model,omitempty"`
+	Conversation llm.Conversation `json:"conversation"`
+}
+
+// maxTitle is how much of the first prompt becomes a chat's title.
+const maxTitle = 60
+
+// DataDir returns the directory boba-text keeps user data in:
+// $XDG_DATA_HOME/boba-text or ~/.local/share/boba-text, and the user config
+// directory on Windows and macOS.
+func DataDir() (string, error) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "darwin" {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(dir, "boba-text"), nil
+	}
+	if dir := os.Getenv("XDG_DATA_HOME"); dir != "" {
+		return filepath.Join(dir, "boba-text"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "share", "boba-text"), nil
+}
+
+// Store keeps the chats of one project, each as a JSON file with a markdown
+// copy beside it for reading and grepping.
+type Store struct {
+	Dir     string
+	Project string
+}
+
+// ForProject returns the store for the project at root.
+func ForProject(root string) (Store, error) {
+	data, err := DataDir()
+	if err != nil {
+		return Store{}, err
+	}
+	name, err := fileio.EncodePath(root)
+	if err != nil {
+		return Store{}, err
+	}
+	abs, _ := filepath.Abs(root)
+	return Store{Dir: filepath.Join(data, "chats", name), Project: abs}, nil
+}
+
+// Save writes c, giving it an ID and title first if it has none.
+func (s Store) Save(c *Chat) error {
+	now := time.Now()
+	if c.ID == "" {
+		c.ID = s.newID(now)
+		c.Created = now
+	}
+	if c.Title == "" {
+		c.Title = DefaultTitle(c.Conversation)
+	}
+	c.Project = s.Project
+	c.Updated = now
+	return s.write(*c)
+}
+
+// write stores c as JSON with its markdown copy beside it.
+func (s Store) write(c Chat) error {
+	if err := os.MkdirAll(s.Dir, 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeAtomic(s.path(c.ID, ".json"), data); err != nil {
+		return err
+	}
+	return writeAtomic(s.path(c.ID, ".md"), []byte(Markdown(c)))
+}
+
+// Load reads the chat with the given ID.
+func (s Store) Load(id string) (Chat, error) {
+	data, err := os.ReadFile(s.path(id, ".json"))
+	if err != nil {
+		return Chat{}, err
+	}
+	var c Chat
+	if err := json.Unmarshal(data, &c); err != nil {
+		return Chat{}, fmt.Errorf("reading chat %s: %w", id, err)
+	}
+	return c, nil
+}
+
+// List returns the project's chats, most recently updated first. Unreadable
+// files are skipped.
+func (s Store) List() ([]Chat, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []Chat
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), ".json")
+		if e.IsDir() || !ok {
+			continue
+		}
+		if c, err := s.Load(id); err == nil {
+			list = append(list, c)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Updated.After(list[j].Updated) })
+	return list, nil
+}
+
+// Rename changes a chat's title. It is not new activity, so the chat keeps
+// its place in the list.
+func (s Store) Rename(id, title string) error {
+	title = strings.TrimSpace(title)
+	if title == "" {
+		return errors.New("a chat needs a title")
+	}
+	c, err := s.Load(id)
+	if err != nil {
+		return err
+	}
+	c.Title = title
+	return s.write(c)
+}
+
+// Delete removes a chat and its markdown copy.
+func (s Store) Delete(id string) error {
+	for _, ext := range []string{".json", ".md"} {
+		if err := os.Remove(s.path(id, ext)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s Store) path(id, ext string) string {
+	return filepath.Join(s.Dir, id+ext)
+}
+
+// newID names a chat after the time it was started, adding a suffix if two
+// start within the same second.
+func (s Store) newID(t time.Time) string {
+	base := t.Format("20060102-150405")
+	id := base
+	for n := 2; ; n++ {
+		if _, err := os.Stat(s.path(id, ".json")); errors.Is(err, fs.ErrNotExist) {
+			return id
+		}
+		id = fmt.Sprintf("%s-%d", base, n)
+	}
+}
+
+// writeAtomic writes beside the destination and renames, so a crash mid-write
+// leaves the previous version intact.
+func writeAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// DefaultTitle is the start of the first prompt, on one line.
+func DefaultTitle(conv llm.Conversation) string {
+	for _, t := range conv.Turns {
+		if t.Role != llm.RoleUser {
+			continue
+		}
+		title := strings.Join(strings.Fields(t.Text), " ")
+		if r := []rune(title); len(r) > maxTitle {
+			title = string(r[:maxTitle-1]) + "…"
+		}
+		if title != "" {
+			return title
+		}
+	}
+	return "Untitled chat"
+}
+
+// Matches reports whether every word of query appears, ignoring case, in the
+// chat's title or anything said in it.
+func (c Chat) Matches(query string) bool {
+	words := strings.Fields(strings.ToLower(query))
+	if len(words) == 0 {
+		return true
+	}
+	var text strings.Builder
+	text.WriteString(strings.ToLower(c.Title))
+	for _, t := range c.Conversation.Turns {
+		text.WriteString("\n" + strings.ToLower(t.Text))
+	}
+	haystack := text.String()
+	for _, w := range words {
+		if !strings.Contains(haystack, w) {
+			return false
+		}
+	}
+	return true
+}
+
+// Markdown renders a chat for reading: each turn under a heading, tool calls
+// and their results in code blocks, and attachments by name.
+func Markdown(c Chat) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n", c.Title)
+	meta := []string{c.Created.Format("2006-01-02 15:04")}
+	if c.Provider != "" {
+		meta = append(meta, strings.TrimSpace(c.Provider+" "+c.Model))
+	}
+	if c.Project != "" {
+		meta = append(meta, c.Project)
+	}
+	fmt.Fprintf(&b, "_%s_\n\n", strings.Join(meta, " · "))
+	if c.Conversation.Summary != "" {
+		fmt.Fprintf(&b, "## Summary of earlier turns\n\n%s\n\n", c.Conversation.Summary)
+	}
+	for _, t := range c.Conversation.Turns {
+		switch t.Role {
+		case llm.RoleUser:
+			b.WriteString("## You\n\n")
+			for _, a := range t.Attachments {
+				name := a.Name
+				if a.Label != "" {
+					name += " (" + a.Label + ")"
+				}
+				fmt.Fprintf(&b, "> Attached: `%s`\n", name)
+			}
+			if len(t.Attachments) > 0 {
+				b.WriteString("\n")
+			}
+			b.WriteString(t.Text + "\n\n")
+		case llm.RoleAssistant:
+			b.WriteString("## Assistant\n\n")
+			if t.Text != "" {
+				b.WriteString(t.Text + "\n\n")
+			}
+			for _, call := range t.ToolCalls {
+				var args bytes.Buffer
+				if json.Compact(&args, call.Args) != nil {
+					args.Write(call.Args)
+				}
+				fmt.Fprintf(&b, "Called `%s`:\n\n```json\n%s\n```\n\n", call.Name, args.String())
+			}
+		case llm.RoleTool:
+			fmt.Fprintf(&b, "Result of `%s`:\n\n%s\n%s\n%s\n\n", t.ToolName, fence(t.Text), t.Text, fence(t.Text))
+		}
+	}
+	return b.String()
+}
+
+// fence returns a code fence longer than any run of backticks in text.
+func fence(text string) string {
+	longest, run := 0, 0
+	for _, r := range text {
+		if r == '`' {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+	return strings.Repeat("`", max(3, longest+1))
+}
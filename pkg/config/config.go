@@ -1,12 +1,6 @@
 package config
 
-import (
-	"os"
-
-	"path/filepath"
-
-	"github.com/BurntSushi/toml"
-)
+// Colors override individual colours of the active theme. Empty values keep the theme's colour.
 
 type Colors struct {
 	Text string `toml:"text"`
@@ -56,12 +50,97 @@ type Keys struct {
 	EditorCommandRun  string `toml:"editor_command_run"`
 
 	AgentSend string `toml:"agent_send"`
+
+	AgentCancel string `toml:"agent_cancel"`
+
+	AgentContext string `toml:"agent_context"`
+
+	AgentInsert string `toml:"agent_insert"`
 }
 
 type AI struct {
 	Name string `toml:"name"`
 
+	// Provider is one of gemini, openai, anthropic, ollama or llamacpp.
+	Provider string `toml:"provider"`
+
 	Model string `toml:"model"`
+
+	// BaseURL overrides the provider's API endpoint (proxies, compatible servers, local models).
+	BaseURL string `toml:"base_url"`
+
+	// KeyCommand prints the API key when the provider's environment variable
+	// is unset, e.g. "pass show gemini".
+	KeyCommand string `toml:"key_command"`
+
+	// CredentialsFile holds API keys by provider name, used when neither the
+	// environment nor KeyCommand gives one. Empty means CredentialsPath.
+	CredentialsFile string `toml:"credentials_file"`
+
+	// Stream shows replies as they are generated instead of all at once.
+	Stream bool `toml:"stream"`
+
+	// SystemPrompt replaces the built-in instructions that open every request.
+	// How to propose file changes, and to use tools, is still added to it.
+	SystemPrompt string `toml:"system_prompt"`
+
+	// Temperature and TopP control how varied replies are; unset leaves the
+	// provider's defaults.
+	Temperature *float64 `toml:"temperature"`
+
+	TopP *float64 `toml:"top_p"`
+
+	// MaxOutputTokens caps the length of a reply. 0 uses the provider's default.
+	MaxOutputTokens int `toml:"max_output_tokens"`
+
+	// Stop sequences end a reply early when generated.
+	Stop []string `toml:"stop"`
+
+	// Safety sets Gemini's blocking threshold per harm category, such as
+	// harassment = "block_only_high".
+	Safety map[string]string `toml:"safety"`
+
+	// HistoryBudget is the approximate number of tokens of conversation
+	// history sent with each request; older turns are summarised to fit. 0 means no limit.
+	HistoryBudget int `toml:"history_budget"`
+
+	// TokenLimit is the approximate number of tokens a whole request may use;
+	// attachments are cut to fit. 0 uses the provider's usual context window.
+	TokenLimit int `toml:"token_limit"`
+
+	// Tools lets the assistant read, search and (with approval) edit files and
+	// run commands in the project directory.
+	Tools bool `toml:"tools"`
+
+	// MaxSteps caps the tool calls the assistant may make while answering one prompt.
+	MaxSteps int `toml:"max_steps"`
+
+	// Retries is how many times a request failing with a network error, a
+	// rate limit or a server error is retried, backing off between attempts.
+	Retries int `toml:"retries"`
+
+	// RequestsPerMinute holds requests back to stay under the provider's rate
+	// limit. 0 is unlimited.
+	RequestsPerMinute int `toml:"requests_per_minute"`
+
+	// SaveChats keeps each conversation under the user data directory so it
+	// can be reopened from the history browser.
+	SaveChats bool `toml:"save_chats"`
+
+	// Templates add slash commands to the agent pane, or replace the built-in
+	// ones of the same name.
+	Templates []Template `toml:"templates"`
+}
+
+// Template is a prompt the agent pane runs as /name. Prompt may use the
+// placeholders {{file}}, {{filetype}}, {{selection}} and {{input}}, the text
+// typed after the command. {{diagnostics}} is not supported.
+type Template struct {
+	Name string `toml:"name"`
+
+	Description string `toml:"description"`
+
+	Prompt string `toml:"prompt"`
 }
 
 type Commands struct {
@@ -70,7 +149,15 @@ type Commands struct {
 	Quit []string `toml:"quit"`
 }
 
+type Files struct {
+	Backup bool `toml:"backup"`
+
+	BackupDir string `toml:"backup_dir"`
+}
+
 type Config struct {
+	Theme string `toml:"theme"`
+
 	Colors Colors `toml:"colors"`
 
 	Keys Keys `toml:"keys"`
@@ -78,23 +165,15 @@ type Config struct {
 	AI AI `toml:"ai"`
 
 	Commands Commands `toml:"commands"`
+
+	Files Files `toml:"files"`
 }
 
 // DefaultConfig returns the default configuration with sensible preset values.
 
 func DefaultConfig() Config {
 	return Config{
-		Colors: Colors{
-			Text:      "#FAFAFA",
-			SubText:   "#7D7D7D",
-			Primary:   "#F25D94", // Neon Pink
-			Secondary: "#A550DF", // Purple
-			Accent:    "#61AFEF", // Blue
-			Success:   "#98C379", // Green
-			Warning:   "#E5C07B", // Yellow
-			Error:     "#E06C75", // Red
-			Dark:      "#1E1E1E",
-		},
+		Theme: "dark",
 		Keys: Keys{
 			ToggleTree:        "ctrl+t",
 			FocusTree:         "ctrl+e",
@@ -115,10 +194,20 @@ func DefaultConfig() Config {
 			EditorNormalMode:  "esc",
 			EditorCommandRun:  "enter",
 			AgentSend:         "enter",
+			AgentCancel:       "esc",
+			AgentContext:      "ctrl+o",
+			AgentInsert:       "ctrl+y",
 		},
 		AI: AI{
-			Name:  "Agent",
-			Model: "default",
+			Name:          "Agent",
+			Provider:      "gemini",
+			Model:         "default",
+			Stream:        true,
+			HistoryBudget: 16000,
+			Tools:         true,
+			MaxSteps:      8,
+			Retries:       3,
+			SaveChats:     true,
 		},
 		Commands: Commands{
 			Save: []string{"w", "s", "save", "write"},
@@ -127,41 +216,4 @@ func DefaultConfig() Config {
 	}
 }
 
-// Load attempts to load configuration from local file or home directory, falling back to defaults.
-
-func Load() Config {
-
-	var paths []string
-
-	configDir, err := os.UserConfigDir()
-	if err == nil {
-		paths = append(paths, filepath.Join(configDir, "boba-text", "config.toml"))
-	}
-
-	for _, path := range paths {
-
-		if _, err := os.Stat(path); err == nil {
-
-			var cfg Config
-
-			defaults := DefaultConfig()
-
-			cfg = defaults
-
-			// Unmarshal over defaults (this is a simple way, though slightly imperfect for deep structs, works here)
-
-			if _, err := toml.DecodeFile(path, &cfg); err == nil {
-
-				return cfg
-
-			}
-
-		}
-
-	}
-
-	return DefaultConfig()
-
-}
-
 //Test comment
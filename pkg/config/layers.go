@@ -0,0 +1,453 @@
+package config
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Layer names, in order of increasing precedence.
+const (
+	LayerDefault = "default"
+	LayerSystem  = "system"
+	LayerUser    = "user"
+	LayerProject = "project"
+	LayerFlag    = "--set"
+)
+
+// layers lists the layer names in order of precedence.
+var layers = []string{LayerDefault, LayerSystem, LayerUser, LayerProject, LayerFlag}
+
+// ProjectConfig is the location of a project's config relative to its root.
+var ProjectConfig = filepath.Join(".boba", "config.toml")
+
+// LoadOptions selects which layers Load reads.
+type LoadOptions struct {
+	// StartPath is where the search for a project config begins.
+	StartPath string
+	// ConfigPath replaces the user config file when set.
+	ConfigPath string
+	// Clean skips every file layer, leaving defaults and Sets.
+	Clean bool
+	// Sets are "key=value" overrides such as "keys.quit=ctrl+q".
+	Sets []string
+	// TrustProject is asked before an untrusted project config is applied.
+	// A nil func never applies untrusted project configs.
+	TrustProject func(path string) bool
+}
+
+// Loaded is an effective configuration along with where each value came from.
+type Loaded struct {
+	Config Config
+	// Sources maps dotted keys such as "keys.quit" to the layer that set them.
+	// Keys absent from the map come from the defaults.
+	Sources map[string]string
+	// Files lists the config files that were applied, by layer.
+	Files  map[string]string
+	Issues []Issue
+}
+
+// Load builds the configuration from built-in defaults, the system-wide file,
+// the user file, the project file and command-line overrides, in that order.
+// Problems with individual layers, including TOML errors in ConfigPath, are
+// reported as issues; only a missing or unreadable ConfigPath is an error.
+func Load(opts LoadOptions) (Loaded, error) {
+	l := Loaded{
+		Config:  DefaultConfig(),
+		Sources: map[string]string{},
+		Files:   map[string]string{},
+	}
+
+	if !opts.Clean {
+		if path := systemConfigPath(); path != "" {
+			l.applyFile(LayerSystem, path)
+		}
+
+		if opts.ConfigPath != "" {
+			f, err := os.Open(opts.ConfigPath)
+			if err != nil {
+				return l, err
+			}
+			f.Close()
+			l.applyFile(LayerUser, opts.ConfigPath)
+		} else if path := UserConfigPath(); path != "" {
+			l.applyFile(LayerUser, path)
+		}
+
+		if path := FindProjectConfig(opts.StartPath); path != "" {
+			if IsTrusted(path) || (opts.TrustProject != nil && opts.TrustProject(path)) {
+				l.applyFile(LayerProject, path)
+			} else {
+				l.Issues = append(l.Issues, Issue{File: path, Message: "project config not trusted, ignoring"})
+			}
+		}
+	}
+
+	for _, set := range opts.Sets {
+		l.applySet(set)
+	}
+
+	l.Issues = append(l.Issues, Validate(l.Config)...)
+	return l, nil
+}
+
+// Paths returns the config files Load would consider, in layer order, whether
+// or not they exist yet. It is used to watch for changes.
+func (opts LoadOptions) Paths() []string {
+	if opts.Clean {
+		return nil
+	}
+	var paths []string
+	if path := systemConfigPath(); path != "" {
+		paths = append(paths, path)
+	}
+	if opts.ConfigPath != "" {
+		paths = append(paths, opts.ConfigPath)
+	} else if path := UserConfigPath(); path != "" {
+		paths = append(paths, path)
+	}
+	if path := FindProjectConfig(opts.StartPath); path != "" {
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// applyFile decodes path over the current config and records the keys it set.
+// A missing file is skipped silently and a broken one reported as an issue.
+func (l *Loaded) applyFile(layer, path string) {
+	if _, err := os.Stat(path); err != nil {
+		return
+	}
+	// Decode into a copy so a half-applied broken file does not leak through.
+	next := l.Config
+	md, err := toml.DecodeFile(path, &next)
+	if err != nil {
+		l.Issues = append(l.Issues, Issue{File: path, Message: err.Error() + " (file ignored)"})
+		return
+	}
+	var refused []string
+	var sources map[string]string
+	if layer == LayerProject {
+		refused = l.refuseProjectKeys(&next, md, path)
+		sources = maps.Clone(l.Sources)
+	}
+	l.Config = next
+	l.Files[layer] = path
+	l.recordSources(layer, md)
+	for _, key := range refused {
+		if source, ok := sources[key]; ok {
+			l.Sources[key] = source
+		} else {
+			delete(l.Sources, key)
+		}
+	}
+	l.Issues = append(l.Issues, undecodedIssues(path, md)...)
+}
+
+// refuseProjectKeys undoes the settings a project config may not make,
+// reporting each, and returns their keys. They would let a repository run a
+// command when the agent starts, or choose where the user's API key is read
+// from or sent.
+func (l *Loaded) refuseProjectKeys(next *Config, md toml.MetaData, path string) []string {
+	var refused []string
+	for _, r := range []struct {
+		key   string
+		field *string
+		was   string
+	}{
+		{"key_command", &next.AI.KeyCommand, l.Config.AI.KeyCommand},
+		{"base_url", &next.AI.BaseURL, l.Config.AI.BaseURL},
+		{"credentials_file", &next.AI.CredentialsFile, l.Config.AI.CredentialsFile},
+	} {
+		if md.IsDefined("ai", r.key) {
+			*r.field = r.was
+			refused = append(refused, "ai."+r.key)
+			l.Issues = append(l.Issues, Issue{File: path, Key: "ai." + r.key,
+				Message: "not allowed in a project config, set it in your user config (ignored)"})
+		}
+	}
+	return refused
+}
+
+// applySet applies a single "key=value" override. Values that are not valid
+// TOML are treated as strings, so "keys.quit=ctrl+q" works unquoted.
+func (l *Loaded) applySet(set string) {
+	key, value, ok := strings.Cut(set, "=")
+	key = strings.TrimSpace(key)
+	value = strings.TrimSpace(value)
+	if !ok || key == "" {
+		l.Issues = append(l.Issues, Issue{File: LayerFlag, Message: fmt.Sprintf("expected key=value, got %q", set)})
+		return
+	}
+
+	next := l.Config
+	md, err := toml.Decode(key+" = "+value, &next)
+	if err != nil {
+		next = l.Config
+		md, err = toml.Decode(key+" = "+strconv.Quote(value), &next)
+	}
+	if err != nil {
+		l.Issues = append(l.Issues, Issue{File: LayerFlag, Key: key, Message: err.Error()})
+		return
+	}
+	l.Config = next
+	l.recordSources(LayerFlag, md)
+	l.Issues = append(l.Issues, undecodedIssues(LayerFlag, md)...)
+}
+
+// Set changes one [ai] setting, as "/set temperature 0.2" does in the agent
+// pane. Values are parsed as in applySet, and must pass validation.
+func (ai *AI) Set(key, value string) error {
+	var patch AI
+	md, err := toml.Decode(key+" = "+value, &patch)
+	if err != nil {
+		patch = AI{}
+		md, err = toml.Decode(key+" = "+strconv.Quote(value), &patch)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	if len(md.Undecoded()) > 0 {
+		return fmt.Errorf("unknown setting %q", key)
+	}
+
+	// Copy just the one field, so the result shares nothing with patch's
+	// zero values or with other copies of ai.
+	next := *ai
+	v, p := reflect.ValueOf(&next).Elem(), reflect.ValueOf(patch)
+	for i := 0; i < v.NumField(); i++ {
+		if v.Type().Field(i).Tag.Get("toml") == key {
+			v.Field(i).Set(p.Field(i))
+		}
+	}
+	for _, issue := range validateAI(next) {
+		if issue.Key == "ai."+key || strings.HasPrefix(issue.Key, "ai."+key+".") {
+			return fmt.Errorf("%s: %s", key, issue.Message)
+		}
+	}
+	*ai = next
+	return nil
+}
+
+func (l *Loaded) recordSources(layer string, md toml.MetaData) {
+	for _, key := range md.Keys() {
+		if md.Type(key...) == "Hash" {
+			continue
+		}
+		l.Sources[key.String()] = layer
+	}
+}
+
+// Setting is one effective configuration value and the layer that supplied it.
+type Setting struct {
+	Key    string
+	Value  string
+	Source string
+}
+
+// Settings lists every configuration value in declaration order.
+func (l Loaded) Settings() []Setting {
+	var settings []Setting
+	var walk func(prefix string, v reflect.Value)
+	walk = func(prefix string, v reflect.Value) {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			tag := t.Field(i).Tag.Get("toml")
+			if tag == "" || tag == "-" {
+				continue
+			}
+			key := tag
+			if prefix != "" {
+				key = prefix + "." + tag
+			}
+			fv := v.Field(i)
+			if fv.Kind() == reflect.Struct {
+				walk(key, fv)
+				continue
+			}
+			source := l.Sources[key]
+			if fv.Kind() == reflect.Map {
+				// A table comes from the latest layer to set any of its entries.
+				for k, layer := range l.Sources {
+					if strings.HasPrefix(k, key+".") && slices.Index(layers, layer) > slices.Index(layers, source) {
+						source = layer
+					}
+				}
+			}
+			if source == "" {
+				source = LayerDefault
+			}
+			settings = append(settings, Setting{Key: key, Value: formatValue(fv), Source: source})
+		}
+	}
+	walk("", reflect.ValueOf(l.Config))
+	return settings
+}
+
+func formatValue(v reflect.Value) string {
+	switch v.Kind() {
+	case reflect.String:
+		return strconv.Quote(v.String())
+	case reflect.Pointer:
+		if v.IsNil() {
+			return "(unset)"
+		}
+		return formatValue(v.Elem())
+	case reflect.Slice:
+		parts := make([]string, v.Len())
+		for i := range parts {
+			parts[i] = formatValue(v.Index(i))
+		}
+		return "[" + strings.Join(parts, ", ") + "]"
+	case reflect.Map:
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		parts := make([]string, len(keys))
+		for i, k := range keys {
+			parts[i] = k.String() + " = " + formatValue(v.MapIndex(k))
+		}
+		return "{" + strings.Join(parts, ", ") + "}"
+	case reflect.Struct:
+		parts := make([]string, v.NumField())
+		for i := range parts {
+			parts[i] = v.Type().Field(i).Tag.Get("toml") + " = " + formatValue(v.Field(i))
+		}
+		return "{" + strings.Join(parts, ", ") + "}"
+	default:
+		return fmt.Sprint(v.Interface())
+	}
+}
+
+// UserConfigPath returns the per-user config file location.
+func UserConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "boba-text", "config.toml")
+}
+
+// CredentialsPath returns the default location of the API key file, beside
+// the user config.
+func CredentialsPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "boba-text", "credentials.toml")
+}
+
+// systemConfigPath returns the machine-wide config file location.
+func systemConfigPath() string {
+	if runtime.GOOS == "windows" {
+		dir := os.Getenv("ProgramData")
+		if dir == "" {
+			return ""
+		}
+		return filepath.Join(dir, "boba-text", "config.toml")
+	}
+	return "/etc/boba-text/config.toml"
+}
+
+// FindProjectConfig walks up from start looking for a project config file.
+func FindProjectConfig(start string) string {
+	if start == "" {
+		return ""
+	}
+	dir, err := filepath.Abs(start)
+	if err != nil {
+		return ""
+	}
+	if info, err := os.Stat(dir); err == nil && !info.IsDir() {
+		dir = filepath.Dir(dir)
+	}
+	for {
+		path := filepath.Join(dir, ProjectConfig)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// trustFile records trusted project configs as "sha256  path" lines, so a
+// project config has to be trusted again after it changes.
+func trustFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "boba-text", "trusted")
+}
+
+func fileHash(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// IsTrusted reports whether the project config at path, in its current form,
+// has been trusted.
+func IsTrusted(path string) bool {
+	hash, err := fileHash(path)
+	if err != nil {
+		return false
+	}
+	f, err := os.Open(trustFile())
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	want := hash + "  " + path
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if scanner.Text() == want {
+			return true
+		}
+	}
+	return false
+}
+
+// Trust records the project config at path as trusted.
+func Trust(path string) error {
+	hash, err := fileHash(path)
+	if err != nil {
+		return err
+	}
+	store := trustFile()
+	if store == "" {
+		return fmt.Errorf("no user config directory")
+	}
+	if err := os.MkdirAll(filepath.Dir(store), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(store, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(f, "%s  %s\n", hash, path); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,228 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/BurntSushi/toml"
+	"github.com/CiaranMccarthy1/boba-text/pkg/llm"
+)
+
+// Issue describes a problem found while loading or validating configuration.
+type Issue struct {
+	File    string
+	Key     string
+	Message string
+}
+
+func (i Issue) String() string {
+	var b strings.Builder
+	if i.File != "" {
+		b.WriteString(i.File + ": ")
+	}
+	if i.Key != "" {
+		b.WriteString(i.Key + ": ")
+	}
+	b.WriteString(i.Message)
+	return b.String()
+}
+
+var hexColor = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+var templateName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// reservedCommands are the agent pane's own slash commands, which templates
+// cannot replace.
+var reservedCommands = map[string]bool{"new": true, "clear": true, "history": true, "set": true}
+
+// namedKeys are the key names bubbletea reports for non-character keys.
+var namedKeys = map[string]bool{
+	"enter": true, "esc": true, "tab": true, "shift+tab": true, "backspace": true,
+	"delete": true, "insert": true, " ": true,
+	"ctrl+@": true, "ctrl+\\": true, "ctrl+]": true, "ctrl+^": true, "ctrl+_": true,
+}
+
+// navKeys may be combined with ctrl and shift modifiers.
+var navKeys = []string{"up", "down", "left", "right", "home", "end", "pgup", "pgdown"}
+
+// undecodedIssues reports keys present in the file that do not map to any setting.
+func undecodedIssues(path string, md toml.MetaData) []Issue {
+	var issues []Issue
+	for _, key := range md.Undecoded() {
+		issues = append(issues, Issue{File: path, Key: key.String(), Message: "unknown setting"})
+	}
+	return issues
+}
+
+// Validate checks colours, key names and key binding conflicts.
+func Validate(cfg Config) []Issue {
+	var issues []Issue
+
+	for _, f := range tomlFields(cfg.Colors) {
+		if !validColor(f.value) {
+			issues = append(issues, Issue{
+				Key:     "colors." + f.tag,
+				Message: fmt.Sprintf("invalid colour %q (use #RGB, #RRGGBB or an ANSI number 0-255)", f.value),
+			})
+		}
+	}
+
+	keyFields := tomlFields(cfg.Keys)
+	for _, f := range keyFields {
+		if !ValidKey(f.value) {
+			issues = append(issues, Issue{
+				Key:     "keys." + f.tag,
+				Message: fmt.Sprintf("unrecognised key name %q", f.value),
+			})
+		}
+	}
+	issues = append(issues, duplicateBindings(keyFields)...)
+	issues = append(issues, validateAI(cfg.AI)...)
+	return issues
+}
+
+// validateAI checks the [ai] section.
+func validateAI(ai AI) []Issue {
+	var issues []Issue
+	if _, err := llm.New(llm.Config{Provider: ai.Provider}); err != nil {
+		issues = append(issues, Issue{Key: "ai.provider", Message: err.Error()})
+	}
+	if ai.HistoryBudget < 0 {
+		issues = append(issues, Issue{Key: "ai.history_budget", Message: "must be 0 (no limit) or a positive number of tokens"})
+	}
+	if ai.TokenLimit < 0 {
+		issues = append(issues, Issue{Key: "ai.token_limit", Message: "must be 0 (provider default) or a positive number of tokens"})
+	}
+	if ai.MaxSteps < 1 {
+		issues = append(issues, Issue{Key: "ai.max_steps", Message: "must be at least 1"})
+	}
+	if ai.Retries < 0 {
+		issues = append(issues, Issue{Key: "ai.retries", Message: "must be 0 (no retries) or more"})
+	}
+	if ai.RequestsPerMinute < 0 {
+		issues = append(issues, Issue{Key: "ai.requests_per_minute", Message: "must be 0 (no limit) or a positive number"})
+	}
+	for i, t := range ai.Templates {
+		key := fmt.Sprintf("ai.templates[%d]", i)
+		switch {
+		case !templateName.MatchString(t.Name):
+			issues = append(issues, Issue{Key: key + ".name", Message: fmt.Sprintf("invalid name %q (use letters, digits, - and _)", t.Name)})
+		case reservedCommands[t.Name]:
+			issues = append(issues, Issue{Key: key + ".name", Message: fmt.Sprintf("/%s is a built-in command", t.Name)})
+		}
+		if strings.TrimSpace(t.Prompt) == "" {
+			issues = append(issues, Issue{Key: key + ".prompt", Message: "must not be empty"})
+		}
+	}
+	if t := ai.Temperature; t != nil && (*t < 0 || *t > 2) {
+		issues = append(issues, Issue{Key: "ai.temperature", Message: "must be between 0 and 2"})
+	}
+	if p := ai.TopP; p != nil && (*p <= 0 || *p > 1) {
+		issues = append(issues, Issue{Key: "ai.top_p", Message: "must be above 0 and at most 1"})
+	}
+	if ai.MaxOutputTokens < 0 {
+		issues = append(issues, Issue{Key: "ai.max_output_tokens", Message: "must be 0 (provider default) or a positive number of tokens"})
+	}
+	for _, category := range slices.Sorted(maps.Keys(ai.Safety)) {
+		threshold := ai.Safety[category]
+		if _, ok := llm.SafetyCategories[category]; !ok {
+			issues = append(issues, Issue{Key: "ai.safety." + category, Message: "unknown harm category (want one of " + strings.Join(slices.Sorted(maps.Keys(llm.SafetyCategories)), ", ") + ")"})
+		} else if !slices.Contains(llm.SafetyThresholds, strings.ToLower(threshold)) {
+			issues = append(issues, Issue{Key: "ai.safety." + category, Message: fmt.Sprintf("unknown threshold %q (want one of %s)", threshold, strings.Join(llm.SafetyThresholds, ", "))})
+		}
+	}
+	return issues
+}
+
+// duplicateBindings reports keys bound to more than one action where both can
+// fire: global bindings are checked against everything, pane bindings
+// (tree_, editor_, agent_) only against their own pane.
+func duplicateBindings(fields []tomlField) []Issue {
+	scope := func(tag string) string {
+		for _, prefix := range []string{"tree_", "editor_", "agent_"} {
+			if strings.HasPrefix(tag, prefix) {
+				return prefix
+			}
+		}
+		return ""
+	}
+
+	var issues []Issue
+	for i, a := range fields {
+		for _, b := range fields[i+1:] {
+			if a.value == "" || a.value != b.value {
+				continue
+			}
+			sa, sb := scope(a.tag), scope(b.tag)
+			if sa != "" && sb != "" && sa != sb {
+				continue
+			}
+			issues = append(issues, Issue{
+				Key:     "keys." + b.tag,
+				Message: fmt.Sprintf("%q is also bound to keys.%s", b.value, a.tag),
+			})
+		}
+	}
+	return issues
+}
+
+// ValidKey reports whether name is a key string bubbletea can produce.
+func ValidKey(name string) bool {
+	name = strings.TrimPrefix(name, "alt+")
+	if name == "" {
+		return false
+	}
+	if utf8.RuneCountInString(name) == 1 || namedKeys[name] {
+		return true
+	}
+	if rest, ok := strings.CutPrefix(name, "ctrl+"); ok && len(rest) == 1 && rest[0] >= 'a' && rest[0] <= 'z' {
+		return true
+	}
+	if rest, ok := strings.CutPrefix(name, "f"); ok {
+		if n, err := strconv.Atoi(rest); err == nil && n >= 1 && n <= 20 {
+			return true
+		}
+	}
+	for _, nav := range navKeys {
+		for _, mod := range []string{"", "ctrl+", "shift+", "ctrl+shift+"} {
+			if name == mod+nav {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func validColor(c string) bool {
+	if c == "" || hexColor.MatchString(c) {
+		return true
+	}
+	n, err := strconv.Atoi(c)
+	return err == nil && n >= 0 && n <= 255
+}
+
+type tomlField struct {
+	tag   string
+	value string
+}
+
+// tomlFields lists the string fields of a config section with their TOML names,
+// in declaration order.
+func tomlFields(section any) []tomlField {
+	v := reflect.ValueOf(section)
+	t := v.Type()
+	var fields []tomlField
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Type.Kind() != reflect.String {
+			continue
+		}
+		fields = append(fields, tomlField{tag: t.Field(i).Tag.Get("toml"), value: v.Field(i).String()})
+	}
+	return fields
+}
@@ -0,0 +1,258 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Kind describes how a line differs between the old and new text.
+type Kind int
+
+const (
+	Equal Kind = iota
+	Delete
+	Insert
+)
+
+// Line is a single line of a diff.
+type Line struct {
+	Kind Kind
+	Text string
+}
+
+// Hunk is a contiguous group of changes with surrounding context. Line numbers
+// are 1-based as in unified diff headers.
+type Hunk struct {
+	OldStart int
+	OldLines int
+	NewStart int
+	NewLines int
+	Lines    []Line
+}
+
+// Header returns the unified diff "@@ -a,b +c,d @@" header for the hunk.
+func (h Hunk) Header() string {
+	return fmt.Sprintf("@@ -%d,%d +%d,%d @@", h.OldStart, h.OldLines, h.NewStart, h.NewLines)
+}
+
+// SplitLines splits text into lines without their trailing newline.
+func SplitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// maxEditDistance bounds the Myers search. Texts further apart than this
+// are diffed as the whole changed middle deleted and inserted again, which
+// is as good as any script once so little is shared, and keeps a large
+// external change from taking seconds and gigabytes.
+const maxEditDistance = 1000
+
+// Lines computes the line-by-line edit script turning a into b using
+// Myers' O(ND) algorithm.
+func Lines(a, b []string) []Line {
+	// Trim the common prefix and suffix so the search only covers the changed middle.
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix &&
+		a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	out := make([]Line, 0, len(a)+len(b))
+	for _, l := range a[:prefix] {
+		out = append(out, Line{Equal, l})
+	}
+	out = append(out, myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, l := range a[len(a)-suffix:] {
+		out = append(out, Line{Equal, l})
+	}
+	return out
+}
+
+// replace is the script deleting all of a and inserting all of b.
+func replace(a, b []string) []Line {
+	out := make([]Line, 0, len(a)+len(b))
+	for _, l := range a {
+		out = append(out, Line{Delete, l})
+	}
+	for _, l := range b {
+		out = append(out, Line{Insert, l})
+	}
+	return out
+}
+
+func myers(a, b []string) []Line {
+	n, m := len(a), len(b)
+	if n == 0 || m == 0 {
+		return replace(a, b)
+	}
+
+	max := n + m
+	offset := max + 1
+	v := make([]int, 2*max+3)
+	// trace[d] holds diagonals -d-1 to d+1 of v as step d found them, which
+	// is all the walk back reads, so the trace grows as D² rather than
+	// D·(N+M).
+	var trace [][]int
+
+search:
+	for d := 0; d <= max; d++ {
+		if d > maxEditDistance {
+			return replace(a, b)
+		}
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				break search
+			}
+		}
+	}
+
+	// Walk the trace backwards to recover the edit script.
+	var rev []Line
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && v[d+k] < v[d+k+2]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[d+1+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			rev = append(rev, Line{Equal, a[x-1]})
+			x--
+			y--
+		}
+		if d > 0 {
+			if x == prevX {
+				rev = append(rev, Line{Insert, b[y-1]})
+			} else {
+				rev = append(rev, Line{Delete, a[x-1]})
+			}
+		}
+		x, y = prevX, prevY
+	}
+
+	out := make([]Line, len(rev))
+	for i, l := range rev {
+		out[len(rev)-1-i] = l
+	}
+	return out
+}
+
+// Compute diffs two texts and groups the changes into hunks with the given
+// number of context lines.
+func Compute(oldText, newText string, context int) []Hunk {
+	lines := Lines(SplitLines(oldText), SplitLines(newText))
+
+	// Record the 1-based old/new line number at each position of the script.
+	oldNo := make([]int, len(lines)+1)
+	newNo := make([]int, len(lines)+1)
+	oldNo[0], newNo[0] = 1, 1
+	for i, l := range lines {
+		oldNo[i+1], newNo[i+1] = oldNo[i], newNo[i]
+		if l.Kind != Insert {
+			oldNo[i+1]++
+		}
+		if l.Kind != Delete {
+			newNo[i+1]++
+		}
+	}
+
+	var hunks []Hunk
+	i := 0
+	for i < len(lines) {
+		if lines[i].Kind == Equal {
+			i++
+			continue
+		}
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+		// Extend the hunk while the next change is within 2*context lines.
+		end := i
+		for j := i; j < len(lines); j++ {
+			if lines[j].Kind != Equal {
+				end = j + 1
+			} else if j-end >= 2*context {
+				break
+			}
+		}
+		stop := end + context
+		if stop > len(lines) {
+			stop = len(lines)
+		}
+
+		h := Hunk{
+			OldStart: oldNo[start],
+			NewStart: newNo[start],
+			Lines:    append([]Line(nil), lines[start:stop]...),
+		}
+		for _, l := range h.Lines {
+			if l.Kind != Insert {
+				h.OldLines++
+			}
+			if l.Kind != Delete {
+				h.NewLines++
+			}
+		}
+		if h.OldLines == 0 {
+			h.OldStart--
+		}
+		if h.NewLines == 0 {
+			h.NewStart--
+		}
+		hunks = append(hunks, h)
+		i = stop
+	}
+	return hunks
+}
+
+// Unified renders hunks as a unified diff.
+func Unified(oldName, newName string, hunks []Hunk) string {
+	if len(hunks) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", oldName, newName)
+	for _, h := range hunks {
+		b.WriteString(h.Header() + "\n")
+		for _, l := range h.Lines {
+			b.WriteString(prefix(l.Kind) + l.Text + "\n")
+		}
+	}
+	return b.String()
+}
+
+func prefix(k Kind) string {
+	switch k {
+	case Delete:
+		return "-"
+	case Insert:
+		return "+"
+	default:
+		return " "
+	}
+}
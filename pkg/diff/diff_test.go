@@ -0,0 +1,117 @@
+package diff
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// sides rebuilds the two texts from an edit script, counting its edits.
+func sides(script []Line) (a, b []string, edits int) {
+	for _, l := range script {
+		if l.Kind != Insert {
+			a = append(a, l.Text)
+		}
+		if l.Kind != Delete {
+			b = append(b, l.Text)
+		}
+		if l.Kind != Equal {
+			edits++
+		}
+	}
+	return a, b, edits
+}
+
+func TestLines(t *testing.T) {
+	tests := []struct {
+		a, b  string
+		edits int
+	}{
+		{"", "", 0},
+		{"a b c", "a b c", 0},
+		{"", "a b", 2},
+		{"a b", "", 2},
+		{"x", "y", 2},
+		// The example from Myers' paper.
+		{"a b c a b b a", "c b a b a c", 5},
+		{"a b c d", "a x c d", 2},
+		{"a b c", "a b c d", 1},
+	}
+	for _, tt := range tests {
+		a, b := strings.Fields(tt.a), strings.Fields(tt.b)
+		gotA, gotB, edits := sides(Lines(a, b))
+		if strings.Join(gotA, " ") != tt.a || strings.Join(gotB, " ") != tt.b {
+			t.Errorf("Lines(%q, %q) rebuilds %q and %q", tt.a, tt.b, gotA, gotB)
+		}
+		if edits != tt.edits {
+			t.Errorf("Lines(%q, %q) makes %d edits, want %d", tt.a, tt.b, edits, tt.edits)
+		}
+	}
+}
+
+func numbered(n int, format string) string {
+	var b strings.Builder
+	for i := range n {
+		fmt.Fprintf(&b, format+"\n", i)
+	}
+	return b.String()
+}
+
+func TestLargeChanges(t *testing.T) {
+	// Every other line changed: far enough apart to take the whole-file
+	// fallback.
+	old := numbered(5000, "line %d")
+	var changed strings.Builder
+	for i, l := range SplitLines(old) {
+		if i%2 == 0 {
+			l += " changed"
+		}
+		changed.WriteString(l + "\n")
+	}
+	// One line in fifty changed: within reach of the search.
+	var few strings.Builder
+	for i, l := range SplitLines(old) {
+		if i%50 == 0 {
+			l += " changed"
+		}
+		few.WriteString(l + "\n")
+	}
+
+	tests := []struct {
+		name  string
+		text  string
+		edits int
+	}{
+		{"replaced", numbered(5000, "other %d"), 10000},
+		// The unchanged last line is kept out of the replacement.
+		{"half", changed.String(), 9998},
+		{"few", few.String(), 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var before, after runtime.MemStats
+			runtime.ReadMemStats(&before)
+			hunks := Compute(old, tt.text, 3)
+			runtime.ReadMemStats(&after)
+			if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 64<<20 {
+				t.Errorf("allocated %d MB", alloc>>20)
+			}
+			got, err := Apply(old, hunks)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.text {
+				t.Error("the hunks do not rebuild the new text")
+			}
+			edits := 0
+			for _, h := range hunks {
+				_, _, n := sides(h.Lines)
+				edits += n
+			}
+			if edits != tt.edits {
+				t.Errorf("%d edits, want %d", edits, tt.edits)
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package diff
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// FileDiff is the part of a unified diff that applies to one file.
+type FileDiff struct {
+	OldName string
+	NewName string
+	Hunks   []Hunk
+}
+
+// Parse reads a unified diff, which may cover several files. It is lenient
+// about the hunk line counts, since hand-written and generated diffs often get
+// them wrong: a hunk simply runs until the next header.
+func Parse(unified string) ([]FileDiff, error) {
+	var files []FileDiff
+	var cur *FileDiff
+	var hunk *Hunk
+
+	flush := func() {
+		if hunk != nil && cur != nil {
+			cur.Hunks = append(cur.Hunks, *hunk)
+		}
+		hunk = nil
+	}
+
+	lines := strings.Split(strings.TrimSuffix(unified, "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSuffix(lines[i], "\r")
+		switch {
+		case strings.HasPrefix(line, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ "):
+			flush()
+			files = append(files, FileDiff{
+				OldName: fileName(line[4:]),
+				NewName: fileName(strings.TrimSuffix(lines[i+1], "\r")[4:]),
+			})
+			cur = &files[len(files)-1]
+			i++
+		case strings.HasPrefix(line, "@@"):
+			if cur == nil {
+				return nil, fmt.Errorf("line %d: hunk before file header", i+1)
+			}
+			flush()
+			h, err := parseHeader(line)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", i+1, err)
+			}
+			hunk = &h
+		case hunk == nil:
+			// Preamble such as "diff --git" or "index" lines.
+		case strings.HasPrefix(line, "\\"):
+			// "\ No newline at end of file"
+		case strings.HasPrefix(line, "+"):
+			hunk.Lines = append(hunk.Lines, Line{Insert, line[1:]})
+		case strings.HasPrefix(line, "-"):
+			hunk.Lines = append(hunk.Lines, Line{Delete, line[1:]})
+		case strings.HasPrefix(line, " "):
+			hunk.Lines = append(hunk.Lines, Line{Equal, line[1:]})
+		case line == "":
+			// Editors and models often strip the space from blank context lines.
+			hunk.Lines = append(hunk.Lines, Line{Equal, ""})
+		default:
+			flush()
+		}
+	}
+	flush()
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no file headers found")
+	}
+	for i := range files {
+		for j := range files[i].Hunks {
+			files[i].Hunks[j].count()
+		}
+	}
+	return files, nil
+}
+
+// fileName strips the a/ b/ prefixes and any trailing timestamp from a
+// ---/+++ header name.
+func fileName(s string) string {
+	if i := strings.IndexByte(s, '\t'); i >= 0 {
+		s = s[:i]
+	}
+	s = strings.TrimSpace(s)
+	if s == "/dev/null" {
+		return ""
+	}
+	if strings.HasPrefix(s, "a/") || strings.HasPrefix(s, "b/") {
+		s = s[2:]
+	}
+	return s
+}
+
+func parseHeader(line string) (Hunk, error) {
+	var h Hunk
+	fields := strings.Fields(line)
+	if len(fields) < 3 || !strings.HasPrefix(fields[1], "-") || !strings.HasPrefix(fields[2], "+") {
+		// "@@ ... @@" without ranges: position is found by content alone.
+		return h, nil
+	}
+	var err error
+	if h.OldStart, err = rangeStart(fields[1][1:]); err != nil {
+		return h, err
+	}
+	if h.NewStart, err = rangeStart(fields[2][1:]); err != nil {
+		return h, err
+	}
+	return h, nil
+}
+
+func rangeStart(r string) (int, error) {
+	start, _, _ := strings.Cut(r, ",")
+	n, err := strconv.Atoi(start)
+	if err != nil {
+		return 0, fmt.Errorf("bad hunk range %q", r)
+	}
+	return n, nil
+}
+
+// count recomputes the hunk's line counts from its lines.
+func (h *Hunk) count() {
+	h.OldLines, h.NewLines = 0, 0
+	for _, l := range h.Lines {
+		if l.Kind != Insert {
+			h.OldLines++
+		}
+		if l.Kind != Delete {
+			h.NewLines++
+		}
+	}
+}
+
+// Old returns the lines the hunk expects to find.
+func (h Hunk) Old() []string {
+	var out []string
+	for _, l := range h.Lines {
+		if l.Kind != Insert {
+			out = append(out, l.Text)
+		}
+	}
+	return out
+}
+
+// New returns the lines the hunk replaces them with.
+func (h Hunk) New() []string {
+	var out []string
+	for _, l := range h.Lines {
+		if l.Kind != Delete {
+			out = append(out, l.Text)
+		}
+	}
+	return out
+}
+
+// Apply applies hunks to text in order. Each hunk is matched by content,
+// searching outward from its stated position, so hunks still apply when
+// earlier parts of the file have moved. Lines are compared ignoring trailing
+// whitespace.
+func Apply(text string, hunks []Hunk) (string, error) {
+	lines := SplitLines(text)
+	out := make([]string, 0, len(lines))
+	pos := 0 // next unconsumed line of lines
+	for i, h := range hunks {
+		old := h.Old()
+		hint := h.OldStart - 1
+		if len(old) == 0 {
+			// A pure insertion's start is the line it goes after.
+			hint = h.OldStart
+		}
+		at := find(lines, old, pos, hint)
+		if at < 0 {
+			return "", fmt.Errorf("hunk %d (%s) does not match", i+1, h.Header())
+		}
+		out = append(out, lines[pos:at]...)
+		out = append(out, h.New()...)
+		pos = at + len(old)
+	}
+	out = append(out, lines[pos:]...)
+
+	result := strings.Join(out, "\n")
+	if len(out) > 0 && (text == "" || strings.HasSuffix(text, "\n")) {
+		result += "\n"
+	}
+	return result, nil
+}
+
+// find returns the index at or after from where want occurs in lines, trying
+// the positions closest to hint first, or -1.
+func find(lines, want []string, from, hint int) int {
+	if hint < from {
+		hint = from
+	}
+	last := len(lines) - len(want)
+	for d := 0; hint-d >= from || hint+d <= last; d++ {
+		if at := hint + d; at <= last && matchAt(lines, want, at) {
+			return at
+		}
+		if at := hint - d; d > 0 && at >= from && at <= last && matchAt(lines, want, at) {
+			return at
+		}
+	}
+	return -1
+}
+
+func matchAt(lines, want []string, at int) bool {
+	for i, w := range want {
+		if strings.TrimRight(lines[at+i], " \t") != strings.TrimRight(w, " \t") {
+			return false
+		}
+	}
+	return true
+}
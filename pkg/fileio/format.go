@@ -0,0 +1,220 @@
+package fileio
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// LineEnding identifies how lines are terminated on disk, using Vim's fileformat names.
+type LineEnding string
+
+const (
+	LineEndingUnix LineEnding = "unix"
+	LineEndingDOS  LineEnding = "dos"
+	LineEndingMac  LineEnding = "mac"
+)
+
+// Encoding identifies the character encoding of a file, using Vim's fileencoding names.
+type Encoding string
+
+const (
+	EncodingUTF8    Encoding = "utf-8"
+	EncodingUTF16LE Encoding = "utf-16le"
+	EncodingUTF16BE Encoding = "utf-16be"
+	EncodingLatin1  Encoding = "latin1"
+)
+
+var (
+	bomUTF8    = []byte{0xEF, 0xBB, 0xBF}
+	bomUTF16LE = []byte{0xFF, 0xFE}
+	bomUTF16BE = []byte{0xFE, 0xFF}
+)
+
+// Format describes the on-disk representation of a buffer so it can be round-tripped on save.
+type Format struct {
+	Encoding   Encoding
+	LineEnding LineEnding
+	BOM        bool
+	// Mixed reports that the file ended its lines in more than one way. They
+	// are all read as "\n", so saving writes every line with LineEnding.
+	Mixed bool
+}
+
+// DefaultFormat returns the format used for new buffers.
+func DefaultFormat() Format {
+	return Format{Encoding: EncodingUTF8, LineEnding: LineEndingUnix}
+}
+
+// String returns a short status-line label such as "utf-8[BOM] dos".
+func (f Format) String() string {
+	enc := string(f.Encoding)
+	if f.BOM {
+		enc += "[BOM]"
+	}
+	return enc + " " + string(f.LineEnding)
+}
+
+// ParseLineEnding parses a fileformat name.
+func ParseLineEnding(name string) (LineEnding, error) {
+	switch LineEnding(strings.ToLower(name)) {
+	case LineEndingUnix:
+		return LineEndingUnix, nil
+	case LineEndingDOS:
+		return LineEndingDOS, nil
+	case LineEndingMac:
+		return LineEndingMac, nil
+	}
+	return "", fmt.Errorf("unknown fileformat %q (want unix, dos or mac)", name)
+}
+
+// ParseEncoding parses a fileencoding name, accepting common aliases.
+func ParseEncoding(name string) (Encoding, error) {
+	switch strings.ToLower(name) {
+	case "utf-8", "utf8":
+		return EncodingUTF8, nil
+	case "utf-16le", "utf16le", "ucs-2le":
+		return EncodingUTF16LE, nil
+	case "utf-16be", "utf16be", "utf-16", "ucs-2":
+		return EncodingUTF16BE, nil
+	case "latin1", "latin-1", "iso-8859-1", "iso8859-1":
+		return EncodingLatin1, nil
+	}
+	return "", fmt.Errorf("unknown fileencoding %q", name)
+}
+
+// Decode detects the encoding, BOM and line endings of raw file contents and
+// returns the text normalised to UTF-8 with "\n" line endings. Every line
+// terminator is normalised, not only the detected one, since a stray "\r"
+// cannot be kept in the buffer; Format.Mixed says when that happened.
+func Decode(data []byte) (string, Format, error) {
+	format := DefaultFormat()
+
+	switch {
+	case bytes.HasPrefix(data, bomUTF8):
+		format.BOM = true
+		data = data[len(bomUTF8):]
+	case bytes.HasPrefix(data, bomUTF16LE):
+		format.Encoding = EncodingUTF16LE
+		format.BOM = true
+		data = data[len(bomUTF16LE):]
+	case bytes.HasPrefix(data, bomUTF16BE):
+		format.Encoding = EncodingUTF16BE
+		format.BOM = true
+		data = data[len(bomUTF16BE):]
+	case !utf8.Valid(data):
+		format.Encoding = EncodingLatin1
+	}
+
+	var text string
+	if format.Encoding == EncodingUTF8 {
+		text = string(data)
+	} else {
+		decoded, err := codec(format.Encoding).NewDecoder().Bytes(data)
+		if err != nil {
+			return "", format, fmt.Errorf("decoding %s: %w", format.Encoding, err)
+		}
+		text = string(decoded)
+	}
+
+	format.LineEnding = detectLineEnding(text)
+	format.Mixed = mixedLineEndings(text)
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+	return text, format, nil
+}
+
+// TruncateUTF8 cuts s to at most n bytes without splitting a character.
+func TruncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for i := n; i > 0 && i > n-utf8.UTFMax; i-- {
+		if utf8.RuneStart(s[i]) {
+			return s[:i]
+		}
+	}
+	return s[:n]
+}
+
+// Encode converts editor text back to its on-disk representation.
+func Encode(text string, format Format) ([]byte, error) {
+	switch format.LineEnding {
+	case LineEndingDOS:
+		text = strings.ReplaceAll(text, "\n", "\r\n")
+	case LineEndingMac:
+		text = strings.ReplaceAll(text, "\n", "\r")
+	}
+
+	var buf bytes.Buffer
+	if format.BOM {
+		switch format.Encoding {
+		case EncodingUTF8:
+			buf.Write(bomUTF8)
+		case EncodingUTF16LE:
+			buf.Write(bomUTF16LE)
+		case EncodingUTF16BE:
+			buf.Write(bomUTF16BE)
+		}
+	}
+
+	if format.Encoding == EncodingUTF8 || format.Encoding == "" {
+		buf.WriteString(text)
+		return buf.Bytes(), nil
+	}
+
+	encoded, err := codec(format.Encoding).NewEncoder().String(text)
+	if err != nil {
+		return nil, fmt.Errorf("text cannot be encoded as %s: %w", format.Encoding, err)
+	}
+	buf.WriteString(encoded)
+	return buf.Bytes(), nil
+}
+
+// codec returns the x/text encoding for a non-UTF-8 file encoding.
+func codec(enc Encoding) encoding.Encoding {
+	switch enc {
+	case EncodingUTF16LE:
+		return unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM)
+	case EncodingUTF16BE:
+		return unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM)
+	case EncodingLatin1:
+		return charmap.ISO8859_1
+	default:
+		return unicode.UTF8
+	}
+}
+
+// detectLineEnding picks the dominant line terminator, defaulting to unix.
+func detectLineEnding(text string) LineEnding {
+	crlf := strings.Count(text, "\r\n")
+	lf := strings.Count(text, "\n") - crlf
+	cr := strings.Count(text, "\r") - crlf
+	switch {
+	case crlf > 0 && crlf >= lf && crlf >= cr:
+		return LineEndingDOS
+	case cr > 0 && lf == 0 && crlf == 0:
+		return LineEndingMac
+	default:
+		return LineEndingUnix
+	}
+}
+
+// mixedLineEndings reports whether text uses more than one line terminator.
+func mixedLineEndings(text string) bool {
+	crlf := strings.Count(text, "\r\n")
+	lf := strings.Count(text, "\n") - crlf
+	cr := strings.Count(text, "\r") - crlf
+	kinds := 0
+	for _, n := range []int{crlf, lf, cr} {
+		if n > 0 {
+			kinds++
+		}
+	}
+	return kinds > 1
+}
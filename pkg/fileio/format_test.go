@@ -0,0 +1,43 @@
+package fileio
+
+import "testing"
+
+func TestDecodeLineEndings(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		text   string
+		ending LineEnding
+		mixed  bool
+	}{
+		{"unix", "a\nb\n", "a\nb\n", LineEndingUnix, false},
+		{"dos", "a\r\nb\r\n", "a\nb\n", LineEndingDOS, false},
+		{"mac", "a\rb\r", "a\nb\n", LineEndingMac, false},
+		{"unix with a stray cr", "a\nb\rc\n", "a\nb\nc\n", LineEndingUnix, true},
+		{"dos with a stray cr", "a\r\nb\rc\r\n", "a\nb\nc\n", LineEndingDOS, true},
+		{"dos with a stray lf", "a\r\nb\nc\r\n", "a\nb\nc\n", LineEndingDOS, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, format, err := Decode([]byte(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if text != tt.text || format.LineEnding != tt.ending || format.Mixed != tt.mixed {
+				t.Errorf("Decode(%q) = %q, %s, mixed %v; want %q, %s, mixed %v",
+					tt.data, text, format.LineEnding, format.Mixed, tt.text, tt.ending, tt.mixed)
+			}
+		})
+	}
+}
+
+func TestMixedEndingsSaveAsOne(t *testing.T) {
+	text, format, _ := Decode([]byte("a\r\nb\rc\r\n"))
+	data, err := Encode(text, format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "a\r\nb\r\nc\r\n" {
+		t.Errorf("saved %q, want every line ended by \\r\\n", data)
+	}
+}
@@ -0,0 +1,28 @@
+//go:build !windows
+
+package fileio
+
+import (
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+// copyOwner gives f the uid and gid recorded in info.
+func copyOwner(f *os.File, info fs.FileInfo) error {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	return f.Chown(int(st.Uid), int(st.Gid))
+}
+
+// syncDir flushes the directory entry so a rename survives a crash.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
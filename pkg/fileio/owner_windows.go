@@ -0,0 +1,16 @@
+//go:build windows
+
+package fileio
+
+import (
+	"io/fs"
+	"os"
+)
+
+// copyOwner is a no-op on Windows, where ownership is not carried by mode bits.
+func copyOwner(f *os.File, info fs.FileInfo) error {
+	return nil
+}
+
+// syncDir is a no-op on Windows, which cannot open directories for syncing.
+func syncDir(dir string) {}
@@ -0,0 +1,149 @@
+package fileio
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SaveOptions controls how Save treats the file being replaced.
+type SaveOptions struct {
+	// Backup keeps a copy of the previous contents before overwriting.
+	Backup bool
+	// BackupDir stores backups in this directory instead of next to the file.
+	BackupDir string
+}
+
+// Save atomically replaces path with data. The data is written to a temporary
+// file in the same directory, synced and renamed over the original, so a crash
+// never leaves a truncated file behind. Symlinks are followed and the mode and
+// ownership of an existing file are preserved.
+func Save(path string, data []byte, opts SaveOptions) error {
+	target, err := resolveTarget(path)
+	if err != nil {
+		return err
+	}
+
+	mode := fs.FileMode(0644)
+	info, err := os.Stat(target)
+	exists := err == nil
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if exists {
+		mode = info.Mode().Perm()
+		if opts.Backup {
+			if err := backup(target, opts.BackupDir); err != nil {
+				return fmt.Errorf("backup failed: %w", err)
+			}
+		}
+	}
+
+	dir := filepath.Dir(target)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(target)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	committed := false
+	defer func() {
+		if !committed {
+			tmp.Close()
+			os.Remove(tmpName)
+		}
+	}()
+
+	if _, err := tmp.Write(data); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		return err
+	}
+	if exists {
+		// Ownership can only be kept when running with enough privilege; a
+		// failure here is not worth losing the save over.
+		_ = copyOwner(tmp, info)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpName, target); err != nil {
+		return err
+	}
+	committed = true
+	syncDir(dir)
+	return nil
+}
+
+// resolveTarget follows symlinks so the link itself is left in place and the
+// file it points to is replaced.
+func resolveTarget(path string) (string, error) {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err == nil {
+		return resolved, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+	// A dangling symlink is written through to its target.
+	if link, linkErr := os.Readlink(path); linkErr == nil {
+		if !filepath.IsAbs(link) {
+			link = filepath.Join(filepath.Dir(path), link)
+		}
+		return link, nil
+	}
+	return path, nil
+}
+
+// backup copies path to "path~", or into dir with the full path encoded in
+// the name (Vim-style) so files with the same base name do not collide.
+func backup(path string, dir string) error {
+	dest := path + "~"
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		name, err := EncodePath(path)
+		if err != nil {
+			return err
+		}
+		dest = filepath.Join(dir, name+"~")
+	}
+
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	dst, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+// EncodePath flattens the absolute form of path into a single file name by
+// replacing separators with '%', as Vim does for backup and swap files.
+func EncodePath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.NewReplacer(string(filepath.Separator), "%", ":", "%").Replace(abs), nil
+}
@@ -0,0 +1,49 @@
+package fileio
+
+import (
+	"crypto/sha256"
+	"os"
+	"time"
+)
+
+// Stamp identifies a version of a file on disk so external changes can be detected.
+type Stamp struct {
+	ModTime time.Time
+	Size    int64
+	Hash    [sha256.Size]byte
+}
+
+// IsZero reports whether the stamp was never taken, e.g. for a new file.
+func (s Stamp) IsZero() bool {
+	return s.ModTime.IsZero() && s.Size == 0
+}
+
+// StampFile reads the current stamp of path.
+func StampFile(path string) (Stamp, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return Stamp{}, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Stamp{}, err
+	}
+	return Stamp{ModTime: info.ModTime(), Size: info.Size(), Hash: sha256.Sum256(data)}, nil
+}
+
+// Changed reports whether the file at path no longer matches s. Files whose
+// mtime was touched without altering the contents are not considered changed.
+func (s Stamp) Changed(path string) (bool, Stamp, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, Stamp{}, err
+	}
+	if info.ModTime().Equal(s.ModTime) && info.Size() == s.Size {
+		return false, s, nil
+	}
+	cur, err := StampFile(path)
+	if err != nil {
+		return false, Stamp{}, err
+	}
+	return cur.Hash != s.Hash, cur, nil
+}
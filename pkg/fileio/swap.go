@@ -0,0 +1,138 @@
+package fileio
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const swapExt = ".swp"
+
+// Swap is a journal of an unsaved buffer, written periodically so work can be
+// recovered after a crash.
+type Swap struct {
+	Path     string    `json:"path"`
+	PID      int       `json:"pid"`
+	Modified time.Time `json:"modified"`
+	Content  string    `json:"content"`
+}
+
+// SwapDir returns the directory swap files are kept in.
+func SwapDir() (string, error) {
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cache, "boba-text", "swap"), nil
+}
+
+// swapPath returns the swap file location for the file at path.
+func swapPath(path string) (string, error) {
+	dir, err := SwapDir()
+	if err != nil {
+		return "", err
+	}
+	name, err := EncodePath(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name+swapExt), nil
+}
+
+// WriteSwap records the buffer contents for path.
+func WriteSwap(path string, content string) error {
+	dest, err := swapPath(path)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0700); err != nil {
+		return err
+	}
+	abs, _ := filepath.Abs(path)
+	data, err := json.Marshal(Swap{
+		Path:     abs,
+		PID:      os.Getpid(),
+		Modified: time.Now(),
+		Content:  content,
+	})
+	if err != nil {
+		return err
+	}
+
+	// Write beside the destination and rename so a crash mid-write leaves the
+	// previous journal intact.
+	tmp := dest + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, dest)
+}
+
+// ReadSwap returns the swap recorded for path, or fs.ErrNotExist if there is none.
+func ReadSwap(path string) (Swap, error) {
+	src, err := swapPath(path)
+	if err != nil {
+		return Swap{}, err
+	}
+	return readSwapFile(src)
+}
+
+// RemoveSwap deletes the swap for path. A missing swap is not an error.
+func RemoveSwap(path string) error {
+	src, err := swapPath(path)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(src); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// ListSwaps returns every recoverable swap, most recently modified first.
+func ListSwaps() ([]Swap, error) {
+	dir, err := SwapDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var swaps []Swap
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), swapExt) {
+			continue
+		}
+		s, err := readSwapFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			continue
+		}
+		swaps = append(swaps, s)
+	}
+	sort.Slice(swaps, func(i, j int) bool {
+		return swaps[i].Modified.After(swaps[j].Modified)
+	})
+	return swaps, nil
+}
+
+func readSwapFile(path string) (Swap, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Swap{}, err
+	}
+	var s Swap
+	if err := json.Unmarshal(data, &s); err != nil {
+		return Swap{}, err
+	}
+	return s, nil
+}
@@ -0,0 +1,238 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+const anthropicVersion = "2023-06-01"
+
+type anthropic struct {
+	cfg Config
+}
+
+func newAnthropic(cfg Config) *anthropic {
+	cfg.BaseURL = orDefault(cfg.BaseURL, "https://api.anthropic.com")
+	cfg.Model = orDefault(cfg.Model, "claude-3-5-sonnet-latest")
+	return &anthropic{cfg: cfg}
+}
+
+func (a *anthropic) Name() string { return "Anthropic" }
+
+type anthropicMessage struct {
+	Role string `json:"role"`
+	// Content is a plain string, or a list of blocks when tools are involved.
+	Content any `json:"content"`
+}
+
+// anthropicBlock is a content block: text, tool_use or tool_result.
+type anthropicBlock struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text,omitempty"`
+	ID        string          `json:"id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	ToolUseID string          `json:"tool_use_id,omitempty"`
+	Content   string          `json:"content,omitempty"`
+}
+
+type anthropicTool struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	InputSchema map[string]any `json:"input_schema"`
+}
+
+type anthropicRequest struct {
+	Model         string             `json:"model"`
+	MaxTokens     int                `json:"max_tokens"`
+	System        string             `json:"system,omitempty"`
+	Messages      []anthropicMessage `json:"messages"`
+	Tools         []anthropicTool    `json:"tools,omitempty"`
+	Stream        bool               `json:"stream,omitempty"`
+	Temperature   *float64           `json:"temperature,omitempty"`
+	TopP          *float64           `json:"top_p,omitempty"`
+	StopSequences []string           `json:"stop_sequences,omitempty"`
+}
+
+// anthropicEvent is the data of a streamed server-sent event.
+type anthropicEvent struct {
+	Type         string         `json:"type"`
+	Index        int            `json:"index"`
+	ContentBlock anthropicBlock `json:"content_block"`
+	Delta        struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		PartialJSON string `json:"partial_json"`
+		// StopReason ends the reply in a message_delta event.
+		StopReason string `json:"stop_reason"`
+	} `json:"delta"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type anthropicResponse struct {
+	Content    []anthropicBlock `json:"content"`
+	StopReason string           `json:"stop_reason"`
+	Error      *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (a *anthropic) request(req Request) anthropicRequest {
+	gen := req.Generation
+	body := anthropicRequest{
+		Model:         a.cfg.Model,
+		MaxTokens:     4096, // required by the API
+		System:        req.System,
+		Temperature:   gen.Temperature,
+		TopP:          gen.TopP,
+		StopSequences: gen.Stop,
+	}
+	if gen.MaxOutputTokens > 0 {
+		body.MaxTokens = gen.MaxOutputTokens
+	}
+	for _, m := range req.Messages {
+		switch {
+		case m.Role == RoleTool:
+			block := anthropicBlock{Type: "tool_result", ToolUseID: m.ToolCallID, Content: m.Content}
+			// Results of parallel calls go together in one user turn.
+			if n := len(body.Messages); n > 0 {
+				if blocks, ok := body.Messages[n-1].Content.([]anthropicBlock); ok && blocks[0].Type == "tool_result" {
+					body.Messages[n-1].Content = append(blocks, block)
+					continue
+				}
+			}
+			body.Messages = append(body.Messages, anthropicMessage{Role: "user", Content: []anthropicBlock{block}})
+		case len(m.ToolCalls) > 0:
+			var blocks []anthropicBlock
+			if m.Content != "" {
+				blocks = append(blocks, anthropicBlock{Type: "text", Text: m.Content})
+			}
+			for _, call := range m.ToolCalls {
+				blocks = append(blocks, anthropicBlock{Type: "tool_use", ID: call.ID, Name: call.Name, Input: argsOrEmpty(call.Args)})
+			}
+			body.Messages = append(body.Messages, anthropicMessage{Role: string(m.Role), Content: blocks})
+		default:
+			// A prompt following tool results, as when a turn stopped at the
+			// step limit, joins their user turn.
+			if n := len(body.Messages); n > 0 && m.Role == RoleUser {
+				if blocks, ok := body.Messages[n-1].Content.([]anthropicBlock); ok && blocks[0].Type == "tool_result" {
+					body.Messages[n-1].Content = append(blocks, anthropicBlock{Type: "text", Text: m.Content})
+					continue
+				}
+			}
+			body.Messages = append(body.Messages, anthropicMessage{Role: string(m.Role), Content: m.Content})
+		}
+	}
+	for _, t := range req.Tools {
+		body.Tools = append(body.Tools, anthropicTool{Name: t.Name, Description: t.Description, InputSchema: t.Parameters})
+	}
+	return body
+}
+
+func (a *anthropic) headers() map[string]string {
+	return map[string]string{
+		"x-api-key":         a.cfg.APIKey,
+		"anthropic-version": anthropicVersion,
+	}
+}
+
+// anthropicRefusal is the stop reason of a reply the model's safety
+// classifiers declined to give.
+const anthropicRefusal = "refusal"
+
+func (a *anthropic) Complete(ctx context.Context, req Request) (Response, error) {
+	respBytes, err := postJSON(ctx, a.cfg.HTTPClient, a.Name(), a.cfg.BaseURL+"/v1/messages",
+		a.headers(), a.request(req), anthropicError)
+	if err != nil {
+		return Response{}, err
+	}
+
+	var resp anthropicResponse
+	if err := json.Unmarshal(respBytes, &resp); err != nil {
+		return Response{}, fmt.Errorf("failed to parse response: %w", err)
+	}
+	if resp.Error != nil {
+		return Response{}, fmt.Errorf("Anthropic API error: %s", resp.Error.Message)
+	}
+
+	var text string
+	var calls []ToolCall
+	for _, block := range resp.Content {
+		switch block.Type {
+		case "text":
+			text += block.Text
+		case "tool_use":
+			calls = append(calls, ToolCall{ID: block.ID, Name: block.Name, Args: argsOrEmpty(block.Input)})
+		}
+	}
+	if resp.StopReason == anthropicRefusal {
+		return Response{Text: text, ToolCalls: calls}, &BlockedError{Provider: a.Name(), Reason: resp.StopReason}
+	}
+	if text == "" && len(calls) == 0 {
+		return Response{}, fmt.Errorf("empty response from Anthropic")
+	}
+	return Response{Text: text, ToolCalls: calls}, nil
+}
+
+func (a *anthropic) Stream(ctx context.Context, req Request, onDelta func(string)) (Response, error) {
+	body := a.request(req)
+	body.Stream = true
+	resp, err := post(ctx, a.cfg.HTTPClient, a.Name(), a.cfg.BaseURL+"/v1/messages",
+		a.headers(), body, anthropicError)
+	if err != nil {
+		return Response{}, err
+	}
+	defer resp.Body.Close()
+
+	w := &deltaWriter{onDelta: onDelta}
+	var calls toolCallBuilder
+	var blocked bool
+	err = readSSE(resp.Body, func(_, data string) error {
+		var ev anthropicEvent
+		if err := json.Unmarshal([]byte(data), &ev); err != nil {
+			return fmt.Errorf("failed to parse response: %w", err)
+		}
+		switch ev.Type {
+		case "content_block_start":
+			if ev.ContentBlock.Type == "tool_use" {
+				calls.start(ev.Index, ev.ContentBlock.ID, ev.ContentBlock.Name)
+			}
+		case "content_block_delta":
+			switch ev.Delta.Type {
+			case "text_delta":
+				w.write(ev.Delta.Text)
+			case "input_json_delta":
+				calls.appendArgs(ev.Index, ev.Delta.PartialJSON)
+			}
+		case "message_delta":
+			blocked = ev.Delta.StopReason == anthropicRefusal
+		case "error":
+			msg := "stream error"
+			if ev.Error != nil {
+				msg = ev.Error.Message
+			}
+			return fmt.Errorf("Anthropic API error: %s", msg)
+		}
+		return nil
+	})
+	text := w.finish()
+	toolCalls := calls.result()
+	if err == nil && blocked {
+		err = &BlockedError{Provider: a.Name(), Reason: anthropicRefusal}
+	}
+	if err == nil && text == "" && len(toolCalls) == 0 {
+		err = fmt.Errorf("empty response from Anthropic")
+	}
+	return Response{Text: text, ToolCalls: toolCalls}, err
+}
+
+func anthropicError(body []byte) string {
+	var resp anthropicResponse
+	if json.Unmarshal(body, &resp) == nil && resp.Error != nil {
+		return resp.Error.Message
+	}
+	return string(body)
+}
@@ -0,0 +1,206 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/CiaranMccarthy1/boba-text/pkg/fileio"
+)
+
+// Attachment is a piece of context, such as a file, sent along with a turn.
+type Attachment struct {
+	Name    string `json:"name"`
+	Content string `json:"content"`
+	// Label says which part of the file Content is, e.g. "lines 10-20";
+	// empty means the whole file.
+	Label string `json:"label,omitempty"`
+	// Mentioned is set for files the user pointed at with @, as opposed to
+	// the one being edited.
+	Mentioned bool `json:"mentioned,omitempty"`
+	// Omitted is set when the content was dropped to fit the token budget.
+	Omitted bool `json:"omitted,omitempty"`
+}
+
+// Turn is one structured entry in a conversation.
+type Turn struct {
+	Role        Role         `json:"role"`
+	Text        string       `json:"text"`
+	Attachments []Attachment `json:"attachments,omitempty"`
+	// ToolCalls are the tools an assistant turn asked to run.
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	// ToolCallID and ToolName identify the call a RoleTool turn answers.
+	ToolCallID string `json:"tool_call_id,omitempty"`
+	ToolName   string `json:"tool_name,omitempty"`
+}
+
+// Conversation is a chat history. Turns that no longer fit the token budget
+// are folded into Summary.
+type Conversation struct {
+	Summary string `json:"summary,omitempty"`
+	Turns   []Turn `json:"turns"`
+}
+
+// KeepTurns is how many of the most recent turns Compact always keeps verbatim.
+const KeepTurns = 4
+
+// maxSummarisedResult caps how much of each tool result is sent to be summarised.
+const maxSummarisedResult = 2000
+
+// EstimateTokens roughly counts the tokens in s, at about four bytes a token.
+func EstimateTokens(s string) int {
+	return (len(s) + 3) / 4
+}
+
+// Add appends a turn.
+func (c *Conversation) Add(t Turn) {
+	c.Turns = append(c.Turns, t)
+}
+
+// Reset forgets the whole conversation.
+func (c *Conversation) Reset() {
+	c.Summary = ""
+	c.Turns = nil
+}
+
+// Clone returns a copy that shares no slices with c.
+func (c Conversation) Clone() Conversation {
+	c.Turns = append([]Turn(nil), c.Turns...)
+	return c
+}
+
+// Tokens estimates the size of the conversation as Request would send it.
+// A nil count uses EstimateTokens.
+func (c Conversation) Tokens(count Tokenizer) int {
+	return RequestTokens(c.Request(""), count)
+}
+
+// Request builds a provider request from the conversation. Attachments are
+// sent in full only with the latest user turn; earlier turns just name them,
+// since the model has already seen them and they are usually stale by now.
+func (c Conversation) Request(system string) Request {
+	req := Request{System: system}
+	if c.Summary != "" {
+		if req.System != "" {
+			req.System += "\n\n"
+		}
+		req.System += "Summary of the earlier conversation:\n" + c.Summary
+	}
+	latest := -1
+	for i, t := range c.Turns {
+		if t.Role == RoleUser {
+			latest = i
+		}
+	}
+	for i, t := range c.Turns {
+		req.Messages = append(req.Messages, Message{
+			Role:       t.Role,
+			Content:    t.content(i == latest),
+			ToolCalls:  t.ToolCalls,
+			ToolCallID: t.ToolCallID,
+			ToolName:   t.ToolName,
+		})
+	}
+	return req
+}
+
+func (t Turn) content(full bool) string {
+	if len(t.Attachments) == 0 {
+		return t.Text
+	}
+	var b strings.Builder
+	for _, a := range t.Attachments {
+		switch {
+		case full && a.Omitted:
+			fmt.Fprintf(&b, "The file '%s' was attached but left out to stay within the token budget.\n\n", a.Name)
+		case a.Omitted:
+			fmt.Fprintf(&b, "[left out: %s]\n", a.Name)
+		case full && a.Mentioned && a.Label != "":
+			fmt.Fprintf(&b, "The user attached '%s' (%s):\n```\n%s\n```\n\n", a.Name, a.Label, a.Content)
+		case full && a.Mentioned:
+			fmt.Fprintf(&b, "The user attached the file '%s':\n```\n%s\n```\n\n", a.Name, a.Content)
+		case full && a.Label != "":
+			fmt.Fprintf(&b, "From the file '%s' the user is editing, %s:\n```\n%s\n```\n\n", a.Name, a.Label, a.Content)
+		case full:
+			fmt.Fprintf(&b, "The user is editing the file '%s' with this content:\n```\n%s\n```\n\n", a.Name, a.Content)
+		case a.Label != "":
+			fmt.Fprintf(&b, "[attached: %s, %s]\n", a.Name, a.Label)
+		default:
+			fmt.Fprintf(&b, "[attached: %s]\n", a.Name)
+		}
+	}
+	if full {
+		b.WriteString("User request: ")
+	}
+	b.WriteString(t.Text)
+	return b.String()
+}
+
+// Compact keeps the conversation within budget tokens, as measured by count,
+// by asking p to summarise the oldest turns, always keeping the last
+// KeepTurns verbatim. It returns the number of turns folded into the
+// summary. If summarising fails the old turns are dropped anyway and the
+// error is returned alongside.
+func Compact(ctx context.Context, p Provider, c Conversation, budget int, count Tokenizer) (Conversation, int, error) {
+	if budget <= 0 || c.Tokens(count) <= budget || len(c.Turns) <= KeepTurns {
+		return c, 0, nil
+	}
+
+	// Fold turns, oldest first, until the rest fits or only KeepTurns remain.
+	cut := 0
+	for cut < len(c.Turns)-KeepTurns {
+		cut++
+		if (Conversation{Summary: c.Summary, Turns: c.Turns[cut:]}).Tokens(count) <= budget {
+			break
+		}
+	}
+	// Start the kept history on a user turn, as some providers require. If
+	// there is none after the cut, the latest prompt is still being answered
+	// with tool calls, and it stays with them.
+	next := cut
+	for next < len(c.Turns) && c.Turns[next].Role != RoleUser {
+		next++
+	}
+	if next == len(c.Turns) {
+		next = cut
+		for next > 0 && c.Turns[next].Role != RoleUser {
+			next--
+		}
+	}
+	if cut = next; cut == 0 {
+		return c, 0, nil
+	}
+
+	var transcript strings.Builder
+	if c.Summary != "" {
+		transcript.WriteString("Earlier summary:\n" + c.Summary + "\n\n")
+	}
+	for _, t := range c.Turns[:cut] {
+		switch {
+		case t.Role == RoleTool:
+			text := t.Text
+			if len(text) > maxSummarisedResult {
+				text = fileio.TruncateUTF8(text, maxSummarisedResult) + " [...]"
+			}
+			fmt.Fprintf(&transcript, "tool %s returned: %s\n\n", t.ToolName, text)
+		default:
+			fmt.Fprintf(&transcript, "%s: %s\n\n", t.Role, t.content(false))
+			for _, call := range t.ToolCalls {
+				fmt.Fprintf(&transcript, "%s called %s(%s)\n\n", t.Role, call.Name, call.Args)
+			}
+		}
+	}
+
+	out := Conversation{Turns: append([]Turn(nil), c.Turns[cut:]...)}
+	resp, err := p.Complete(ctx, Request{
+		System: "Summarise this conversation between a user and a coding assistant in a few short paragraphs. " +
+			"Keep file names, decisions, code identifiers and open questions; drop pleasantries.",
+		Messages: []Message{{Role: RoleUser, Content: transcript.String()}},
+	})
+	if err != nil {
+		out.Summary = c.Summary
+		return out, cut, fmt.Errorf("summarising history: %w", err)
+	}
+	out.Summary = strings.TrimSpace(resp.Text)
+	return out, cut, nil
+}
@@ -0,0 +1,51 @@
+package llm
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// recorder is a Provider that remembers the last request and answers "ok".
+type recorder struct {
+	req Request
+}
+
+func (r *recorder) Name() string { return "recorder" }
+
+func (r *recorder) Complete(_ context.Context, req Request) (Response, error) {
+	r.req = req
+	return Response{Text: "ok"}, nil
+}
+
+func (r *recorder) Stream(ctx context.Context, req Request, _ func(string)) (Response, error) {
+	return r.Complete(ctx, req)
+}
+
+func TestCompactCutsToolResultsOnCharacters(t *testing.T) {
+	// A two-byte character straddles the cut.
+	result := "x" + strings.Repeat("é", maxSummarisedResult)
+	c := Conversation{Turns: []Turn{
+		{Role: RoleUser, Text: "read it"},
+		{Role: RoleAssistant, ToolCalls: []ToolCall{{ID: "1", Name: "read_file"}}},
+		{Role: RoleTool, Text: result, ToolCallID: "1", ToolName: "read_file"},
+		{Role: RoleAssistant, Text: "done"},
+	}}
+	for range KeepTurns {
+		c.Turns = append(c.Turns, Turn{Role: RoleUser, Text: "more"}, Turn{Role: RoleAssistant, Text: "sure"})
+	}
+
+	p := &recorder{}
+	out, cut, err := Compact(context.Background(), p, c, 50, EstimateTokens)
+	if err != nil || cut != 4 || out.Summary != "ok" {
+		t.Fatalf("Compact = %d turns folded, summary %q, %v", cut, out.Summary, err)
+	}
+	transcript := p.req.Messages[0].Content
+	if !utf8.ValidString(transcript) {
+		t.Error("the transcript is not valid UTF-8")
+	}
+	if !strings.Contains(transcript, "é [...]") {
+		t.Error("the tool result was not cut short")
+	}
+}
@@ -0,0 +1,74 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ErrorKind is the broad cause of a failed request, for choosing what to
+// tell the user.
+type ErrorKind int
+
+const (
+	ErrOther ErrorKind = iota
+	// ErrAuth is a missing, wrong or unauthorised API key.
+	ErrAuth
+	// ErrQuota is a rate limit or exhausted quota.
+	ErrQuota
+	// ErrBlocked is a prompt or reply stopped by the provider's safety filters.
+	ErrBlocked
+	// ErrNetwork is a failure to reach the provider, or a connection lost
+	// part way through a reply.
+	ErrNetwork
+	// ErrServer is a 5xx error from the provider.
+	ErrServer
+)
+
+// BlockedError reports a prompt or reply blocked by safety filters.
+type BlockedError struct {
+	Provider string
+	// Reason is the provider's reason, such as "SAFETY".
+	Reason string
+}
+
+func (e *BlockedError) Error() string {
+	return fmt.Sprintf("%s blocked the response (%s)", e.Provider, e.Reason)
+}
+
+var errNoRewind = errors.New("cannot retry a request whose body cannot be replayed")
+
+// Classify says what kind of failure err is.
+func Classify(err error) ErrorKind {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return ErrOther
+	}
+	var blocked *BlockedError
+	if errors.As(err, &blocked) {
+		return ErrBlocked
+	}
+	var api *APIError
+	if errors.As(err, &api) {
+		switch {
+		case api.Status == http.StatusUnauthorized || api.Status == http.StatusForbidden:
+			return ErrAuth
+		// Gemini answers a bad key with 400 INVALID_ARGUMENT.
+		case api.Status == http.StatusBadRequest && strings.Contains(strings.ToLower(api.Message), "api key"):
+			return ErrAuth
+		case api.Status == http.StatusTooManyRequests:
+			return ErrQuota
+		case api.Status >= 500:
+			return ErrServer
+		}
+		return ErrOther
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, context.DeadlineExceeded) {
+		return ErrNetwork
+	}
+	return ErrOther
+}
@@ -0,0 +1,41 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want ErrorKind
+	}{
+		{"nil", nil, ErrOther},
+		{"cancelled", fmt.Errorf("request: %w", context.Canceled), ErrOther},
+		{"blocked", fmt.Errorf("stream: %w", &BlockedError{Provider: "Gemini", Reason: "SAFETY"}), ErrBlocked},
+		{"401", &APIError{Status: 401}, ErrAuth},
+		{"403", &APIError{Status: 403}, ErrAuth},
+		{"400 bad key", &APIError{Status: 400, Message: "API key not valid. Please pass a valid API key."}, ErrAuth},
+		{"400", &APIError{Status: 400, Message: "invalid model"}, ErrOther},
+		{"404", &APIError{Status: 404}, ErrOther},
+		{"429", &APIError{Status: 429}, ErrQuota},
+		{"500", &APIError{Status: 500}, ErrServer},
+		{"529", fmt.Errorf("wrapped: %w", &APIError{Status: 529}), ErrServer},
+		{"net.Error", &net.DNSError{Err: "no such host", Name: "example.invalid"}, ErrNetwork},
+		{"cut off", fmt.Errorf("reading stream: %w", io.ErrUnexpectedEOF), ErrNetwork},
+		{"timeout", context.DeadlineExceeded, ErrNetwork},
+		{"other", errors.New("failed to parse response"), ErrOther},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Classify(tt.err); got != tt.want {
+				t.Errorf("Classify(%v) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,277 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+type gemini struct {
+	cfg Config
+}
+
+func newGemini(cfg Config) *gemini {
+	cfg.BaseURL = orDefault(cfg.BaseURL, "https://generativelanguage.googleapis.com/v1beta")
+	cfg.Model = orDefault(cfg.Model, "gemini-2.0-flash")
+	return &gemini{cfg: cfg}
+}
+
+func (g *gemini) Name() string { return "Gemini" }
+
+// headers authenticate a request. The key goes in a header rather than the
+// ?key= query parameter, which proxies and error messages tend to log.
+func (g *gemini) headers() map[string]string {
+	if g.cfg.APIKey == "" {
+		return nil
+	}
+	return map[string]string{"x-goog-api-key": g.cfg.APIKey}
+}
+
+// SafetyCategories maps the harm categories of Gemini's safety settings, by
+// the names used in the config, to the API's names.
+var SafetyCategories = map[string]string{
+	"harassment":        "HARM_CATEGORY_HARASSMENT",
+	"hate_speech":       "HARM_CATEGORY_HATE_SPEECH",
+	"sexually_explicit": "HARM_CATEGORY_SEXUALLY_EXPLICIT",
+	"dangerous_content": "HARM_CATEGORY_DANGEROUS_CONTENT",
+	"civic_integrity":   "HARM_CATEGORY_CIVIC_INTEGRITY",
+}
+
+// SafetyThresholds are the levels at which Gemini blocks a category.
+var SafetyThresholds = []string{"block_none", "block_only_high", "block_medium_and_above", "block_low_and_above", "off"}
+
+// geminiRequest is the request body for the Gemini API.
+type geminiRequest struct {
+	SystemInstruction *geminiContent  `json:"systemInstruction,omitempty"`
+	Contents          []geminiContent `json:"contents"`
+	Tools             []geminiTools   `json:"tools,omitempty"`
+	GenerationConfig  *geminiConfig   `json:"generationConfig,omitempty"`
+	SafetySettings    []geminiSafety  `json:"safetySettings,omitempty"`
+}
+
+type geminiConfig struct {
+	Temperature     *float64 `json:"temperature,omitempty"`
+	TopP            *float64 `json:"topP,omitempty"`
+	MaxOutputTokens int      `json:"maxOutputTokens,omitempty"`
+	StopSequences   []string `json:"stopSequences,omitempty"`
+}
+
+type geminiSafety struct {
+	Category  string `json:"category"`
+	Threshold string `json:"threshold"`
+}
+
+type geminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []geminiPart `json:"parts"`
+}
+
+type geminiPart struct {
+	Text             string                  `json:"text,omitempty"`
+	FunctionCall     *geminiFunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *geminiFunctionResponse `json:"functionResponse,omitempty"`
+}
+
+type geminiFunctionCall struct {
+	Name string          `json:"name"`
+	Args json.RawMessage `json:"args,omitempty"`
+}
+
+type geminiFunctionResponse struct {
+	Name     string         `json:"name"`
+	Response map[string]any `json:"response"`
+}
+
+type geminiTools struct {
+	FunctionDeclarations []geminiFunction `json:"functionDeclarations"`
+}
+
+type geminiFunction struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Parameters  map[string]any `json:"parameters,omitempty"`
+}
+
+// geminiResponse is the response body from the Gemini API.
+type geminiResponse struct {
+	Candidates []struct {
+		Content struct {
+			Parts []geminiPart `json:"parts"`
+		} `json:"content"`
+		FinishReason string `json:"finishReason"`
+	} `json:"candidates"`
+	PromptFeedback *struct {
+		BlockReason string `json:"blockReason"`
+	} `json:"promptFeedback"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (g *gemini) request(req Request) geminiRequest {
+	var body geminiRequest
+	if req.System != "" {
+		body.SystemInstruction = &geminiContent{Parts: []geminiPart{{Text: req.System}}}
+	}
+	for _, m := range req.Messages {
+		switch m.Role {
+		case RoleTool:
+			part := geminiPart{FunctionResponse: &geminiFunctionResponse{
+				Name:     m.ToolName,
+				Response: map[string]any{"content": m.Content},
+			}}
+			// Results of parallel calls go together in one turn.
+			if n := len(body.Contents); n > 0 && body.Contents[n-1].Parts[0].FunctionResponse != nil {
+				body.Contents[n-1].Parts = append(body.Contents[n-1].Parts, part)
+				continue
+			}
+			body.Contents = append(body.Contents, geminiContent{Role: "user", Parts: []geminiPart{part}})
+		case RoleAssistant:
+			content := geminiContent{Role: "model"}
+			if m.Content != "" {
+				content.Parts = append(content.Parts, geminiPart{Text: m.Content})
+			}
+			for _, call := range m.ToolCalls {
+				content.Parts = append(content.Parts, geminiPart{FunctionCall: &geminiFunctionCall{Name: call.Name, Args: call.Args}})
+			}
+			if len(content.Parts) == 0 {
+				content.Parts = []geminiPart{{Text: " "}}
+			}
+			body.Contents = append(body.Contents, content)
+		default:
+			body.Contents = append(body.Contents, geminiContent{Role: "user", Parts: []geminiPart{{Text: m.Content}}})
+		}
+	}
+	if len(req.Tools) > 0 {
+		var decls []geminiFunction
+		for _, t := range req.Tools {
+			decls = append(decls, geminiFunction{Name: t.Name, Description: t.Description, Parameters: t.Parameters})
+		}
+		body.Tools = []geminiTools{{FunctionDeclarations: decls}}
+	}
+	gen := req.Generation
+	if gen.Temperature != nil || gen.TopP != nil || gen.MaxOutputTokens > 0 || len(gen.Stop) > 0 {
+		body.GenerationConfig = &geminiConfig{
+			Temperature:     gen.Temperature,
+			TopP:            gen.TopP,
+			MaxOutputTokens: gen.MaxOutputTokens,
+			StopSequences:   gen.Stop,
+		}
+	}
+	for name, threshold := range gen.Safety {
+		category := orDefault(SafetyCategories[name], name)
+		body.SafetySettings = append(body.SafetySettings, geminiSafety{Category: category, Threshold: strings.ToUpper(threshold)})
+	}
+	sort.Slice(body.SafetySettings, func(i, j int) bool {
+		return body.SafetySettings[i].Category < body.SafetySettings[j].Category
+	})
+	return body
+}
+
+// collect adds a response's text and function calls to w and calls. Gemini
+// does not number calls, so IDs are made up from their position.
+func (r geminiResponse) collect(w *deltaWriter, calls *[]ToolCall) {
+	for _, c := range r.Candidates {
+		for _, part := range c.Content.Parts {
+			if part.FunctionCall != nil {
+				*calls = append(*calls, ToolCall{
+					ID:   fmt.Sprintf("call_%d", len(*calls)+1),
+					Name: part.FunctionCall.Name,
+					Args: argsOrEmpty(part.FunctionCall.Args),
+				})
+				continue
+			}
+			w.write(part.Text)
+		}
+	}
+}
+
+// blocked returns why the prompt or reply was blocked, or "" if it was not.
+func (r geminiResponse) blocked() string {
+	if r.PromptFeedback != nil && r.PromptFeedback.BlockReason != "" {
+		return r.PromptFeedback.BlockReason
+	}
+	for _, c := range r.Candidates {
+		switch c.FinishReason {
+		case "SAFETY", "RECITATION", "BLOCKLIST", "PROHIBITED_CONTENT", "SPII":
+			return c.FinishReason
+		}
+	}
+	return ""
+}
+
+func (g *gemini) Complete(ctx context.Context, req Request) (Response, error) {
+	endpoint := fmt.Sprintf("%s/models/%s:generateContent", g.cfg.BaseURL, url.PathEscape(g.cfg.Model))
+
+	respBytes, err := postJSON(ctx, g.cfg.HTTPClient, g.Name(), endpoint, g.headers(), g.request(req), geminiError)
+	if err != nil {
+		return Response{}, err
+	}
+
+	var geminiResp geminiResponse
+	if err := json.Unmarshal(respBytes, &geminiResp); err != nil {
+		return Response{}, fmt.Errorf("failed to parse response: %w", err)
+	}
+	if geminiResp.Error != nil {
+		return Response{}, fmt.Errorf("Gemini API error: %s", geminiResp.Error.Message)
+	}
+
+	w := &deltaWriter{}
+	var calls []ToolCall
+	geminiResp.collect(w, &calls)
+	text := w.finish()
+	if reason := geminiResp.blocked(); reason != "" {
+		return Response{Text: text, ToolCalls: calls}, &BlockedError{Provider: g.Name(), Reason: reason}
+	}
+	if text == "" && len(calls) == 0 {
+		return Response{}, fmt.Errorf("empty response from Gemini")
+	}
+	return Response{Text: text, ToolCalls: calls}, nil
+}
+
+func (g *gemini) Stream(ctx context.Context, req Request, onDelta func(string)) (Response, error) {
+	endpoint := fmt.Sprintf("%s/models/%s:streamGenerateContent?alt=sse", g.cfg.BaseURL, url.PathEscape(g.cfg.Model))
+
+	resp, err := post(ctx, g.cfg.HTTPClient, g.Name(), endpoint, g.headers(), g.request(req), geminiError)
+	if err != nil {
+		return Response{}, err
+	}
+	defer resp.Body.Close()
+
+	w := &deltaWriter{onDelta: onDelta}
+	var calls []ToolCall
+	var blocked string
+	err = readSSE(resp.Body, func(_, data string) error {
+		var chunk geminiResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return fmt.Errorf("failed to parse response: %w", err)
+		}
+		if chunk.Error != nil {
+			return fmt.Errorf("Gemini API error: %s", chunk.Error.Message)
+		}
+		chunk.collect(w, &calls)
+		if reason := chunk.blocked(); reason != "" {
+			blocked = reason
+		}
+		return nil
+	})
+	text := w.finish()
+	if err == nil && blocked != "" {
+		err = &BlockedError{Provider: g.Name(), Reason: blocked}
+	}
+	if err == nil && text == "" && len(calls) == 0 {
+		err = fmt.Errorf("empty response from Gemini")
+	}
+	return Response{Text: text, ToolCalls: calls}, err
+}
+
+func geminiError(body []byte) string {
+	var resp geminiResponse
+	if json.Unmarshal(body, &resp) == nil && resp.Error != nil {
+		return resp.Error.Message
+	}
+	return string(body)
+}
@@ -0,0 +1,240 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// APIError is a non-success HTTP response from a provider.
+type APIError struct {
+	Provider string
+	Status   int
+	Message  string
+	// RetryAfter is how long the provider asked to wait, or 0 if it did not say.
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("%s API error: HTTP %d", e.Provider, e.Status)
+	}
+	return fmt.Sprintf("%s API error (HTTP %d): %s", e.Provider, e.Status, e.Message)
+}
+
+// post sends body as JSON and returns the response for the caller to read.
+// Non-2xx responses become an *APIError, using extractErr to pull a message
+// out of the provider's error format.
+func post(ctx context.Context, client *http.Client, provider, url string, headers map[string]string,
+	body any, extractErr func([]byte) string) (*http.Response, error) {
+
+	bodyBytes, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(bodyBytes))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("API request failed: %w", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		respBytes, _ := io.ReadAll(resp.Body)
+		after, _ := retryAfter(resp.Header)
+		return nil, &APIError{Provider: provider, Status: resp.StatusCode, Message: extractErr(respBytes), RetryAfter: after}
+	}
+	return resp, nil
+}
+
+// postJSON is post for non-streaming calls, returning the whole response body.
+func postJSON(ctx context.Context, client *http.Client, provider, url string, headers map[string]string,
+	body any, extractErr func([]byte) string) ([]byte, error) {
+
+	resp, err := post(ctx, client, provider, url, headers, body, extractErr)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	return respBytes, nil
+}
+
+// errStreamDone stops reading a stream at an explicit end marker.
+var errStreamDone = errors.New("stream done")
+
+// maxLine bounds a single SSE or NDJSON line.
+const maxLine = 4 << 20
+
+// readLines calls fn for each line of r, without the trailing newline.
+func readLines(r io.Reader, fn func(line string) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLine)
+	for scanner.Scan() {
+		if err := fn(strings.TrimRight(scanner.Text(), "\r")); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("stream interrupted: %w", err)
+	}
+	return nil
+}
+
+// readSSE parses a server-sent event stream, calling fn with each event's
+// name (empty for unnamed events) and its data.
+func readSSE(r io.Reader, fn func(event, data string) error) error {
+	var event string
+	var data []string
+	flush := func() error {
+		if len(data) == 0 {
+			event = ""
+			return nil
+		}
+		err := fn(event, strings.Join(data, "\n"))
+		event, data = "", nil
+		return err
+	}
+	err := readLines(r, func(line string) error {
+		switch {
+		case line == "":
+			return flush()
+		case strings.HasPrefix(line, ":"):
+			// comment / keep-alive
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return flush()
+}
+
+// deltaWriter accumulates streamed text and forwards it to onDelta, holding
+// back an incomplete UTF-8 sequence at the end of a chunk until the rest
+// arrives so a multi-byte character is never split across deltas.
+type deltaWriter struct {
+	onDelta func(string)
+	text    strings.Builder
+	pending string
+}
+
+func (w *deltaWriter) write(s string) {
+	s = w.pending + s
+	w.pending = ""
+	cut := len(s)
+	for i := len(s) - 1; i >= 0 && i >= len(s)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(s[i]) {
+			if !utf8.FullRuneInString(s[i:]) {
+				cut = i
+			}
+			break
+		}
+	}
+	s, w.pending = s[:cut], s[cut:]
+	if s == "" {
+		return
+	}
+	w.text.WriteString(s)
+	if w.onDelta != nil {
+		w.onDelta(s)
+	}
+}
+
+// finish flushes any held-back bytes and returns the full text.
+func (w *deltaWriter) finish() string {
+	if w.pending != "" {
+		w.text.WriteString(w.pending)
+		if w.onDelta != nil {
+			w.onDelta(w.pending)
+		}
+		w.pending = ""
+	}
+	return w.text.String()
+}
+
+// argsOrEmpty returns raw, or an empty JSON object when a tool call has no
+// arguments, so callers can always unmarshal it.
+func argsOrEmpty(raw json.RawMessage) json.RawMessage {
+	if len(bytes.TrimSpace(raw)) == 0 || string(raw) == "null" {
+		return json.RawMessage("{}")
+	}
+	return raw
+}
+
+// toolCallBuilder assembles tool calls whose arguments arrive in pieces
+// across a stream, keyed by the provider's index for each call.
+type toolCallBuilder struct {
+	order []int
+	calls map[int]*ToolCall
+	args  map[int]*strings.Builder
+}
+
+func (b *toolCallBuilder) get(index int) *ToolCall {
+	if b.calls == nil {
+		b.calls = map[int]*ToolCall{}
+		b.args = map[int]*strings.Builder{}
+	}
+	if c, ok := b.calls[index]; ok {
+		return c
+	}
+	b.order = append(b.order, index)
+	b.calls[index] = &ToolCall{}
+	b.args[index] = &strings.Builder{}
+	return b.calls[index]
+}
+
+// start records the ID and name of call index.
+func (b *toolCallBuilder) start(index int, id, name string) {
+	c := b.get(index)
+	if id != "" {
+		c.ID = id
+	}
+	if name != "" {
+		c.Name = name
+	}
+}
+
+// appendArgs adds a fragment of call index's JSON arguments.
+func (b *toolCallBuilder) appendArgs(index int, fragment string) {
+	b.get(index)
+	b.args[index].WriteString(fragment)
+}
+
+func (b *toolCallBuilder) result() []ToolCall {
+	var calls []ToolCall
+	for _, i := range b.order {
+		c := *b.calls[i]
+		c.Args = argsOrEmpty(json.RawMessage(b.args[i].String()))
+		if c.ID == "" {
+			c.ID = fmt.Sprintf("call_%d", len(calls)+1)
+		}
+		calls = append(calls, c)
+	}
+	return calls
+}
@@ -0,0 +1,157 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// KeySource says where to look for a provider's API key after its
+// environment variable.
+type KeySource struct {
+	// Command is run through the shell and the first line it prints is the
+	// key, e.g. "pass show gemini".
+	Command string
+	// CredentialsFile is a TOML file of keys by provider name, such as
+	// gemini = "...". It must not be readable by other users.
+	CredentialsFile string
+}
+
+// KeyCommandTimeout bounds how long a key command may run.
+const KeyCommandTimeout = 10 * time.Second
+
+// ResolveKey finds the API key for provider in its environment variable, the
+// output of src.Command or src.CredentialsFile, in that order. Local
+// providers need no key and get "".
+func ResolveKey(ctx context.Context, provider string, src KeySource) (string, error) {
+	env := KeyEnv(provider)
+	if env == "" {
+		return "", nil
+	}
+	if key := strings.TrimSpace(os.Getenv(env)); key != "" {
+		return key, nil
+	}
+	if src.Command != "" {
+		return keyFromCommand(ctx, src.Command)
+	}
+	if src.CredentialsFile != "" {
+		key, err := keyFromFile(src.CredentialsFile, provider)
+		if key != "" || err != nil {
+			return key, err
+		}
+	}
+	name := strings.ToLower(orDefault(provider, "gemini"))
+	msg := fmt.Sprintf("no API key for %s: set the %s environment variable, set key_command", name, env)
+	if src.CredentialsFile != "" {
+		msg += fmt.Sprintf(" or add %s = \"...\" to %s", name, src.CredentialsFile)
+	}
+	return "", errors.New(msg)
+}
+
+// keyFromCommand runs command and returns the first line it prints.
+func keyFromCommand(ctx context.Context, command string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, KeyCommandTimeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if ctx.Err() == context.DeadlineExceeded {
+		return "", fmt.Errorf("key_command timed out after %s", KeyCommandTimeout)
+	}
+	if err != nil {
+		if line, _, _ := strings.Cut(strings.TrimSpace(stderr.String()), "\n"); line != "" {
+			return "", fmt.Errorf("key_command failed: %v: %s", err, line)
+		}
+		return "", fmt.Errorf("key_command failed: %v", err)
+	}
+	key, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+	if key = strings.TrimSpace(key); key == "" {
+		return "", errors.New("key_command printed nothing")
+	}
+	return key, nil
+}
+
+// keyFromFile reads provider's key from a credentials file, refusing files
+// other users could read. A missing file or entry gives "".
+func keyFromFile(path, provider string) (string, error) {
+	info, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	// Windows has no permission bits to check; its ACLs are left to the user.
+	if perm := info.Mode().Perm(); runtime.GOOS != "windows" && perm&0077 != 0 {
+		return "", fmt.Errorf("%s is accessible to other users (mode %04o); run chmod 600 on it", path, perm)
+	}
+	var keys map[string]string
+	if _, err := toml.DecodeFile(path, &keys); err != nil {
+		// The decode error may quote a line of the file, key and all.
+		return "", fmt.Errorf("%s is not a valid credentials file", path)
+	}
+	name := strings.ToLower(orDefault(provider, "gemini"))
+	if name == "llama.cpp" {
+		name = "llamacpp"
+	}
+	return strings.TrimSpace(keys[name]), nil
+}
+
+// redactedError hides secrets in an error's message while keeping the
+// original for errors.Is and errors.As.
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (e *redactedError) Error() string { return e.msg }
+func (e *redactedError) Unwrap() error { return e.err }
+
+// Redact returns err with any occurrence of secret, plain or URL-escaped,
+// replaced by "[REDACTED]".
+func Redact(err error, secret string) error {
+	if err == nil || secret == "" {
+		return err
+	}
+	msg := err.Error()
+	for _, s := range []string{secret, url.QueryEscape(secret), url.PathEscape(secret)} {
+		msg = strings.ReplaceAll(msg, s, "[REDACTED]")
+	}
+	if msg == err.Error() {
+		return err
+	}
+	return &redactedError{msg: msg, err: err}
+}
+
+// redacting wraps a provider so its errors never show the API key.
+type redacting struct {
+	Provider
+	key string
+}
+
+func (r redacting) Complete(ctx context.Context, req Request) (Response, error) {
+	resp, err := r.Provider.Complete(ctx, req)
+	return resp, Redact(err, r.key)
+}
+
+func (r redacting) Stream(ctx context.Context, req Request, onDelta func(string)) (Response, error) {
+	resp, err := r.Provider.Stream(ctx, req, onDelta)
+	return resp, Redact(err, r.key)
+}
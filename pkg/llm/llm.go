@@ -0,0 +1,185 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Role identifies who authored a message in a conversation.
+type Role string
+
+const (
+	RoleUser      Role = "user"
+	RoleAssistant Role = "assistant"
+	// RoleTool messages carry the result of a tool call back to the model.
+	RoleTool Role = "tool"
+)
+
+// Message is a single turn of a conversation.
+type Message struct {
+	Role    Role
+	Content string
+	// ToolCalls are the calls an assistant message asked for.
+	ToolCalls []ToolCall
+	// ToolCallID and ToolName identify the call a RoleTool message answers.
+	ToolCallID string
+	ToolName   string
+}
+
+// Tool describes a function the model may call.
+type Tool struct {
+	Name        string
+	Description string
+	// Parameters is a JSON schema object describing the arguments.
+	Parameters map[string]any
+}
+
+// ToolCall is a request from the model to run a tool.
+type ToolCall struct {
+	ID   string          `json:"id"`
+	Name string          `json:"name"`
+	Args json.RawMessage `json:"args"`
+}
+
+// Request is a provider-neutral completion request.
+type Request struct {
+	// System is the system prompt, sent in whatever form the provider expects.
+	System   string
+	Messages []Message
+	// Tools the model may call; the reply then may hold ToolCalls instead of,
+	// or as well as, text.
+	Tools []Tool
+	// Generation tunes how the reply is sampled.
+	Generation Generation
+}
+
+// Generation holds the sampling parameters of a request. Zero values leave
+// the provider's defaults in place.
+type Generation struct {
+	// Temperature and TopP are nil when unset, since 0 is a valid setting.
+	Temperature *float64
+	TopP        *float64
+	// MaxOutputTokens caps the length of the reply.
+	MaxOutputTokens int
+	// Stop sequences end the reply early.
+	Stop []string
+	// Safety maps harm categories to the threshold at which replies are
+	// blocked, e.g. "harassment" to "block_only_high". Only Gemini has them.
+	Safety map[string]string
+}
+
+// Response is a completed model reply.
+type Response struct {
+	Text      string
+	ToolCalls []ToolCall
+}
+
+// Provider is a chat-completion backend.
+type Provider interface {
+	// Name returns a human-readable name such as "Gemini".
+	Name() string
+	// Complete sends the conversation and returns the model's reply.
+	Complete(ctx context.Context, req Request) (Response, error)
+	// Stream is Complete, but calls onDelta with each piece of the reply as
+	// it arrives. The returned Response holds everything received, even when
+	// the stream fails part way through.
+	Stream(ctx context.Context, req Request, onDelta func(string)) (Response, error)
+}
+
+// Config selects and configures a provider.
+type Config struct {
+	// Provider is one of "gemini", "openai", "anthropic", "ollama" or "llamacpp".
+	Provider string
+	// Model is the provider's model name; empty or "default" picks the provider default.
+	Model string
+	// BaseURL overrides the provider's API endpoint, e.g. for a proxy, a
+	// compatible server or a local stand-in.
+	BaseURL string
+	// APIKey is sent in a request header, never in the URL, and is redacted
+	// from the provider's errors.
+	APIKey string
+	// HTTPClient is used for requests; nil uses a client that waits up to 30s
+	// for response headers. There is no overall timeout, since a streamed
+	// reply can take much longer than that; cancel the context instead.
+	HTTPClient *http.Client
+	// Retries is how many times a request failing with a network error, a
+	// 429 or a 5xx is retried before giving up.
+	Retries int
+	// RequestsPerMinute holds requests back so no more than this many go out
+	// in any minute; 0 is unlimited.
+	RequestsPerMinute int
+}
+
+// Providers lists the supported provider names.
+var Providers = []string{"gemini", "openai", "anthropic", "ollama", "llamacpp"}
+
+// New builds the provider selected by cfg.
+func New(cfg Config) (Provider, error) {
+	if cfg.HTTPClient == nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.ResponseHeaderTimeout = 30 * time.Second
+		cfg.HTTPClient = &http.Client{Transport: transport}
+	}
+	if cfg.Retries > 0 || cfg.RequestsPerMinute > 0 {
+		client := *cfg.HTTPClient
+		t := &retryTransport{base: client.Transport, retries: cfg.Retries}
+		if t.base == nil {
+			t.base = http.DefaultTransport
+		}
+		if cfg.RequestsPerMinute > 0 {
+			t.limit = &limiter{perMinute: cfg.RequestsPerMinute}
+		}
+		client.Transport = t
+		cfg.HTTPClient = &client
+	}
+	if cfg.Model == "default" {
+		cfg.Model = ""
+	}
+	cfg.BaseURL = strings.TrimSuffix(cfg.BaseURL, "/")
+
+	var p Provider
+	switch strings.ToLower(cfg.Provider) {
+	case "", "gemini":
+		p = newGemini(cfg)
+	case "openai":
+		p = newOpenAI(cfg, "OpenAI", "https://api.openai.com/v1", "gpt-4o-mini")
+	case "llamacpp", "llama.cpp":
+		// llama.cpp's server speaks the OpenAI chat completions API.
+		p = newOpenAI(cfg, "llama.cpp", "http://localhost:8080/v1", "default")
+	case "anthropic":
+		p = newAnthropic(cfg)
+	case "ollama":
+		p = newOllama(cfg)
+	default:
+		return nil, fmt.Errorf("unknown AI provider %q (want one of %s)", cfg.Provider, strings.Join(Providers, ", "))
+	}
+	if cfg.APIKey != "" {
+		p = redacting{Provider: p, key: cfg.APIKey}
+	}
+	return p, nil
+}
+
+// KeyEnv returns the environment variable conventionally holding the API key
+// for a provider, or "" for local providers that need none.
+func KeyEnv(provider string) string {
+	switch strings.ToLower(provider) {
+	case "", "gemini":
+		return "GEMINI_API_KEY"
+	case "openai":
+		return "OPENAI_API_KEY"
+	case "anthropic":
+		return "ANTHROPIC_API_KEY"
+	}
+	return ""
+}
+
+func orDefault(v, def string) string {
+	if v == "" {
+		return def
+	}
+	return v
+}
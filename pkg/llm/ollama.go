@@ -0,0 +1,164 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// ollama talks to a local Ollama server's native chat API.
+type ollama struct {
+	cfg Config
+}
+
+func newOllama(cfg Config) *ollama {
+	cfg.BaseURL = orDefault(cfg.BaseURL, "http://localhost:11434")
+	cfg.Model = orDefault(cfg.Model, "llama3.1")
+	return &ollama{cfg: cfg}
+}
+
+func (o *ollama) Name() string { return "Ollama" }
+
+type ollamaMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	ToolCalls []ollamaToolCall `json:"tool_calls,omitempty"`
+	ToolName  string           `json:"tool_name,omitempty"`
+}
+
+type ollamaToolCall struct {
+	Function struct {
+		Name string `json:"name"`
+		// Arguments is a JSON object, not a string as in the OpenAI API.
+		Arguments json.RawMessage `json:"arguments"`
+	} `json:"function"`
+}
+
+type ollamaRequest struct {
+	Model    string          `json:"model"`
+	Messages []ollamaMessage `json:"messages"`
+	Tools    []openAITool    `json:"tools,omitempty"`
+	Stream   bool            `json:"stream"`
+	Options  *ollamaOptions  `json:"options,omitempty"`
+}
+
+// ollamaOptions are the model parameters Ollama takes with a request.
+type ollamaOptions struct {
+	Temperature *float64 `json:"temperature,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+	NumPredict  int      `json:"num_predict,omitempty"`
+	Stop        []string `json:"stop,omitempty"`
+}
+
+type ollamaResponse struct {
+	Message ollamaMessage `json:"message"`
+	Done    bool          `json:"done"`
+	Error   string        `json:"error"`
+}
+
+func (o *ollama) request(req Request, stream bool) ollamaRequest {
+	body := ollamaRequest{Model: o.cfg.Model, Stream: stream}
+	if gen := req.Generation; gen.Temperature != nil || gen.TopP != nil || gen.MaxOutputTokens > 0 || len(gen.Stop) > 0 {
+		body.Options = &ollamaOptions{
+			Temperature: gen.Temperature,
+			TopP:        gen.TopP,
+			NumPredict:  gen.MaxOutputTokens,
+			Stop:        gen.Stop,
+		}
+	}
+	if req.System != "" {
+		body.Messages = append(body.Messages, ollamaMessage{Role: "system", Content: req.System})
+	}
+	for _, m := range req.Messages {
+		msg := ollamaMessage{Role: string(m.Role), Content: m.Content, ToolName: m.ToolName}
+		for _, call := range m.ToolCalls {
+			var tc ollamaToolCall
+			tc.Function.Name = call.Name
+			tc.Function.Arguments = argsOrEmpty(call.Args)
+			msg.ToolCalls = append(msg.ToolCalls, tc)
+		}
+		body.Messages = append(body.Messages, msg)
+	}
+	// Ollama takes tool definitions in the OpenAI format.
+	for _, t := range req.Tools {
+		body.Tools = append(body.Tools, openAITool{
+			Type:     "function",
+			Function: openAIFunction{Name: t.Name, Description: t.Description, Parameters: t.Parameters},
+		})
+	}
+	return body
+}
+
+// toolCalls converts the calls in a reply, numbering them since Ollama does not.
+func (r ollamaResponse) toolCalls(calls []ToolCall) []ToolCall {
+	for _, tc := range r.Message.ToolCalls {
+		calls = append(calls, ToolCall{
+			ID:   fmt.Sprintf("call_%d", len(calls)+1),
+			Name: tc.Function.Name,
+			Args: argsOrEmpty(tc.Function.Arguments),
+		})
+	}
+	return calls
+}
+
+func (o *ollama) Complete(ctx context.Context, req Request) (Response, error) {
+	respBytes, err := postJSON(ctx, o.cfg.HTTPClient, o.Name(), o.cfg.BaseURL+"/api/chat",
+		nil, o.request(req, false), ollamaError)
+	if err != nil {
+		return Response{}, err
+	}
+
+	var resp ollamaResponse
+	if err := json.Unmarshal(respBytes, &resp); err != nil {
+		return Response{}, fmt.Errorf("failed to parse response: %w", err)
+	}
+	if resp.Error != "" {
+		return Response{}, fmt.Errorf("Ollama error: %s", resp.Error)
+	}
+	calls := resp.toolCalls(nil)
+	if resp.Message.Content == "" && len(calls) == 0 {
+		return Response{}, fmt.Errorf("empty response from Ollama")
+	}
+	return Response{Text: resp.Message.Content, ToolCalls: calls}, nil
+}
+
+// Stream reads Ollama's newline-delimited JSON stream.
+func (o *ollama) Stream(ctx context.Context, req Request, onDelta func(string)) (Response, error) {
+	resp, err := post(ctx, o.cfg.HTTPClient, o.Name(), o.cfg.BaseURL+"/api/chat",
+		nil, o.request(req, true), ollamaError)
+	if err != nil {
+		return Response{}, err
+	}
+	defer resp.Body.Close()
+
+	w := &deltaWriter{onDelta: onDelta}
+	var calls []ToolCall
+	err = readLines(resp.Body, func(line string) error {
+		if line == "" {
+			return nil
+		}
+		var chunk ollamaResponse
+		if err := json.Unmarshal([]byte(line), &chunk); err != nil {
+			return fmt.Errorf("failed to parse response: %w", err)
+		}
+		if chunk.Error != "" {
+			return fmt.Errorf("Ollama error: %s", chunk.Error)
+		}
+		w.write(chunk.Message.Content)
+		calls = chunk.toolCalls(calls)
+		return nil
+	})
+	text := w.finish()
+	if err == nil && text == "" && len(calls) == 0 {
+		err = fmt.Errorf("empty response from Ollama")
+	}
+	return Response{Text: text, ToolCalls: calls}, err
+}
+
+func ollamaError(body []byte) string {
+	var resp ollamaResponse
+	if json.Unmarshal(body, &resp) == nil && resp.Error != "" {
+		return resp.Error
+	}
+	return string(body)
+}
@@ -0,0 +1,206 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// openAI talks to the OpenAI chat completions API and compatible servers.
+type openAI struct {
+	cfg  Config
+	name string
+}
+
+func newOpenAI(cfg Config, name, baseURL, model string) *openAI {
+	cfg.BaseURL = orDefault(cfg.BaseURL, baseURL)
+	cfg.Model = orDefault(cfg.Model, model)
+	return &openAI{cfg: cfg, name: name}
+}
+
+func (o *openAI) Name() string { return o.name }
+
+type openAIMessage struct {
+	Role       string           `json:"role"`
+	Content    string           `json:"content"`
+	ToolCalls  []openAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
+}
+
+type openAIToolCall struct {
+	// Index orders the pieces of a call in a stream.
+	Index    int    `json:"index,omitempty"`
+	ID       string `json:"id,omitempty"`
+	Type     string `json:"type,omitempty"`
+	Function struct {
+		Name      string `json:"name,omitempty"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+type openAITool struct {
+	Type     string         `json:"type"`
+	Function openAIFunction `json:"function"`
+}
+
+type openAIFunction struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Parameters  map[string]any `json:"parameters,omitempty"`
+}
+
+type openAIRequest struct {
+	Model       string          `json:"model"`
+	Messages    []openAIMessage `json:"messages"`
+	Tools       []openAITool    `json:"tools,omitempty"`
+	Stream      bool            `json:"stream,omitempty"`
+	Temperature *float64        `json:"temperature,omitempty"`
+	TopP        *float64        `json:"top_p,omitempty"`
+	MaxTokens   int             `json:"max_tokens,omitempty"`
+	Stop        []string        `json:"stop,omitempty"`
+}
+
+type openAIResponse struct {
+	Choices []struct {
+		Message openAIMessage `json:"message"`
+		// Delta carries the new text in a streamed chunk.
+		Delta        openAIMessage `json:"delta"`
+		FinishReason string        `json:"finish_reason"`
+	} `json:"choices"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (o *openAI) request(req Request) openAIRequest {
+	gen := req.Generation
+	body := openAIRequest{
+		Model:       o.cfg.Model,
+		Temperature: gen.Temperature,
+		TopP:        gen.TopP,
+		MaxTokens:   gen.MaxOutputTokens,
+		Stop:        gen.Stop,
+	}
+	if req.System != "" {
+		body.Messages = append(body.Messages, openAIMessage{Role: "system", Content: req.System})
+	}
+	for _, m := range req.Messages {
+		msg := openAIMessage{Role: string(m.Role), Content: m.Content, ToolCallID: m.ToolCallID}
+		for _, call := range m.ToolCalls {
+			tc := openAIToolCall{ID: call.ID, Type: "function"}
+			tc.Function.Name = call.Name
+			tc.Function.Arguments = string(argsOrEmpty(call.Args))
+			msg.ToolCalls = append(msg.ToolCalls, tc)
+		}
+		body.Messages = append(body.Messages, msg)
+	}
+	for _, t := range req.Tools {
+		body.Tools = append(body.Tools, openAITool{
+			Type:     "function",
+			Function: openAIFunction{Name: t.Name, Description: t.Description, Parameters: t.Parameters},
+		})
+	}
+	return body
+}
+
+func (o *openAI) headers() map[string]string {
+	if o.cfg.APIKey == "" {
+		return nil
+	}
+	return map[string]string{"Authorization": "Bearer " + o.cfg.APIKey}
+}
+
+// openAIFiltered is the finish reason of a reply cut short by the content
+// filter.
+const openAIFiltered = "content_filter"
+
+func (o *openAI) Complete(ctx context.Context, req Request) (Response, error) {
+	respBytes, err := postJSON(ctx, o.cfg.HTTPClient, o.name, o.cfg.BaseURL+"/chat/completions",
+		o.headers(), o.request(req), openAIError)
+	if err != nil {
+		return Response{}, err
+	}
+
+	var resp openAIResponse
+	if err := json.Unmarshal(respBytes, &resp); err != nil {
+		return Response{}, fmt.Errorf("failed to parse response: %w", err)
+	}
+	if resp.Error != nil {
+		return Response{}, fmt.Errorf("%s API error: %s", o.name, resp.Error.Message)
+	}
+	if len(resp.Choices) == 0 {
+		return Response{}, fmt.Errorf("empty response from %s", o.name)
+	}
+	msg := resp.Choices[0].Message
+	var calls toolCallBuilder
+	for i, tc := range msg.ToolCalls {
+		calls.start(i, tc.ID, tc.Function.Name)
+		calls.appendArgs(i, tc.Function.Arguments)
+	}
+	toolCalls := calls.result()
+	if reason := resp.Choices[0].FinishReason; reason == openAIFiltered {
+		return Response{Text: msg.Content, ToolCalls: toolCalls}, &BlockedError{Provider: o.name, Reason: reason}
+	}
+	if msg.Content == "" && len(toolCalls) == 0 {
+		return Response{}, fmt.Errorf("empty response from %s", o.name)
+	}
+	return Response{Text: msg.Content, ToolCalls: toolCalls}, nil
+}
+
+func (o *openAI) Stream(ctx context.Context, req Request, onDelta func(string)) (Response, error) {
+	body := o.request(req)
+	body.Stream = true
+	resp, err := post(ctx, o.cfg.HTTPClient, o.name, o.cfg.BaseURL+"/chat/completions",
+		o.headers(), body, openAIError)
+	if err != nil {
+		return Response{}, err
+	}
+	defer resp.Body.Close()
+
+	w := &deltaWriter{onDelta: onDelta}
+	var calls toolCallBuilder
+	var blocked bool
+	err = readSSE(resp.Body, func(_, data string) error {
+		if data == "[DONE]" {
+			return errStreamDone
+		}
+		var chunk openAIResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return fmt.Errorf("failed to parse response: %w", err)
+		}
+		if chunk.Error != nil {
+			return fmt.Errorf("%s API error: %s", o.name, chunk.Error.Message)
+		}
+		for _, c := range chunk.Choices {
+			w.write(c.Delta.Content)
+			for _, tc := range c.Delta.ToolCalls {
+				calls.start(tc.Index, tc.ID, tc.Function.Name)
+				calls.appendArgs(tc.Index, tc.Function.Arguments)
+			}
+			if c.FinishReason == openAIFiltered {
+				blocked = true
+			}
+		}
+		return nil
+	})
+	if err == errStreamDone {
+		err = nil
+	}
+	text := w.finish()
+	toolCalls := calls.result()
+	if err == nil && blocked {
+		err = &BlockedError{Provider: o.name, Reason: openAIFiltered}
+	}
+	if err == nil && text == "" && len(toolCalls) == 0 {
+		err = fmt.Errorf("empty response from %s", o.name)
+	}
+	return Response{Text: text, ToolCalls: toolCalls}, err
+}
+
+func openAIError(body []byte) string {
+	var resp openAIResponse
+	if json.Unmarshal(body, &resp) == nil && resp.Error != nil {
+		return resp.Error.Message
+	}
+	return string(body)
+}
@@ -0,0 +1,543 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// captured is a request as the test server saw it.
+type captured struct {
+	Path   string
+	Query  string
+	Header http.Header
+	Body   map[string]any
+}
+
+// fakeServer answers every request with status and body, recording what it
+// was sent.
+func fakeServer(t *testing.T, status int, body string) (*httptest.Server, *captured) {
+	t.Helper()
+	got := &captured{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, _ := io.ReadAll(r.Body)
+		got.Path, got.Query, got.Header = r.URL.Path, r.URL.RawQuery, r.Header.Clone()
+		got.Body = nil
+		if err := json.Unmarshal(raw, &got.Body); err != nil {
+			t.Errorf("request body is not JSON: %v", err)
+		}
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, got
+}
+
+func newTestProvider(t *testing.T, provider, baseURL, key string) Provider {
+	t.Helper()
+	p, err := New(Config{Provider: provider, BaseURL: baseURL, APIKey: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// toolRequest is a conversation with a tool call and its result, followed by
+// a prompt.
+func toolRequest() Request {
+	temp := 0.5
+	return Request{
+		System: "be brief",
+		Messages: []Message{
+			{Role: RoleUser, Content: "read main.go"},
+			{Role: RoleAssistant, ToolCalls: []ToolCall{{ID: "call_1", Name: "read_file", Args: json.RawMessage(`{"path":"main.go"}`)}}},
+			{Role: RoleTool, Content: "package main", ToolCallID: "call_1", ToolName: "read_file"},
+			{Role: RoleUser, Content: "thanks"},
+		},
+		Tools: []Tool{{Name: "read_file", Description: "Read a file", Parameters: map[string]any{"type": "object"}}},
+		Generation: Generation{
+			Temperature:     &temp,
+			MaxOutputTokens: 100,
+			Stop:            []string{"END"},
+			Safety:          map[string]string{"harassment": "block_none"},
+		},
+	}
+}
+
+// field walks a decoded JSON body by map keys and slice indexes.
+func field(t *testing.T, v any, path ...any) any {
+	t.Helper()
+	for _, p := range path {
+		switch k := p.(type) {
+		case string:
+			m, ok := v.(map[string]any)
+			if !ok {
+				t.Fatalf("%v: not an object at %q", path, k)
+			}
+			v = m[k]
+		case int:
+			s, ok := v.([]any)
+			if !ok || k >= len(s) {
+				t.Fatalf("%v: no element %d", path, k)
+			}
+			v = s[k]
+		}
+	}
+	return v
+}
+
+func TestRequestHeadersAndKey(t *testing.T) {
+	const key = "secret-key/+="
+	tests := []struct {
+		provider string
+		path     string
+		header   string
+		want     string
+	}{
+		{"gemini", "/models/gemini-2.0-flash:generateContent", "x-goog-api-key", key},
+		{"openai", "/chat/completions", "Authorization", "Bearer " + key},
+		{"anthropic", "/v1/messages", "x-api-key", key},
+	}
+	for _, tt := range tests {
+		t.Run(tt.provider, func(t *testing.T) {
+			srv, got := fakeServer(t, http.StatusBadRequest, `{}`)
+			p := newTestProvider(t, tt.provider, srv.URL, key)
+			p.Complete(context.Background(), Request{Messages: []Message{{Role: RoleUser, Content: "hi"}}})
+
+			if got.Path != tt.path {
+				t.Errorf("path = %q, want %q", got.Path, tt.path)
+			}
+			if h := got.Header.Get(tt.header); h != tt.want {
+				t.Errorf("%s = %q, want %q", tt.header, h, tt.want)
+			}
+			if got.Header.Get("Content-Type") != "application/json" {
+				t.Errorf("Content-Type = %q", got.Header.Get("Content-Type"))
+			}
+			if strings.Contains(got.Query, "secret") || strings.Contains(got.Path, "secret") {
+				t.Errorf("key leaked into the URL: %s?%s", got.Path, got.Query)
+			}
+		})
+	}
+}
+
+func TestGeminiKeyNotInStreamURL(t *testing.T) {
+	srv, got := fakeServer(t, http.StatusOK, "")
+	p := newTestProvider(t, "gemini", srv.URL, "secret")
+	p.Stream(context.Background(), Request{}, func(string) {})
+	if got.Query != "alt=sse" {
+		t.Errorf("query = %q, want alt=sse only", got.Query)
+	}
+	if got.Header.Get("x-goog-api-key") != "secret" {
+		t.Errorf("x-goog-api-key = %q", got.Header.Get("x-goog-api-key"))
+	}
+}
+
+func TestLocalProvidersSendNoKey(t *testing.T) {
+	srv, got := fakeServer(t, http.StatusOK, `{"message":{"content":"hi"},"done":true}`)
+	p := newTestProvider(t, "ollama", srv.URL, "")
+	if _, err := p.Complete(context.Background(), Request{}); err != nil {
+		t.Fatal(err)
+	}
+	if got.Header.Get("Authorization") != "" {
+		t.Errorf("Authorization = %q, want none", got.Header.Get("Authorization"))
+	}
+}
+
+func TestGeminiRequestBody(t *testing.T) {
+	srv, got := fakeServer(t, http.StatusOK, `{"candidates":[{"content":{"parts":[{"text":"ok"}]}}]}`)
+	p := newTestProvider(t, "gemini", srv.URL, "k")
+	if _, err := p.Complete(context.Background(), toolRequest()); err != nil {
+		t.Fatal(err)
+	}
+	b := got.Body
+	if s := field(t, b, "systemInstruction", "parts", 0, "text"); s != "be brief" {
+		t.Errorf("system = %v", s)
+	}
+	if r := field(t, b, "contents", 1, "role"); r != "model" {
+		t.Errorf("assistant role = %v, want model", r)
+	}
+	if n := field(t, b, "contents", 1, "parts", 0, "functionCall", "name"); n != "read_file" {
+		t.Errorf("functionCall = %v", n)
+	}
+	if a := field(t, b, "contents", 1, "parts", 0, "functionCall", "args", "path"); a != "main.go" {
+		t.Errorf("functionCall args = %v", a)
+	}
+	if c := field(t, b, "contents", 2, "parts", 0, "functionResponse", "response", "content"); c != "package main" {
+		t.Errorf("functionResponse = %v", c)
+	}
+	if n := field(t, b, "tools", 0, "functionDeclarations", 0, "name"); n != "read_file" {
+		t.Errorf("tool = %v", n)
+	}
+	if v := field(t, b, "generationConfig", "maxOutputTokens"); v != 100.0 {
+		t.Errorf("maxOutputTokens = %v", v)
+	}
+	if v := field(t, b, "safetySettings", 0); !reflect.DeepEqual(v, map[string]any{
+		"category": "HARM_CATEGORY_HARASSMENT", "threshold": "BLOCK_NONE"}) {
+		t.Errorf("safetySettings = %v", v)
+	}
+}
+
+func TestOpenAIRequestBody(t *testing.T) {
+	srv, got := fakeServer(t, http.StatusOK, `{"choices":[{"message":{"content":"ok"}}]}`)
+	p := newTestProvider(t, "openai", srv.URL, "k")
+	if _, err := p.Complete(context.Background(), toolRequest()); err != nil {
+		t.Fatal(err)
+	}
+	b := got.Body
+	if r := field(t, b, "messages", 0, "role"); r != "system" {
+		t.Errorf("first message role = %v, want system", r)
+	}
+	if a := field(t, b, "messages", 2, "tool_calls", 0, "function", "arguments"); a != `{"path":"main.go"}` {
+		t.Errorf("arguments = %v, want a JSON string", a)
+	}
+	if id := field(t, b, "messages", 3, "tool_call_id"); id != "call_1" {
+		t.Errorf("tool_call_id = %v", id)
+	}
+	if v := field(t, b, "temperature"); v != 0.5 {
+		t.Errorf("temperature = %v", v)
+	}
+	if v := field(t, b, "max_tokens"); v != 100.0 {
+		t.Errorf("max_tokens = %v", v)
+	}
+	if _, ok := b["safetySettings"]; ok {
+		t.Error("safety settings sent to OpenAI")
+	}
+}
+
+func TestAnthropicRequestBody(t *testing.T) {
+	srv, got := fakeServer(t, http.StatusOK, `{"content":[{"type":"text","text":"ok"}]}`)
+	p := newTestProvider(t, "anthropic", srv.URL, "k")
+	if _, err := p.Complete(context.Background(), toolRequest()); err != nil {
+		t.Fatal(err)
+	}
+	b := got.Body
+	if got.Header.Get("anthropic-version") != anthropicVersion {
+		t.Errorf("anthropic-version = %q", got.Header.Get("anthropic-version"))
+	}
+	if s := field(t, b, "system"); s != "be brief" {
+		t.Errorf("system = %v", s)
+	}
+	if v := field(t, b, "max_tokens"); v != 100.0 {
+		t.Errorf("max_tokens = %v", v)
+	}
+	if typ := field(t, b, "messages", 1, "content", 0, "type"); typ != "tool_use" {
+		t.Errorf("assistant block = %v, want tool_use", typ)
+	}
+	// The result and the following prompt share one user turn.
+	if typ := field(t, b, "messages", 2, "content", 0, "type"); typ != "tool_result" {
+		t.Errorf("result block = %v, want tool_result", typ)
+	}
+	if text := field(t, b, "messages", 2, "content", 1, "text"); text != "thanks" {
+		t.Errorf("prompt after result = %v", text)
+	}
+	if n := len(field(t, b, "messages").([]any)); n != 3 {
+		t.Errorf("%d messages, want 3", n)
+	}
+}
+
+func TestAnthropicDefaultMaxTokens(t *testing.T) {
+	srv, got := fakeServer(t, http.StatusOK, `{"content":[{"type":"text","text":"ok"}]}`)
+	p := newTestProvider(t, "anthropic", srv.URL, "k")
+	p.Complete(context.Background(), Request{Messages: []Message{{Role: RoleUser, Content: "hi"}}})
+	if v := field(t, got.Body, "max_tokens"); v != 4096.0 {
+		t.Errorf("max_tokens = %v, want 4096", v)
+	}
+}
+
+func TestOllamaRequestBody(t *testing.T) {
+	srv, got := fakeServer(t, http.StatusOK, `{"message":{"content":"ok"},"done":true}`)
+	p := newTestProvider(t, "ollama", srv.URL, "")
+	if _, err := p.Complete(context.Background(), toolRequest()); err != nil {
+		t.Fatal(err)
+	}
+	b := got.Body
+	if got.Path != "/api/chat" {
+		t.Errorf("path = %q", got.Path)
+	}
+	if s := field(t, b, "stream"); s != false {
+		t.Errorf("stream = %v, want false", s)
+	}
+	if v := field(t, b, "options", "num_predict"); v != 100.0 {
+		t.Errorf("num_predict = %v", v)
+	}
+	if a := field(t, b, "messages", 2, "tool_calls", 0, "function", "arguments", "path"); a != "main.go" {
+		t.Errorf("arguments = %v, want a JSON object", a)
+	}
+	if n := field(t, b, "messages", 3, "tool_name"); n != "read_file" {
+		t.Errorf("tool_name = %v", n)
+	}
+}
+
+func TestCompleteParsesReplies(t *testing.T) {
+	tests := []struct {
+		provider string
+		body     string
+		text     string
+		call     ToolCall
+	}{
+		{
+			"gemini",
+			`{"candidates":[{"content":{"parts":[{"text":"Hi "},{"text":"there"},{"functionCall":{"name":"read_file","args":{"path":"a"}}}]}}]}`,
+			"Hi there",
+			ToolCall{ID: "call_1", Name: "read_file", Args: json.RawMessage(`{"path":"a"}`)},
+		},
+		{
+			"openai",
+			`{"choices":[{"message":{"content":"Hi there","tool_calls":[{"id":"c9","type":"function","function":{"name":"read_file","arguments":"{\"path\":\"a\"}"}}]}}]}`,
+			"Hi there",
+			ToolCall{ID: "c9", Name: "read_file", Args: json.RawMessage(`{"path":"a"}`)},
+		},
+		{
+			"anthropic",
+			`{"content":[{"type":"text","text":"Hi there"},{"type":"tool_use","id":"tu1","name":"read_file","input":{"path":"a"}}]}`,
+			"Hi there",
+			ToolCall{ID: "tu1", Name: "read_file", Args: json.RawMessage(`{"path":"a"}`)},
+		},
+		{
+			"ollama",
+			`{"message":{"content":"Hi there","tool_calls":[{"function":{"name":"read_file","arguments":{"path":"a"}}}]},"done":true}`,
+			"Hi there",
+			ToolCall{ID: "call_1", Name: "read_file", Args: json.RawMessage(`{"path":"a"}`)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.provider, func(t *testing.T) {
+			srv, _ := fakeServer(t, http.StatusOK, tt.body)
+			resp, err := newTestProvider(t, tt.provider, srv.URL, "k").Complete(context.Background(), Request{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.Text != tt.text {
+				t.Errorf("text = %q, want %q", resp.Text, tt.text)
+			}
+			if len(resp.ToolCalls) != 1 {
+				t.Fatalf("tool calls = %v, want 1", resp.ToolCalls)
+			}
+			c := resp.ToolCalls[0]
+			if c.ID != tt.call.ID || c.Name != tt.call.Name || string(c.Args) != string(tt.call.Args) {
+				t.Errorf("tool call = %+v (args %s), want %+v", c, c.Args, tt.call)
+			}
+		})
+	}
+}
+
+func TestStreams(t *testing.T) {
+	tests := []struct {
+		provider string
+		body     string
+		text     string
+		call     string
+	}{
+		{
+			"gemini",
+			"data: {\"candidates\":[{\"content\":{\"parts\":[{\"text\":\"Hel\"}]}}]}\n\n" +
+				"data: {\"candidates\":[{\"content\":{\"parts\":[{\"text\":\"lo\"},{\"functionCall\":{\"name\":\"ls\",\"args\":{\"dir\":\".\"}}}]}}]}\n\n",
+			"Hello", `ls {"dir":"."}`,
+		},
+		{
+			"openai",
+			": keep-alive\n\n" +
+				"data: {\"choices\":[{\"delta\":{\"content\":\"Hel\"}}]}\n\n" +
+				"data: {\"choices\":[{\"delta\":{\"content\":\"lo\",\"tool_calls\":[{\"index\":0,\"id\":\"c1\",\"function\":{\"name\":\"ls\",\"arguments\":\"{\\\"dir\\\"\"}}]}}]}\n\n" +
+				"data: {\"choices\":[{\"delta\":{\"tool_calls\":[{\"index\":0,\"function\":{\"arguments\":\":\\\".\\\"}\"}}]}}]}\n\n" +
+				"data: [DONE]\n\n" +
+				"data: not json after the end\n\n",
+			"Hello", `ls {"dir":"."}`,
+		},
+		{
+			"anthropic",
+			"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"Hel\"}}\n\n" +
+				"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"lo\"}}\n\n" +
+				"event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":1,\"content_block\":{\"type\":\"tool_use\",\"id\":\"tu1\",\"name\":\"ls\"}}\n\n" +
+				"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":1,\"delta\":{\"type\":\"input_json_delta\",\"partial_json\":\"{\\\"dir\\\":\"}}\n\n" +
+				"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":1,\"delta\":{\"type\":\"input_json_delta\",\"partial_json\":\"\\\".\\\"}\"}}\n\n" +
+				"event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n",
+			"Hello", `ls {"dir":"."}`,
+		},
+		{
+			"ollama",
+			"{\"message\":{\"content\":\"Hel\"},\"done\":false}\n" +
+				"\n" +
+				"{\"message\":{\"content\":\"lo\",\"tool_calls\":[{\"function\":{\"name\":\"ls\",\"arguments\":{\"dir\":\".\"}}}]},\"done\":false}\n" +
+				"{\"message\":{\"content\":\"\"},\"done\":true}\n",
+			"Hello", `ls {"dir":"."}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.provider, func(t *testing.T) {
+			srv, got := fakeServer(t, http.StatusOK, tt.body)
+			var deltas []string
+			resp, err := newTestProvider(t, tt.provider, srv.URL, "k").Stream(context.Background(),
+				Request{Messages: []Message{{Role: RoleUser, Content: "hi"}}},
+				func(d string) { deltas = append(deltas, d) })
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.Text != tt.text || strings.Join(deltas, "") != tt.text {
+				t.Errorf("text = %q, deltas = %q, want %q", resp.Text, deltas, tt.text)
+			}
+			if len(deltas) < 2 {
+				t.Errorf("deltas = %q, want the reply in pieces", deltas)
+			}
+			if len(resp.ToolCalls) != 1 {
+				t.Fatalf("tool calls = %v, want 1", resp.ToolCalls)
+			}
+			if c := resp.ToolCalls[0]; c.Name+" "+string(c.Args) != tt.call {
+				t.Errorf("tool call = %s %s, want %s", c.Name, c.Args, tt.call)
+			}
+			// Gemini streams from its own endpoint rather than by a flag.
+			if tt.provider != "gemini" && got.Body["stream"] != true {
+				t.Errorf("stream = %v, want true", got.Body["stream"])
+			}
+		})
+	}
+}
+
+func TestStreamKeepsPartialReplyOnError(t *testing.T) {
+	srv, _ := fakeServer(t, http.StatusOK,
+		"data: {\"choices\":[{\"delta\":{\"content\":\"partial\"}}]}\n\n"+
+			"data: {\"error\":{\"message\":\"overloaded\"}}\n\n")
+	resp, err := newTestProvider(t, "openai", srv.URL, "k").Stream(context.Background(), Request{}, func(string) {})
+	if err == nil || !strings.Contains(err.Error(), "overloaded") {
+		t.Fatalf("err = %v, want the stream's error", err)
+	}
+	if resp.Text != "partial" {
+		t.Errorf("text = %q, want what arrived before the error", resp.Text)
+	}
+}
+
+func TestAPIErrorMessages(t *testing.T) {
+	tests := []struct {
+		provider string
+		body     string
+		want     string
+	}{
+		{"gemini", `{"error":{"code":400,"message":"API key not valid","status":"INVALID_ARGUMENT"}}`, "API key not valid"},
+		{"openai", `{"error":{"message":"Incorrect API key provided","type":"invalid_request_error"}}`, "Incorrect API key provided"},
+		{"anthropic", `{"type":"error","error":{"type":"authentication_error","message":"invalid x-api-key"}}`, "invalid x-api-key"},
+		{"ollama", `{"error":"model \"nope\" not found"}`, `model "nope" not found`},
+		{"openai", `upstream connect error`, "upstream connect error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.provider, func(t *testing.T) {
+			srv, _ := fakeServer(t, http.StatusUnauthorized, tt.body)
+			p := newTestProvider(t, tt.provider, srv.URL, "k")
+			for _, call := range []func() error{
+				func() error { _, err := p.Complete(context.Background(), Request{}); return err },
+				func() error { _, err := p.Stream(context.Background(), Request{}, func(string) {}); return err },
+			} {
+				var apiErr *APIError
+				if err := call(); !errors.As(err, &apiErr) {
+					t.Fatalf("err = %v, want an *APIError", err)
+				}
+				if apiErr.Status != http.StatusUnauthorized || apiErr.Message != tt.want {
+					t.Errorf("APIError = %d %q, want 401 %q", apiErr.Status, apiErr.Message, tt.want)
+				}
+				if apiErr.Provider != p.Name() {
+					t.Errorf("Provider = %q, want %q", apiErr.Provider, p.Name())
+				}
+			}
+		})
+	}
+}
+
+func TestEmptyReplies(t *testing.T) {
+	tests := []struct{ provider, body string }{
+		{"gemini", `{"candidates":[]}`},
+		{"openai", `{"choices":[]}`},
+		{"openai", `{"choices":[{"message":{"role":"assistant","content":""},"finish_reason":"stop"}]}`},
+		{"anthropic", `{"content":[]}`},
+		{"ollama", `{"message":{"role":"assistant","content":""},"done":true}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.provider, func(t *testing.T) {
+			srv, _ := fakeServer(t, http.StatusOK, tt.body)
+			_, err := newTestProvider(t, tt.provider, srv.URL, "k").Complete(context.Background(), Request{})
+			if err == nil || !strings.Contains(err.Error(), "empty response") {
+				t.Errorf("err = %v, want an empty response error", err)
+			}
+		})
+	}
+}
+
+func TestOllamaEmptyStream(t *testing.T) {
+	srv, _ := fakeServer(t, http.StatusOK, "{\"message\":{\"content\":\"\"},\"done\":false}\n{\"message\":{\"content\":\"\"},\"done\":true}\n")
+	_, err := newTestProvider(t, "ollama", srv.URL, "").Stream(context.Background(), Request{}, func(string) {})
+	if err == nil || !strings.Contains(err.Error(), "empty response") {
+		t.Errorf("err = %v, want an empty response error", err)
+	}
+}
+
+func TestBlockedReplies(t *testing.T) {
+	tests := []struct {
+		provider string
+		stream   bool
+		body     string
+		reason   string
+	}{
+		{"gemini", false, `{"promptFeedback":{"blockReason":"SAFETY"}}`, "SAFETY"},
+		{"gemini", true, "data: {\"candidates\":[{\"content\":{\"parts\":[{\"text\":\"Hi\"}]},\"finishReason\":\"RECITATION\"}]}\n\n", "RECITATION"},
+		{"openai", false, `{"choices":[{"message":{"content":""},"finish_reason":"content_filter"}]}`, "content_filter"},
+		{"openai", true, "data: {\"choices\":[{\"delta\":{\"content\":\"Hi\"}}]}\n\n" +
+			"data: {\"choices\":[{\"delta\":{},\"finish_reason\":\"content_filter\"}]}\n\ndata: [DONE]\n\n", "content_filter"},
+		{"anthropic", false, `{"content":[],"stop_reason":"refusal"}`, "refusal"},
+		{"anthropic", true, "event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"Hi\"}}\n\n" +
+			"event: message_delta\ndata: {\"type\":\"message_delta\",\"delta\":{\"stop_reason\":\"refusal\"}}\n\n", "refusal"},
+	}
+	for _, tt := range tests {
+		name := tt.provider
+		if tt.stream {
+			name += " stream"
+		}
+		t.Run(name, func(t *testing.T) {
+			srv, _ := fakeServer(t, http.StatusOK, tt.body)
+			p := newTestProvider(t, tt.provider, srv.URL, "k")
+			var err error
+			if tt.stream {
+				_, err = p.Stream(context.Background(), Request{}, func(string) {})
+			} else {
+				_, err = p.Complete(context.Background(), Request{})
+			}
+			var blocked *BlockedError
+			if !errors.As(err, &blocked) || blocked.Reason != tt.reason {
+				t.Fatalf("err = %v, want a BlockedError for %s", err, tt.reason)
+			}
+			if Classify(err) != ErrBlocked {
+				t.Errorf("Classify = %d, want ErrBlocked", Classify(err))
+			}
+		})
+	}
+}
+
+func TestAnthropicStreamEndsNormally(t *testing.T) {
+	srv, _ := fakeServer(t, http.StatusOK,
+		"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"Hi\"}}\n\n"+
+			"event: message_delta\ndata: {\"type\":\"message_delta\",\"delta\":{\"stop_reason\":\"end_turn\"}}\n\n")
+	resp, err := newTestProvider(t, "anthropic", srv.URL, "k").Stream(context.Background(), Request{}, func(string) {})
+	if err != nil || resp.Text != "Hi" {
+		t.Errorf("got %q, %v; want the reply", resp.Text, err)
+	}
+}
+
+func TestDeltaWriterKeepsRunesWhole(t *testing.T) {
+	var deltas []string
+	w := &deltaWriter{onDelta: func(s string) { deltas = append(deltas, s) }}
+	euro := "€" // three bytes
+	w.write("a" + euro[:1])
+	w.write(euro[1:2])
+	w.write(euro[2:] + "b")
+	if got := w.finish(); got != "a€b" {
+		t.Errorf("text = %q", got)
+	}
+	for _, d := range deltas {
+		if !strings.HasPrefix(d, "a") && !strings.HasPrefix(d, euro) {
+			t.Errorf("delta %q splits a rune", d)
+		}
+	}
+}
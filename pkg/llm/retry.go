@@ -0,0 +1,187 @@
+package llm
+
+import (
+	"context"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Backoff bounds for retries: the first waits about retryBase, doubling with
+// each attempt up to retryMax. A Retry-After longer than maxRetryAfter, as a
+// daily quota gives, is not waited out.
+const (
+	retryBase     = time.Second
+	retryMax      = 30 * time.Second
+	maxRetryAfter = 2 * time.Minute
+)
+
+// Wait is a pause before a request goes out: a backoff before retrying a
+// failed attempt, or holding back for the per-minute limit.
+type Wait struct {
+	Until time.Time
+	// Attempt is the number of the retry about to be made, or 0 when the
+	// rate limiter is holding the request.
+	Attempt int
+	// Retries is how many retries are allowed in all.
+	Retries int
+	// Status is the HTTP status that failed the last attempt, or 0 for a
+	// network error or the rate limiter.
+	Status int
+	Err    error
+}
+
+type waitNotifyKey struct{}
+
+// WithWaitNotify returns a context whose requests call fn before each pause,
+// so the wait can be shown while it lasts.
+func WithWaitNotify(ctx context.Context, fn func(Wait)) context.Context {
+	return context.WithValue(ctx, waitNotifyKey{}, fn)
+}
+
+func notifyWait(ctx context.Context, w Wait) {
+	if fn, ok := ctx.Value(waitNotifyKey{}).(func(Wait)); ok {
+		fn(w)
+	}
+}
+
+// retryTransport retries requests that fail with a network error, 408, 429
+// or a 5xx status, backing off exponentially with jitter or as long as the
+// server's Retry-After asks, and holds requests to the per-minute limit.
+// Retrying happens before any of a reply is read, so a stream never repeats.
+type retryTransport struct {
+	base    http.RoundTripper
+	retries int
+	limit   *limiter
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	for attempt := 0; ; attempt++ {
+		if t.limit != nil {
+			if err := t.limit.wait(ctx, t.retries); err != nil {
+				return nil, err
+			}
+		}
+		try := req
+		if attempt > 0 {
+			if req.GetBody == nil {
+				return nil, errNoRewind
+			}
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			try = req.Clone(ctx)
+			try.Body = body
+		}
+
+		resp, err := t.base.RoundTrip(try)
+		delay, retry := t.backoff(ctx, attempt, resp, err)
+		if !retry {
+			return resp, err
+		}
+		w := Wait{Until: time.Now().Add(delay), Attempt: attempt + 1, Retries: t.retries, Err: err}
+		if resp != nil {
+			w.Status = resp.StatusCode
+			// Drain a little so the connection can be reused.
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+		notifyWait(ctx, w)
+		if err := sleep(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// backoff decides whether a failed attempt is worth retrying and how long to
+// wait first.
+func (t *retryTransport) backoff(ctx context.Context, attempt int, resp *http.Response, err error) (time.Duration, bool) {
+	if attempt >= t.retries || ctx.Err() != nil {
+		return 0, false
+	}
+	if err == nil && !retryable(resp.StatusCode) {
+		return 0, false
+	}
+	if resp != nil {
+		if after, ok := retryAfter(resp.Header); ok {
+			return after, after <= maxRetryAfter
+		}
+	}
+	d := min(retryBase<<attempt, retryMax)
+	// Equal jitter: half the backoff is fixed, the rest random, so clients
+	// that failed together do not all come back at once.
+	return d/2 + rand.N(d/2+1), true
+}
+
+func retryable(status int) bool {
+	switch status {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests,
+		http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout,
+		529: // Anthropic's "overloaded"
+		return true
+	}
+	return false
+}
+
+// retryAfter reads a Retry-After header in seconds or as an HTTP date.
+func retryAfter(h http.Header) (time.Duration, bool) {
+	v := h.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(time.Until(t), 0), true
+	}
+	return 0, false
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// limiter allows at most perMinute requests in any minute.
+type limiter struct {
+	perMinute int
+
+	mu   sync.Mutex
+	sent []time.Time
+}
+
+// wait blocks until a request may be sent, and counts it.
+func (l *limiter) wait(ctx context.Context, retries int) error {
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		for len(l.sent) > 0 && now.Sub(l.sent[0]) >= time.Minute {
+			l.sent = l.sent[1:]
+		}
+		if len(l.sent) < l.perMinute {
+			l.sent = append(l.sent, now)
+			l.mu.Unlock()
+			return nil
+		}
+		until := l.sent[0].Add(time.Minute)
+		l.mu.Unlock()
+
+		notifyWait(ctx, Wait{Until: until, Retries: retries})
+		if err := sleep(ctx, time.Until(until)); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyServer answers with the statuses in turn, then 200, recording the
+// body of each request. A failing answer asks to be retried at once.
+func flakyServer(t *testing.T, statuses ...int) (*httptest.Server, *[]string) {
+	t.Helper()
+	var mu sync.Mutex
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		n := len(bodies)
+		bodies = append(bodies, string(raw))
+		mu.Unlock()
+		if n < len(statuses) {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(statuses[n])
+			return
+		}
+		io.WriteString(w, "ok")
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &bodies
+}
+
+func postTo(t *testing.T, ctx context.Context, url, body string) *http.Request {
+	t.Helper()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+func TestRetryTransportRetries(t *testing.T) {
+	srv, bodies := flakyServer(t, http.StatusTooManyRequests, http.StatusInternalServerError, 529)
+	var waits []Wait
+	ctx := WithWaitNotify(context.Background(), func(w Wait) { waits = append(waits, w) })
+	rt := &retryTransport{base: http.DefaultTransport, retries: 3}
+
+	resp, err := rt.RoundTrip(postTo(t, ctx, srv.URL, `{"n":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want 200", resp.StatusCode)
+	}
+	// The body is sent again, whole, with every attempt.
+	if want := []string{`{"n":1}`, `{"n":1}`, `{"n":1}`, `{"n":1}`}; strings.Join(*bodies, " ") != strings.Join(want, " ") {
+		t.Errorf("bodies = %q, want %q", *bodies, want)
+	}
+	if len(waits) != 3 {
+		t.Fatalf("waits = %+v, want 3", waits)
+	}
+	for i, status := range []int{429, 500, 529} {
+		if w := waits[i]; w.Attempt != i+1 || w.Retries != 3 || w.Status != status {
+			t.Errorf("wait %d = %+v, want attempt %d of 3 after %d", i, w, i+1, status)
+		}
+	}
+}
+
+func TestRetryTransportGivesUp(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		retries  int
+		want     int
+		attempts int
+	}{
+		{"out of retries", []int{503, 503, 503}, 2, 503, 3},
+		{"not retryable", []int{400}, 3, 400, 1},
+		{"unauthorised", []int{401}, 3, 401, 1},
+		{"no retries", []int{503}, 0, 503, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, bodies := flakyServer(t, tt.statuses...)
+			rt := &retryTransport{base: http.DefaultTransport, retries: tt.retries}
+			resp, err := rt.RoundTrip(postTo(t, context.Background(), srv.URL, "{}"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want || len(*bodies) != tt.attempts {
+				t.Errorf("got %d after %d attempt(s), want %d after %d", resp.StatusCode, len(*bodies), tt.want, tt.attempts)
+			}
+		})
+	}
+}
+
+func TestRetryTransportNeedsRewind(t *testing.T) {
+	srv, bodies := flakyServer(t, http.StatusServiceUnavailable)
+	req := postTo(t, context.Background(), srv.URL, "{}")
+	req.GetBody = nil
+	rt := &retryTransport{base: http.DefaultTransport, retries: 3}
+	if _, err := rt.RoundTrip(req); !errors.Is(err, errNoRewind) {
+		t.Errorf("err = %v, want errNoRewind", err)
+	}
+	if len(*bodies) != 1 {
+		t.Errorf("%d attempts, want 1", len(*bodies))
+	}
+}
+
+func TestRetryTransportCancelDuringBackoff(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	ctx = WithWaitNotify(ctx, func(Wait) { cancel() })
+	rt := &retryTransport{base: http.DefaultTransport, retries: 3}
+
+	start := time.Now()
+	if _, err := rt.RoundTrip(postTo(t, ctx, srv.URL, "{}")); !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("took %s to notice the cancellation", d)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	rt := &retryTransport{retries: 5}
+	ctx := context.Background()
+	withAfter := func(v string) *http.Response {
+		return &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": {v}}}
+	}
+
+	if d, ok := rt.backoff(ctx, 0, withAfter("7"), nil); !ok || d != 7*time.Second {
+		t.Errorf("Retry-After in seconds: %s %v, want 7s", d, ok)
+	}
+	date := time.Now().Add(20 * time.Second).UTC().Format(http.TimeFormat)
+	if d, ok := rt.backoff(ctx, 0, withAfter(date), nil); !ok || d < 15*time.Second || d > 20*time.Second {
+		t.Errorf("Retry-After as a date: %s %v, want about 20s", d, ok)
+	}
+	past := time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+	if d, ok := rt.backoff(ctx, 0, withAfter(past), nil); !ok || d != 0 {
+		t.Errorf("Retry-After in the past: %s %v, want 0", d, ok)
+	}
+	if _, ok := rt.backoff(ctx, 0, withAfter("3600"), nil); ok {
+		t.Error("retried a Retry-After longer than maxRetryAfter")
+	}
+
+	// Without Retry-After the wait doubles with each attempt, half of it
+	// random, up to retryMax.
+	resp := &http.Response{StatusCode: http.StatusBadGateway, Header: http.Header{}}
+	for attempt, base := range map[int]time.Duration{0: retryBase, 1: 2 * retryBase, 2: 4 * retryBase, 4: 16 * retryBase} {
+		d, ok := rt.backoff(ctx, attempt, resp, nil)
+		if !ok || d < base/2 || d > base {
+			t.Errorf("attempt %d: %s %v, want between %s and %s", attempt, d, ok, base/2, base)
+		}
+	}
+	capped := &retryTransport{retries: 20}
+	if d, _ := capped.backoff(ctx, 10, resp, nil); d > retryMax {
+		t.Errorf("attempt 10: %s, want at most %s", d, retryMax)
+	}
+	if _, ok := rt.backoff(ctx, 0, nil, io.ErrUnexpectedEOF); !ok {
+		t.Error("a network error was not retried")
+	}
+	if _, ok := rt.backoff(ctx, 5, resp, nil); ok {
+		t.Error("retried past the limit")
+	}
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, ok := rt.backoff(cancelled, 0, resp, nil); ok {
+		t.Error("retried a cancelled request")
+	}
+}
+
+func TestRetryAfterIgnoresNonsense(t *testing.T) {
+	for _, v := range []string{"", "soon", "-5"} {
+		if _, ok := retryAfter(http.Header{"Retry-After": {v}}); ok {
+			t.Errorf("Retry-After %q was accepted", v)
+		}
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	// One request a minute, the last sent almost a minute ago.
+	l := &limiter{perMinute: 1, sent: []time.Time{time.Now().Add(-time.Minute + 50*time.Millisecond)}}
+	var waits []Wait
+	ctx := WithWaitNotify(context.Background(), func(w Wait) { waits = append(waits, w) })
+
+	start := time.Now()
+	if err := l.wait(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 40*time.Millisecond {
+		t.Errorf("waited %s, want about 50ms", d)
+	}
+	if len(waits) != 1 || waits[0].Attempt != 0 || waits[0].Retries != 2 {
+		t.Errorf("waits = %+v, want one from the limiter", waits)
+	}
+	if len(l.sent) != 1 {
+		t.Errorf("%d requests counted, want the old one forgotten", len(l.sent))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := l.wait(ctx, 0); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want the context's", err)
+	}
+}
+
+func TestLimiterAllowsBurstWithinLimit(t *testing.T) {
+	l := &limiter{perMinute: 3}
+	ctx := WithWaitNotify(context.Background(), func(w Wait) { t.Errorf("held back: %+v", w) })
+	for range 3 {
+		if err := l.wait(ctx, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestNewAddsRetries(t *testing.T) {
+	srv, bodies := flakyServer(t, http.StatusServiceUnavailable)
+	p, err := New(Config{Provider: "ollama", BaseURL: srv.URL, Retries: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The fake server's "ok" is not a chat reply, but it shows the request
+	// got through on the second attempt.
+	p.Complete(context.Background(), Request{})
+	if len(*bodies) != 2 || (*bodies)[0] != (*bodies)[1] {
+		t.Errorf("bodies = %q, want the same request twice", *bodies)
+	}
+}
@@ -0,0 +1,198 @@
+package llm
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Tokenizer estimates how many tokens a model splits text into.
+type Tokenizer func(string) int
+
+// TokenizerFor returns an approximate tokenizer for a provider. None is exact;
+// they aim to be within a fifth or so on English and source code.
+func TokenizerFor(provider string) Tokenizer {
+	switch strings.ToLower(provider) {
+	case "anthropic":
+		return wordPieces(5)
+	case "ollama", "llamacpp", "llama.cpp":
+		// Local models often have smaller vocabularies.
+		return wordPieces(4.5)
+	}
+	return wordPieces(6)
+}
+
+// DefaultTokenLimit is the context window assumed for a provider's models
+// when none is configured.
+func DefaultTokenLimit(provider string) int {
+	switch strings.ToLower(provider) {
+	case "openai":
+		return 128000
+	case "anthropic":
+		return 200000
+	case "ollama", "llamacpp", "llama.cpp":
+		return 8192
+	}
+	return 1000000
+}
+
+// wordPieces counts tokens roughly the way byte-pair encoders split text: a
+// word costs a token per lettersPerToken letters, a run of digits a token per
+// three and punctuation a token per two characters, newlines included; a run
+// of indentation is one token and a single space joins what follows it.
+// Ideographs cost a token apiece.
+func wordPieces(lettersPerToken float64) Tokenizer {
+	return func(s string) int {
+		tokens := 0
+		letters, digits, spaces, symbols := 0, 0, 0, 0
+		flush := func() {
+			if letters > 0 {
+				tokens += int(float64(letters-1)/lettersPerToken) + 1
+			}
+			if digits > 0 {
+				tokens += (digits + 2) / 3
+			}
+			if spaces > 1 {
+				tokens++
+			}
+			tokens += (symbols + 1) / 2
+			letters, digits, spaces, symbols = 0, 0, 0, 0
+		}
+		for _, r := range s {
+			switch {
+			case r == ' ' || r == '\t':
+				if letters > 0 || digits > 0 || symbols > 0 {
+					flush()
+				}
+				spaces++
+			case r < 0x80 && ('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || r == '_'):
+				if digits > 0 || symbols > 0 {
+					flush()
+				}
+				letters++
+			case '0' <= r && r <= '9':
+				if letters > 0 {
+					letters++
+					continue
+				}
+				if symbols > 0 {
+					flush()
+				}
+				digits++
+			case unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul):
+				flush()
+				tokens++
+			case unicode.IsLetter(r):
+				// Accented letters take more than one byte and split words more.
+				letters += 2
+			default:
+				if letters > 0 || digits > 0 {
+					flush()
+				}
+				symbols++
+			}
+		}
+		flush()
+		return tokens
+	}
+}
+
+// RequestTokens estimates the size of a request, including the tools offered.
+func RequestTokens(req Request, count Tokenizer) int {
+	if count == nil {
+		count = EstimateTokens
+	}
+	n := count(req.System)
+	for _, m := range req.Messages {
+		// Each message carries a few tokens of framing.
+		n += count(m.Content) + 4
+		for _, call := range m.ToolCalls {
+			n += count(call.Name) + count(string(call.Args))
+		}
+	}
+	for _, t := range req.Tools {
+		params, _ := json.Marshal(t.Parameters)
+		n += count(t.Name) + count(t.Description) + count(string(params))
+	}
+	return n
+}
+
+// minAttachmentTokens is the smallest share of the budget worth sending; an
+// attachment that would get less is left out instead.
+const minAttachmentTokens = 100
+
+// FitAttachments trims attachments to take about budget tokens between them.
+// Each gets an equal share, and what the smaller ones do not need goes to the
+// larger. An attachment over its share is cut at a line boundary and
+// relabelled, or marked Omitted with no content if its share is too small
+// for that to be useful.
+func FitAttachments(as []Attachment, budget int, count Tokenizer) []Attachment {
+	if count == nil {
+		count = EstimateTokens
+	}
+	costs := make([]int, len(as))
+	total := 0
+	for i, a := range as {
+		costs[i] = attachmentTokens(a, count)
+		total += costs[i]
+	}
+	if total <= budget {
+		return as
+	}
+
+	out := append([]Attachment(nil), as...)
+	order := make([]int, len(as))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return costs[order[i]] < costs[order[j]] })
+
+	left := max(budget, 0)
+	for k, i := range order {
+		share := left / (len(order) - k)
+		if costs[i] <= share {
+			left -= costs[i]
+			continue
+		}
+		room := share - (costs[i] - count(as[i].Content))
+		if room < minAttachmentTokens || !truncateAttachment(&out[i], room, count) {
+			out[i].Content = ""
+			out[i].Omitted = true
+			continue
+		}
+		left -= share
+	}
+	return out
+}
+
+// attachmentTokens is what an attachment adds to a request, framing included.
+func attachmentTokens(a Attachment, count Tokenizer) int {
+	return count(a.Content) + count(a.Name) + count(a.Label) + 16
+}
+
+// truncateAttachment keeps the lines of a that fit in limit tokens, reporting
+// false if not even the first one does.
+func truncateAttachment(a *Attachment, limit int, count Tokenizer) bool {
+	lines := strings.Split(a.Content, "\n")
+	used, kept := 0, 0
+	for kept < len(lines) {
+		n := count(lines[kept]) + 1
+		if used+n > limit {
+			break
+		}
+		used += n
+		kept++
+	}
+	if kept == 0 {
+		return false
+	}
+	cut := fmt.Sprintf("first %d of %d lines", kept, len(lines))
+	if a.Label != "" {
+		cut = a.Label + ", " + cut
+	}
+	a.Content = strings.Join(lines[:kept], "\n")
+	a.Label = cut
+	return true
+}
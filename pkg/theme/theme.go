@@ -0,0 +1,197 @@
+package theme
+
+import (
+	"embed"
+	"fmt"
+	"image/color"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/charmbracelet/colorprofile"
+	"github.com/charmbracelet/x/ansi"
+	"github.com/lucasb-eyer/go-colorful"
+)
+
+// Default is the theme used when none is configured.
+const Default = "dark"
+
+//go:embed themes/*.toml
+var builtin embed.FS
+
+// UI holds the colours of the editor chrome.
+type UI struct {
+	Text       string `toml:"text"`
+	SubText    string `toml:"subtext"`
+	Primary    string `toml:"primary"`
+	Secondary  string `toml:"secondary"`
+	Accent     string `toml:"accent"`
+	Success    string `toml:"success"`
+	Warning    string `toml:"warning"`
+	Error      string `toml:"error"`
+	Dark       string `toml:"dark"`
+	StatusLine string `toml:"statusline"`
+	TabBar     string `toml:"tabbar"`
+	CursorLine string `toml:"cursorline"`
+}
+
+// Modes holds the status line mode badge colours.
+type Modes struct {
+	Foreground string `toml:"foreground"`
+	Normal     string `toml:"normal"`
+	Insert     string `toml:"insert"`
+	Visual     string `toml:"visual"`
+	Command    string `toml:"command"`
+}
+
+// Pair is a foreground/background colour pair.
+type Pair struct {
+	Fg string `toml:"fg"`
+	Bg string `toml:"bg"`
+}
+
+// Syntax holds the colours for syntax token classes.
+type Syntax struct {
+	Keyword     string `toml:"keyword"`
+	String      string `toml:"string"`
+	Number      string `toml:"number"`
+	Comment     string `toml:"comment"`
+	Function    string `toml:"function"`
+	Type        string `toml:"type"`
+	Constant    string `toml:"constant"`
+	Operator    string `toml:"operator"`
+	Punctuation string `toml:"punctuation"`
+	Heading     string `toml:"heading"`
+	Link        string `toml:"link"`
+}
+
+// Theme is a named colour scheme loaded from TOML.
+type Theme struct {
+	Name      string `toml:"name"`
+	UI        UI     `toml:"ui"`
+	Modes     Modes  `toml:"modes"`
+	Selection Pair   `toml:"selection"`
+	Search    Pair   `toml:"search"`
+	Syntax    Syntax `toml:"syntax"`
+}
+
+// Dir returns the directory user themes are loaded from.
+func Dir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "boba-text", "themes")
+}
+
+// Load returns the named theme, preferring a user theme file over a built-in.
+// Themes are decoded over the default theme, so a theme file only needs the
+// colours it changes.
+func Load(name string) (Theme, error) {
+	var t Theme
+	base, err := builtin.ReadFile("themes/" + Default + ".toml")
+	if err != nil {
+		return t, err
+	}
+	if _, err := toml.Decode(string(base), &t); err != nil {
+		return t, err
+	}
+	if name == "" {
+		name = Default
+	}
+
+	if dir := Dir(); dir != "" {
+		path := filepath.Join(dir, name+".toml")
+		if _, err := os.Stat(path); err == nil {
+			if _, err := toml.DecodeFile(path, &t); err != nil {
+				return t, fmt.Errorf("theme %s: %w", path, err)
+			}
+			t.Name = name
+			return t, nil
+		}
+	}
+
+	data, err := builtin.ReadFile("themes/" + name + ".toml")
+	if err != nil {
+		return t, fmt.Errorf("unknown colorscheme %q", name)
+	}
+	if _, err := toml.Decode(string(data), &t); err != nil {
+		return t, fmt.Errorf("theme %s: %w", name, err)
+	}
+	t.Name = name
+	return t, nil
+}
+
+// Names lists the available built-in and user themes, sorted.
+func Names() []string {
+	seen := map[string]bool{}
+	add := func(entries []os.DirEntry) {
+		for _, e := range entries {
+			if name, ok := strings.CutSuffix(e.Name(), ".toml"); ok && !e.IsDir() {
+				seen[name] = true
+			}
+		}
+	}
+	if entries, err := builtin.ReadDir("themes"); err == nil {
+		add(entries)
+	}
+	if dir := Dir(); dir != "" {
+		if entries, err := os.ReadDir(dir); err == nil {
+			add(entries)
+		}
+	}
+
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Adapt converts a theme colour to the closest one the colour profile can
+// show: hex on true-colour terminals, a palette index on 256 or 16 colour
+// terminals, and no colour at all when colour is unsupported.
+func Adapt(p colorprofile.Profile, c string) string {
+	if c == "" || p == colorprofile.TrueColor {
+		return c
+	}
+	var src color.Color
+	if strings.HasPrefix(c, "#") {
+		hex, err := colorful.Hex(expandHex(c))
+		if err != nil {
+			return c
+		}
+		src = hex
+	} else if n, err := strconv.Atoi(c); err == nil && n >= 0 && n <= 255 {
+		if n < 16 {
+			src = ansi.BasicColor(n)
+		} else {
+			src = ansi.ExtendedColor(n)
+		}
+	} else {
+		return c
+	}
+
+	switch out := p.Convert(src).(type) {
+	case nil:
+		return ""
+	case ansi.BasicColor:
+		return strconv.Itoa(int(out))
+	case ansi.ExtendedColor:
+		return strconv.Itoa(int(out))
+	default:
+		return c
+	}
+}
+
+// expandHex turns "#RGB" into "#RRGGBB".
+func expandHex(c string) string {
+	if len(c) != 4 {
+		return c
+	}
+	return string([]byte{'#', c[1], c[1], c[2], c[2], c[3], c[3]})
+}
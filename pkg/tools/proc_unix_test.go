@@ -0,0 +1,33 @@
+//go:build !windows
+
+package tools
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRunCommandDoesNotWaitForBackground(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		timeout time.Duration
+	}{
+		// The shell exits at once, leaving sleep holding its output open.
+		{"left running", "sleep 30 & echo started", time.Minute},
+		// Cancelling kills the shell and both of its children.
+		{"cancelled", "sleep 30 & sleep 30", 100 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+			defer cancel()
+			start := time.Now()
+			Workspace{Root: t.TempDir()}.runCommand(ctx, tt.command)
+			if d := time.Since(start); d > commandWaitDelay+2*time.Second {
+				t.Errorf("took %s, want at most about %s", d, commandWaitDelay)
+			}
+		})
+	}
+}
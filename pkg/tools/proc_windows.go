@@ -0,0 +1,9 @@
+//go:build windows
+
+package tools
+
+import "os/exec"
+
+// killGroup is a no-op on Windows, where cancelling kills only the shell
+// and WaitDelay frees the output of anything it started.
+func killGroup(cmd *exec.Cmd) {}
@@ -0,0 +1,541 @@
+package tools
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/CiaranMccarthy1/boba-text/pkg/fileio"
+	"github.com/CiaranMccarthy1/boba-text/pkg/llm"
+)
+
+// Tool names.
+const (
+	ReadFile   = "read_file"
+	ListDir    = "list_dir"
+	Grep       = "grep"
+	ApplyEdit  = "apply_edit"
+	RunCommand = "run_command"
+)
+
+// Output limits keep tool results from flooding the model's context.
+const (
+	maxReadBytes   = 200 * 1024
+	maxListEntries = 500
+	maxGrepMatches = 200
+	maxGrepFile    = 1 << 20
+	maxOutputBytes = 32 * 1024
+
+	// CommandTimeout bounds a single run_command call.
+	CommandTimeout = 60 * time.Second
+
+	// commandWaitDelay is how long run_command waits, once the shell has
+	// exited or been killed, for anything else holding its output open.
+	commandWaitDelay = 3 * time.Second
+)
+
+// skipDirs are not searched by grep.
+var skipDirs = map[string]bool{".git": true, "node_modules": true, ".hg": true, ".svn": true}
+
+// SkipDir reports whether a directory is version-control or dependency
+// storage that searches should not descend into.
+func SkipDir(name string) bool {
+	return skipDirs[name]
+}
+
+// Workspace runs the agent's tools confined to a project directory.
+type Workspace struct {
+	Root string
+}
+
+// NeedsApproval reports whether a tool changes anything and so must be
+// approved by the user before it runs.
+func NeedsApproval(name string) bool {
+	return name == ApplyEdit || name == RunCommand
+}
+
+func object(required []string, props map[string]any) map[string]any {
+	return map[string]any{"type": "object", "properties": props, "required": required}
+}
+
+func str(desc string) map[string]any {
+	return map[string]any{"type": "string", "description": desc}
+}
+
+func integer(desc string) map[string]any {
+	return map[string]any{"type": "integer", "description": desc}
+}
+
+// Definitions describes the tools to the model.
+func (w Workspace) Definitions() []llm.Tool {
+	return []llm.Tool{
+		{
+			Name:        ReadFile,
+			Description: "Read a text file in the project. Paths are relative to the project root.",
+			Parameters: object([]string{"path"}, map[string]any{
+				"path":       str("File path relative to the project root"),
+				"start_line": integer("First line to return, 1-based (optional)"),
+				"end_line":   integer("Last line to return, inclusive (optional)"),
+			}),
+		},
+		{
+			Name:        ListDir,
+			Description: "List a directory in the project. Directories are shown with a trailing slash.",
+			Parameters: object([]string{"path"}, map[string]any{
+				"path": str("Directory path relative to the project root; \".\" for the root"),
+			}),
+		},
+		{
+			Name:        Grep,
+			Description: "Search project files for a regular expression. Returns path:line: text for each match.",
+			Parameters: object([]string{"pattern"}, map[string]any{
+				"pattern": str("RE2 regular expression"),
+				"path":    str("Directory or file to search, relative to the project root (optional)"),
+				"glob":    str("Only search files whose name matches this glob, e.g. *.go (optional)"),
+			}),
+		},
+		{
+			Name: ApplyEdit,
+			Description: "Replace old_text with new_text in a file. old_text must occur exactly once. " +
+				"To create a new file, give an empty old_text. The user reviews the change before it is written.",
+			Parameters: object([]string{"path", "old_text", "new_text"}, map[string]any{
+				"path":     str("File path relative to the project root"),
+				"old_text": str("Exact text to replace, including enough context to be unique"),
+				"new_text": str("Replacement text"),
+			}),
+		},
+		{
+			Name: RunCommand,
+			Description: "Run a shell command in the project root and return its combined output and exit status. " +
+				"The user approves each command before it runs.",
+			Parameters: object([]string{"command"}, map[string]any{
+				"command": str("Command line to run"),
+			}),
+		},
+	}
+}
+
+// Describe summarises a call for display, e.g. "read_file main.go".
+func Describe(call llm.ToolCall) string {
+	var args map[string]any
+	json.Unmarshal(call.Args, &args)
+	get := func(k string) string {
+		v, _ := args[k].(string)
+		return v
+	}
+	switch call.Name {
+	case ReadFile, ListDir, ApplyEdit:
+		return call.Name + " " + get("path")
+	case Grep:
+		desc := fmt.Sprintf("grep %q", get("pattern"))
+		if p := get("path"); p != "" {
+			desc += " in " + p
+		}
+		if g := get("glob"); g != "" {
+			desc += " (" + g + ")"
+		}
+		return desc
+	case RunCommand:
+		return "run_command $ " + get("command")
+	}
+	return call.Name + " " + string(call.Args)
+}
+
+// Run executes a read-only tool or run_command. apply_edit goes through
+// PrepareEdit and WriteEdit instead, so the change can be reviewed in between.
+func (w Workspace) Run(ctx context.Context, call llm.ToolCall) (string, error) {
+	switch call.Name {
+	case ReadFile:
+		var args struct {
+			Path      string `json:"path"`
+			StartLine int    `json:"start_line"`
+			EndLine   int    `json:"end_line"`
+		}
+		if err := json.Unmarshal(call.Args, &args); err != nil {
+			return "", fmt.Errorf("bad arguments: %w", err)
+		}
+		return w.readFile(args.Path, args.StartLine, args.EndLine)
+	case ListDir:
+		var args struct {
+			Path string `json:"path"`
+		}
+		if err := json.Unmarshal(call.Args, &args); err != nil {
+			return "", fmt.Errorf("bad arguments: %w", err)
+		}
+		return w.listDir(args.Path)
+	case Grep:
+		var args struct {
+			Pattern string `json:"pattern"`
+			Path    string `json:"path"`
+			Glob    string `json:"glob"`
+		}
+		if err := json.Unmarshal(call.Args, &args); err != nil {
+			return "", fmt.Errorf("bad arguments: %w", err)
+		}
+		return w.grep(ctx, args.Pattern, args.Path, args.Glob)
+	case RunCommand:
+		var args struct {
+			Command string `json:"command"`
+		}
+		if err := json.Unmarshal(call.Args, &args); err != nil {
+			return "", fmt.Errorf("bad arguments: %w", err)
+		}
+		return w.runCommand(ctx, args.Command)
+	case ApplyEdit:
+		return "", errors.New("apply_edit must be prepared and approved")
+	}
+	return "", fmt.Errorf("unknown tool %q", call.Name)
+}
+
+// Resolve turns a path given by the model into an absolute path, refusing
+// anything that is, or links to somewhere, outside the workspace.
+func (w Workspace) Resolve(path string) (string, error) {
+	root, err := filepath.Abs(w.Root)
+	if err != nil {
+		return "", err
+	}
+	name := path
+	if path == "" {
+		path = "."
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(root, path)
+	}
+	path = filepath.Clean(path)
+	if !within(realPath(root), realPath(path)) {
+		return "", fmt.Errorf("%s is outside the project", name)
+	}
+	return path, nil
+}
+
+// realPath resolves symlinks in the longest existing prefix of path.
+func realPath(path string) string {
+	rest := ""
+	for p := path; ; p = filepath.Dir(p) {
+		if real, err := filepath.EvalSymlinks(p); err == nil {
+			return filepath.Join(real, rest)
+		}
+		if filepath.Dir(p) == p {
+			return path
+		}
+		rest = filepath.Join(filepath.Base(p), rest)
+	}
+}
+
+func within(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func (w Workspace) rel(path string) string {
+	root, _ := filepath.Abs(w.Root)
+	if rel, err := filepath.Rel(root, path); err == nil {
+		return filepath.ToSlash(rel)
+	}
+	return path
+}
+
+// readFile returns lines start to end of a file, reading no more of it than
+// the output limit allows.
+func (w Workspace) readFile(name string, start, end int) (string, error) {
+	path, err := w.Resolve(name)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		return "", fmt.Errorf("%s is a directory", name)
+	}
+
+	if start < 1 {
+		start = 1
+	}
+	if end >= 1 && end < start {
+		return "", fmt.Errorf("end_line %d is before start_line %d", end, start)
+	}
+	r := bufio.NewReader(f)
+	for n := 1; n < start; n++ {
+		if !skipLine(r) {
+			return "", fmt.Errorf("%s has %d lines", name, n-1)
+		}
+	}
+	data, err := io.ReadAll(io.LimitReader(r, maxReadBytes+1))
+	if err != nil {
+		return "", err
+	}
+	if start > 1 && len(data) == 0 {
+		return "", fmt.Errorf("%s has %d lines", name, start-1)
+	}
+	if bytes.IndexByte(data, 0) >= 0 {
+		return "", fmt.Errorf("%s looks like a binary file", name)
+	}
+	limited := len(data) > maxReadBytes
+	if limited {
+		// Cut before decoding, so a character split by the limit does not
+		// make the text look like Latin-1.
+		data = []byte(fileio.TruncateUTF8(string(data), maxReadBytes))
+	}
+	text, _, err := fileio.Decode(data)
+	if err != nil {
+		return "", err
+	}
+
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	if end >= 1 && end-start+1 < len(lines) {
+		// The range ends before the limit did.
+		lines, limited = lines[:end-start+1], false
+	}
+	out := strings.Join(lines, "\n")
+	if limited {
+		out = fileio.TruncateUTF8(out, maxReadBytes)
+		out += fmt.Sprintf("\n[truncated; %s is %d KB, read on from line %d with start_line]",
+			name, (info.Size()+1023)/1024, start+len(lines)-1)
+	}
+	return out, nil
+}
+
+// skipLine reads past the next line, ended as Decode ends lines by "\n",
+// "\r\n" or a lone "\r", reporting false at the end of the file.
+func skipLine(r *bufio.Reader) bool {
+	for n := 0; ; n++ {
+		c, err := r.ReadByte()
+		if err != nil {
+			return n > 0
+		}
+		switch c {
+		case '\n':
+			return true
+		case '\r':
+			if next, err := r.Peek(1); err == nil && next[0] == '\n' {
+				r.ReadByte()
+			}
+			return true
+		}
+	}
+}
+
+func (w Workspace) listDir(name string) (string, error) {
+	path, err := w.Resolve(name)
+	if err != nil {
+		return "", err
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for i, e := range entries {
+		if i == maxListEntries {
+			fmt.Fprintf(&b, "[%d more entries]\n", len(entries)-i)
+			break
+		}
+		b.WriteString(e.Name())
+		if e.IsDir() {
+			b.WriteString("/")
+		}
+		b.WriteString("\n")
+	}
+	if b.Len() == 0 {
+		return "(empty directory)", nil
+	}
+	return b.String(), nil
+}
+
+func (w Workspace) grep(ctx context.Context, pattern, name, glob string) (string, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", fmt.Errorf("bad pattern: %w", err)
+	}
+	start, err := w.Resolve(name)
+	if err != nil {
+		return "", err
+	}
+
+	var matches []string
+	errLimit := errors.New("limit")
+	err = filepath.WalkDir(start, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if d.IsDir() {
+			if path != start && skipDirs[d.Name()] {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if glob != "" {
+			if ok, _ := filepath.Match(glob, d.Name()); !ok {
+				return nil
+			}
+		}
+		if info, err := d.Info(); err != nil || !info.Mode().IsRegular() || info.Size() > maxGrepFile {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil || bytes.IndexByte(data, 0) >= 0 {
+			return nil
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		scanner.Buffer(make([]byte, 64*1024), maxGrepFile)
+		for n := 1; scanner.Scan(); n++ {
+			if re.MatchString(scanner.Text()) {
+				matches = append(matches, fmt.Sprintf("%s:%d: %s", w.rel(path), n, strings.TrimSpace(scanner.Text())))
+				if len(matches) == maxGrepMatches {
+					return errLimit
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil && err != errLimit {
+		return "", err
+	}
+	if len(matches) == 0 {
+		return "no matches", nil
+	}
+	sort.Strings(matches)
+	out := strings.Join(matches, "\n")
+	if err == errLimit {
+		out += fmt.Sprintf("\n[stopped after %d matches; narrow the search]", maxGrepMatches)
+	}
+	return out, nil
+}
+
+func (w Workspace) runCommand(ctx context.Context, command string) (string, error) {
+	if strings.TrimSpace(command) == "" {
+		return "", errors.New("empty command")
+	}
+	root, err := w.Resolve(".")
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(ctx, CommandTimeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	cmd.Dir = root
+	killGroup(cmd)
+	cmd.WaitDelay = commandWaitDelay
+	out, err := cmd.CombinedOutput()
+
+	result := string(out)
+	if len(result) > maxOutputBytes {
+		result = "[output truncated to the last part]\n" + result[len(result)-maxOutputBytes:]
+	}
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		result += fmt.Sprintf("\n[timed out after %s]", CommandTimeout)
+	case err != nil:
+		result += "\n[" + err.Error() + "]"
+	default:
+		result += "\n[exit status 0]"
+	}
+	return strings.TrimLeft(result, "\n"), nil
+}
+
+// Edit is a prepared apply_edit call: the file's text before and after.
+type Edit struct {
+	Path   string
+	Before string
+	After  string
+	format fileio.Format
+}
+
+// PrepareEdit works out what an apply_edit call would do without writing it.
+func (w Workspace) PrepareEdit(call llm.ToolCall) (Edit, error) {
+	var args struct {
+		Path    string `json:"path"`
+		OldText string `json:"old_text"`
+		NewText string `json:"new_text"`
+	}
+	if err := json.Unmarshal(call.Args, &args); err != nil {
+		return Edit{}, fmt.Errorf("bad arguments: %w", err)
+	}
+	path, err := w.Resolve(args.Path)
+	if err != nil {
+		return Edit{}, err
+	}
+	e := Edit{Path: path, format: fileio.DefaultFormat()}
+
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		if args.OldText != "" {
+			return Edit{}, fmt.Errorf("%s does not exist", args.Path)
+		}
+		e.After = args.NewText
+		return e, nil
+	case err != nil:
+		return Edit{}, err
+	}
+	if e.Before, e.format, err = fileio.Decode(data); err != nil {
+		return Edit{}, err
+	}
+	if args.OldText == "" {
+		return Edit{}, fmt.Errorf("%s already exists; give the old_text to replace", args.Path)
+	}
+	switch n := strings.Count(e.Before, args.OldText); n {
+	case 0:
+		return Edit{}, fmt.Errorf("old_text not found in %s", args.Path)
+	case 1:
+	default:
+		return Edit{}, fmt.Errorf("old_text occurs %d times in %s; include more context", n, args.Path)
+	}
+	e.After = strings.Replace(e.Before, args.OldText, args.NewText, 1)
+	return e, nil
+}
+
+// WriteEdit writes an approved edit, refusing if the file changed since it
+// was prepared.
+func (w Workspace) WriteEdit(e Edit, opts fileio.SaveOptions) error {
+	current := ""
+	data, err := os.ReadFile(e.Path)
+	switch {
+	case err == nil:
+		if current, _, err = fileio.Decode(data); err != nil {
+			return err
+		}
+	case !errors.Is(err, fs.ErrNotExist):
+		return err
+	}
+	if current != e.Before {
+		return fmt.Errorf("%s changed since the edit was proposed", w.rel(e.Path))
+	}
+	out, err := fileio.Encode(e.After, e.format)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(e.Path), 0755); err != nil {
+		return err
+	}
+	return fileio.Save(e.Path, out, opts)
+}
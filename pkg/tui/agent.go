@@ -1,26 +1,50 @@
 package tui
 
 import (
+	"context"
 	"fmt"
+	"slices"
 	"strings"
+	"time"
 
+	"github.com/CiaranMccarthy1/boba-text/pkg/chats"
 	"github.com/CiaranMccarthy1/boba-text/pkg/config"
+	"github.com/CiaranMccarthy1/boba-text/pkg/diff"
+	"github.com/CiaranMccarthy1/boba-text/pkg/llm"
+	"github.com/CiaranMccarthy1/boba-text/pkg/tools"
 	"github.com/charmbracelet/bubbles/textarea"
 	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
 )
 
 // PendingRewrite holds a proposed file rewrite from the AI awaiting user approval.
 type PendingRewrite struct {
 	FilePath string
 	Content  string
+	// Original is the text the proposal was made against.
+	Original string
+	root     string
+
+	// hunks is the proposal split for review; states and edited record the
+	// reviewer's decision on each. msgIndex is the conversation entry showing it.
+	hunks    []diff.Hunk
+	states   []hunkState
+	edited   []bool
+	msgIndex int
 }
 
 // GeminiResponseMsg carries the AI response back to the TUI from the async call.
 type GeminiResponseMsg struct {
 	Response string
-	Err      error
+	// ToolCalls are the tools the assistant asked to run before it answers.
+	ToolCalls []llm.ToolCall
+	Err       error
+	// Streamed is set when the reply was already shown piece by piece via
+	// aiStreamMsg; Response then repeats the text received.
+	Streamed bool
+	id       int
 }
 
 type AgentModel struct {
@@ -37,29 +61,96 @@ type AgentModel struct {
 	waiting        bool
 	pendingRewrite *PendingRewrite
 	currentFile    string
+	// cursorFunction labels the function under the editor's cursor, for
+	// the context chip in function mode.
+	cursorFunction string
+	provider       llm.Provider
+	providerErr    error
+	// apiKey is the key looked up by keySettings, or keyErr why there is
+	// none. Until the lookup finishes, provider is nil and providerErr is
+	// errKeyPending.
+	apiKey      string
+	keyErr      error
+	keySettings keySettings
+
+	// replyIndex is the entry in messages holding the reply being generated.
+	replyIndex int
+	reply      string
+	// conv is the structured history sent with each request; messages is
+	// only its rendering.
+	conv    llm.Conversation
+	welcome string
+	// requestID identifies the request in flight; cancel aborts it.
+	requestID int
+	cancel    context.CancelFunc
+	// root is the project directory proposed file paths are relative to;
+	// rewrites queues proposals waiting behind pendingRewrite.
+	root     string
+	rewrites []*PendingRewrite
+	// hunk is the hunk of pendingRewrite under review; hunkPrefix holds a
+	// pending "]" or "[" and editingHunk is set while the textarea edits it.
+	hunk        int
+	hunkPrefix  string
+	editingHunk bool
+	// ctx spans the whole prompt, including the tool calls made while
+	// answering it; steps counts them against the configured limit.
+	ctx   context.Context
+	steps int
+	// toolCalls queues the calls still to run, running is the one in
+	// progress and approval the one waiting for the user's answer.
+	toolCalls []llm.ToolCall
+	running   *llm.ToolCall
+	approval  *toolApproval
+	// pinned is context picked with :Ask for the next prompt. Otherwise
+	// contextMode decides, and the Model puts the matching part of the
+	// live buffer in live just before a prompt is sent.
+	pinned      *llm.Attachment
+	contextMode contextMode
+	live        *llm.Attachment
+	// buffer is the whole live buffer, for @buffer mentions.
+	buffer *llm.Attachment
+	// projectFiles caches the completions for @mentions, loaded at
+	// filesLoaded; suggestions complete the slash command or mention being
+	// typed and suggestion is the highlighted one.
+	projectFiles []string
+	filesLoaded  time.Time
+	suggestions  []string
+	suggestion   int
+
+	// tokens is the estimated size of the request the conversation would
+	// make now, shown against the token limit.
+	tokens int
+
+	// snippets are the code blocks of finished replies, numbered from 1 as
+	// shown in the pane.
+	snippets []string
+
+	// chat is the saved chat the conversation is kept in, without its
+	// conversation; history is the browser of saved chats while it is open.
+	chat    chats.Chat
+	history *historyBrowser
+
+	// configured is the [ai] section as loaded; config is that with the
+	// session's /set overrides on top.
+	configured config.AI
+	overrides  map[string]string
+
+	// wait is the latest pause of the request in flight, a backoff or the
+	// rate limiter, counted down in the status line while it lasts.
+	wait *llm.Wait
 }
 
 // NewAgent creates a new AI agent model with the given configuration.
 func NewAgent(aiConfig config.AI, keyConfig config.Keys) AgentModel {
 	ta := textarea.New()
-	ta.Placeholder = "Ask Gemini about your code..."
+	ta.Placeholder = "Ask the AI about your code..."
 	ta.Focus()
 	ta.CharLimit = 0
 	ta.SetHeight(3)
 	ta.ShowLineNumbers = false
 
 	vp := viewport.New(0, 0)
-
-	welcome := lipgloss.NewStyle().Foreground(ColorPrimary).Bold(true).Render("Boba AI Agent") + "\n\n"
-	welcome += lipgloss.NewStyle().Foreground(ColorSubText).Render("Powered by Google Gemini\n")
-	welcome += lipgloss.NewStyle().Foreground(ColorSubText).Render("Ask questions about your code, request refactors,\n")
-	welcome += lipgloss.NewStyle().Foreground(ColorSubText).Render("or ask me to rewrite files.\n\n")
-	welcome += lipgloss.NewStyle().Foreground(ColorAccent).Render("Tips:\n")
-	welcome += lipgloss.NewStyle().Foreground(ColorSubText).Render("  • \"explain this code\" — analyzes the current file\n")
-	welcome += lipgloss.NewStyle().Foreground(ColorSubText).Render("  • \"refactor for readability\" — suggests improvements\n")
-	welcome += lipgloss.NewStyle().Foreground(ColorSubText).Render("  • \"rewrite <file>\" — proposes changes (requires approval)\n\n")
-	welcome += lipgloss.NewStyle().Foreground(ColorWarning).Render("Set GEMINI_API_KEY env var to enable AI features.\n")
-
+	welcome := welcomeText(aiConfig.Provider, nil)
 	vp.SetContent(welcome)
 
 	return AgentModel{
@@ -70,12 +161,53 @@ func NewAgent(aiConfig config.AI, keyConfig config.Keys) AgentModel {
 		aiStyle:     lipgloss.NewStyle().Foreground(ColorSuccess),
 		errorStyle:  lipgloss.NewStyle().Foreground(ColorError),
 		config:      aiConfig,
+		configured:  aiConfig,
 		keys:        keyConfig,
+		providerErr: errKeyPending,
+		keySettings: keySettingsOf(aiConfig),
+		welcome:     welcome,
 	}
 }
 
+// welcomeText is shown while the conversation is empty, with err, if any,
+// explaining why the provider cannot be used.
+func welcomeText(providerName string, err error) string {
+	welcome := lipgloss.NewStyle().Foreground(ColorPrimary).Bold(true).Render("Boba AI Agent") + "\n\n"
+	welcome += lipgloss.NewStyle().Foreground(ColorSubText).Render("Powered by " + providerName + "\n")
+	welcome += lipgloss.NewStyle().Foreground(ColorSubText).Render("Ask questions about your code, request refactors,\n")
+	welcome += lipgloss.NewStyle().Foreground(ColorSubText).Render("or ask me to rewrite files.\n\n")
+	welcome += lipgloss.NewStyle().Foreground(ColorAccent).Render("Tips:\n")
+	welcome += lipgloss.NewStyle().Foreground(ColorSubText).Render("  • \"explain this code\" — analyzes the current file\n")
+	welcome += lipgloss.NewStyle().Foreground(ColorSubText).Render("  • \"refactor for readability\" — suggests improvements\n")
+	welcome += lipgloss.NewStyle().Foreground(ColorSubText).Render("  • \"rewrite <file>\" — proposes changes (requires approval)\n")
+	welcome += lipgloss.NewStyle().Foreground(ColorSubText).Render("  • :'<,'>Ask or :AskFunc in the editor — ask about a selection\n")
+	welcome += lipgloss.NewStyle().Foreground(ColorSubText).Render("  • /explain, /tests, /doc, /fix, /review — ready-made prompts\n")
+	welcome += lipgloss.NewStyle().Foreground(ColorSubText).Render("  • /new or /clear — start a fresh conversation\n")
+	welcome += lipgloss.NewStyle().Foreground(ColorSubText).Render("  • /history — reopen, search, rename or delete past chats\n\n")
+	if err != nil {
+		welcome += lipgloss.NewStyle().Foreground(ColorWarning).Render(err.Error() + "\n")
+	}
+	return welcome
+}
+
 func (m AgentModel) Init() tea.Cmd {
-	return textarea.Blink
+	return tea.Batch(textarea.Blink, resolveKey(m.keySettings))
+}
+
+// updateProvider rebuilds the provider for the current config. When the
+// settings the API key is looked up by have changed, or the last lookup
+// failed, it returns a command to look the key up again, and the provider
+// waits for the answer.
+func (m *AgentModel) updateProvider() tea.Cmd {
+	if s := keySettingsOf(m.config); s != m.keySettings || m.keyErr != nil {
+		m.keySettings, m.apiKey, m.keyErr = s, "", nil
+		m.provider, m.providerErr = nil, errKeyPending
+		return resolveKey(s)
+	}
+	if m.providerErr != errKeyPending {
+		m.provider, m.providerErr = newProvider(m.config, m.apiKey)
+	}
+	return nil
 }
 
 func (m AgentModel) Update(msg tea.Msg) (AgentModel, tea.Cmd) {
@@ -84,67 +216,202 @@ func (m AgentModel) Update(msg tea.Msg) (AgentModel, tea.Cmd) {
 		vpCmd tea.Cmd
 	)
 
+	// Approval keys must not reach the textarea.
+	if key, ok := msg.(tea.KeyMsg); ok && m.pendingRewrite != nil {
+		return m, m.handleRewriteKey(key)
+	}
+	if key, ok := msg.(tea.KeyMsg); ok && m.approval != nil {
+		return m, m.handleApprovalKey(key)
+	}
+	if key, ok := msg.(tea.KeyMsg); ok && m.history != nil {
+		return m, m.handleHistoryKey(key)
+	}
+	if key, ok := msg.(tea.KeyMsg); ok && len(m.suggestions) > 0 && m.handleSuggestionKey(key) {
+		return m, nil
+	}
+
 	m.textarea, tiCmd = m.textarea.Update(msg)
 	m.viewport, vpCmd = m.viewport.Update(msg)
+	if _, ok := msg.(tea.KeyMsg); ok {
+		m.updateSuggestions()
+	}
 
 	switch msg := msg.(type) {
-	case GeminiResponseMsg:
-		m.waiting = false
-		if msg.Err != nil {
-			errMsg := m.errorStyle.Render("Error: ") + msg.Err.Error()
-			m.messages = append(m.messages, errMsg)
+	case ConfigChangedMsg:
+		m.configured = msg.Config.AI
+		m.applyOverrides()
+		cmd := m.updateProvider()
+		m.keys = msg.Config.Keys
+		m.senderStyle = lipgloss.NewStyle().Foreground(ColorPrimary).Bold(true)
+		m.aiStyle = lipgloss.NewStyle().Foreground(ColorSuccess)
+		m.errorStyle = lipgloss.NewStyle().Foreground(ColorError)
+		m.countTokens()
+		return m, cmd
+
+	case keyResolvedMsg:
+		if msg.settings != m.keySettings {
+			// The config changed while the key was looked up.
+			return m, nil
+		}
+		m.apiKey, m.keyErr = msg.key, msg.err
+		if m.keyErr != nil {
+			m.provider, m.providerErr = nil, m.keyErr
 		} else {
-			aiName := m.config.Name
-			if aiName == "" {
-				aiName = "Gemini"
-			}
-			aiMsg := m.aiStyle.Render(aiName+": ") + msg.Response
-			m.messages = append(m.messages, aiMsg)
+			m.provider, m.providerErr = newProvider(m.config, m.apiKey)
 		}
-		m.viewport.SetContent(strings.Join(m.messages, "\n\n"))
-		m.viewport.GotoBottom()
+		name := m.config.Provider
+		if m.provider != nil {
+			name = m.provider.Name()
+		}
+		m.welcome = welcomeText(name, m.providerErr)
+		if len(m.messages) == 0 && m.history == nil {
+			m.viewport.SetContent(m.welcome)
+		}
+		return m, nil
 
-	case tea.KeyMsg:
-		// Handle pending rewrite approval
-		if m.pendingRewrite != nil {
-			switch msg.String() {
-			case "y", "Y":
-				// User approved the rewrite
-				m.messages = append(m.messages,
-					m.aiStyle.Render("Rewrite approved — file saved: ")+m.pendingRewrite.FilePath)
-				m.pendingRewrite = nil
-				m.viewport.SetContent(strings.Join(m.messages, "\n\n"))
-				m.viewport.GotoBottom()
-				return m, tea.Batch(tiCmd, vpCmd)
-			case "n", "N":
-				m.messages = append(m.messages,
-					m.errorStyle.Render("Rewrite rejected"))
-				m.pendingRewrite = nil
-				m.viewport.SetContent(strings.Join(m.messages, "\n\n"))
-				m.viewport.GotoBottom()
-				return m, tea.Batch(tiCmd, vpCmd)
+	case aiStreamMsg:
+		if !m.waiting || msg.id != m.requestID {
+			return m, waitForStream(msg.ch)
+		}
+		m.reply += msg.Delta
+		m.showReply(m.reply, false)
+		// The history is unchanged until the reply is complete.
+		m.redraw()
+		return m, tea.Batch(tiCmd, vpCmd, waitForStream(msg.ch))
+
+	case aiWaitMsg:
+		if !m.waiting || msg.id != m.requestID {
+			return m, waitForStream(msg.ch)
+		}
+		m.wait = &msg.Wait
+		return m, tea.Batch(tiCmd, vpCmd, waitForStream(msg.ch), waitTick(msg.id, msg.Wait.Until))
+
+	case waitTickMsg:
+		// Only the latest wait keeps ticking, and only until it is over.
+		if !m.waiting || msg.id != m.requestID || m.wait == nil || !m.wait.Until.Equal(msg.until) ||
+			time.Now().After(msg.until) {
+			return m, nil
+		}
+		return m, waitTick(msg.id, msg.until)
+
+	case aiCompactedMsg:
+		if !m.waiting || msg.id != m.requestID {
+			return m, waitForStream(msg.ch)
+		}
+		m.conv = msg.Conversation
+		note := StyleDim.Render(fmt.Sprintf("(%d earlier turn(s) summarised to stay within the history budget)", msg.Folded))
+		if msg.Err != nil {
+			note = m.errorStyle.Render("Warning: ") + msg.Err.Error() +
+				StyleDim.Render(fmt.Sprintf(" (%d earlier turn(s) dropped)", msg.Folded))
+		}
+		m.messages = slices.Insert(m.messages, m.replyIndex, note)
+		m.replyIndex++
+		m.refresh()
+		return m, tea.Batch(tiCmd, vpCmd, waitForStream(msg.ch))
+
+	case GeminiResponseMsg:
+		if !m.waiting || msg.id != m.requestID {
+			return m, nil
+		}
+		if msg.Err == nil && len(msg.ToolCalls) > 0 {
+			if msg.Response != "" {
+				m.showReply(msg.Response, true)
+			} else {
+				m.messages = slices.Delete(m.messages, m.replyIndex, m.replyIndex+1)
 			}
-			return m, tea.Batch(tiCmd, vpCmd)
+			m.conv.Add(llm.Turn{Role: llm.RoleAssistant, Text: msg.Response, ToolCalls: msg.ToolCalls})
+			m.reply = ""
+			m.toolCalls = msg.ToolCalls
+			cmd := m.nextTool()
+			m.refresh()
+			return m, tea.Batch(tiCmd, vpCmd, cmd)
+		}
+		m.finishRequest()
+		switch {
+		case msg.Err != nil && msg.Response != "":
+			// The stream broke part way: keep what arrived and say so.
+			m.showReply(msg.Response, true)
+			m.messages = append(m.messages,
+				m.errorStyle.Render("Error: ")+describeError(m.config, msg.Err)+StyleDim.Render(" (reply incomplete)"))
+			m.conv.Add(llm.Turn{Role: llm.RoleAssistant, Text: msg.Response})
+		case msg.Err != nil:
+			m.messages[m.replyIndex] = m.errorStyle.Render("Error: ") + describeError(m.config, msg.Err)
+			m.dropPendingPrompt()
+		default:
+			m.showReply(msg.Response, true)
+			attachments := m.lastAttachments()
+			m.conv.Add(llm.Turn{Role: llm.RoleAssistant, Text: msg.Response})
+			m.proposeRewrites(msg.Response, attachments)
+		}
+		m.reply = ""
+		m.saveChat()
+		m.refresh()
+
+	case toolResultMsg:
+		if !m.waiting || msg.id != m.requestID {
+			return m, nil
 		}
+		m.running = nil
+		m.recordTool(msg.Call, msg.Result, msg.Err)
+		cmd := m.nextTool()
+		m.refresh()
+		return m, tea.Batch(tiCmd, vpCmd, cmd)
 
+	case tea.KeyMsg:
 		switch msg.String() {
+		case m.keys.AgentCancel:
+			if m.waiting {
+				m.Cancel()
+				return m, nil
+			}
+
 		case m.keys.AgentSend:
 			if m.textarea.Value() == "" || m.waiting {
 				break
 			}
 			userInput := m.textarea.Value()
-			userMsg := m.senderStyle.Render("You: ") + userInput
-			m.messages = append(m.messages, userMsg)
+			if arg, ok := strings.CutPrefix(strings.TrimSpace(userInput), "/set"); ok && (arg == "" || arg[0] == ' ') {
+				m.set(arg)
+				return m, nil
+			}
+			switch strings.TrimSpace(userInput) {
+			case "/new":
+				m.conv.Reset()
+				m.chat = chats.Chat{}
+				m.messages = append(m.messages, StyleDim.Render("── New conversation ──"))
+				m.textarea.Reset()
+				m.refresh()
+				return m, nil
+			case "/clear":
+				m.conv.Reset()
+				m.chat = chats.Chat{}
+				m.messages = nil
+				m.snippets = nil
+				m.textarea.Reset()
+				m.countTokens()
+				m.viewport.SetContent(m.welcome)
+				m.viewport.GotoTop()
+				return m, nil
+			case "/history":
+				m.textarea.Reset()
+				if err := m.openHistory(); err != nil {
+					m.messages = append(m.messages, m.errorStyle.Render("Error: ")+err.Error())
+					m.refresh()
+				}
+				return m, nil
+			}
+			return m, tea.Batch(tiCmd, vpCmd, m.send(userInput))
 
-			// Send to Gemini API
-			m.waiting = true
-			waitMsg := StyleDim.Render("⏳ Waiting for Gemini response...")
-			m.messages = append(m.messages, waitMsg)
-			m.viewport.SetContent(strings.Join(m.messages, "\n\n"))
-			m.textarea.Reset()
-			m.viewport.GotoBottom()
+		case m.keys.AgentInsert:
+			return m, m.insertSnippet()
 
-			return m, tea.Batch(tiCmd, vpCmd, sendToGemini(userInput, m.currentFile))
+		case m.keys.AgentContext:
+			if m.pinned != nil {
+				m.pinned = nil
+			} else {
+				m.contextMode = (m.contextMode + 1) % 3
+			}
+			return m, nil
 		}
 	}
 
@@ -153,30 +420,383 @@ func (m AgentModel) Update(msg tea.Msg) (AgentModel, tea.Cmd) {
 
 func (m AgentModel) View() string {
 	var statusLine string
-	if m.waiting {
-		statusLine = StyleDim.Render("Generating...")
-	} else if m.pendingRewrite != nil {
-		statusLine = StyleModeCommand.Render(" APPROVE REWRITE? [y/n] ") + " " + m.pendingRewrite.FilePath
+	if a := m.approval; a != nil {
+		statusLine = StyleModeCommand.Render(" ALLOW ") + " " + tools.Describe(a.call) +
+			StyleDim.Render("  [y]es [n]o  "+m.keys.AgentCancel+" to stop")
+	} else if m.running != nil {
+		statusLine = StyleDim.Render("Running " + m.running.Name + "... (" + m.keys.AgentCancel + " to cancel)")
+	} else if w := m.wait; m.waiting && w != nil && time.Now().Before(w.Until) {
+		statusLine = StyleDim.Render(waitStatus(*w) + "... (" + m.keys.AgentCancel + " to cancel)")
+	} else if m.waiting {
+		statusLine = StyleDim.Render("Generating... (" + m.keys.AgentCancel + " to cancel)")
+	} else if h := m.history; h != nil {
+		statusLine = m.historyStatus(h)
+	} else if m.editingHunk {
+		statusLine = StyleModeInsert.Render(" EDIT HUNK ") + " " +
+			StyleDim.Render(m.keys.AgentCancel+" to finish")
+	} else if r := m.pendingRewrite; r != nil {
+		apply, reject := r.counts()
+		statusLine = StyleModeCommand.Render(" REVIEW ") + " " + r.Name() +
+			fmt.Sprintf(" hunk %d/%d (%d to apply, %d rejected)", m.hunk+1, len(r.hunks), apply, reject) +
+			StyleDim.Render("  [a]ccept [r]eject [e]dit ]h/[h  [y] buffer [w] disk [n] skip")
+		if n := len(m.rewrites); n > 0 {
+			statusLine += StyleDim.Render(fmt.Sprintf("  (%d more)", n))
+		}
 	} else {
 		statusLine = ""
 	}
 
+	tokens := m.tokenIndicator()
+	statusLine = ansi.Truncate(statusLine, max(m.width-lipgloss.Width(tokens)-1, 0), "…")
+	gap := max(m.width-lipgloss.Width(statusLine)-lipgloss.Width(tokens), 1)
+	statusLine = ansi.Truncate(statusLine+strings.Repeat(" ", gap)+tokens, m.width, "…")
+	chip := m.contextChip()
+	if len(m.suggestions) > 0 {
+		chip = m.suggestionLine()
+	}
+	chip = ansi.Truncate(chip, m.width, "…")
+
 	return StyleAgent.Render(
 		fmt.Sprintf(
-			"%s\n%s\n%s",
+			"%s\n%s\n%s\n%s",
 			m.viewport.View(),
 			statusLine,
+			chip,
 			m.textarea.View(),
 		),
 	)
 }
 
+// Cancel aborts the request in flight, if any, keeping whatever part of the
+// reply has already arrived.
+func (m *AgentModel) Cancel() {
+	if !m.waiting {
+		return
+	}
+	m.finishRequest()
+	if m.approval != nil || m.running != nil || len(m.toolCalls) > 0 {
+		m.cancelTools()
+		m.messages = append(m.messages, StyleDim.Render("(cancelled)"))
+	} else if m.reply != "" {
+		m.showReply(m.reply, true)
+		m.messages = append(m.messages, StyleDim.Render("(cancelled)"))
+		m.conv.Add(llm.Turn{Role: llm.RoleAssistant, Text: m.reply})
+	} else {
+		m.messages[m.replyIndex] = StyleDim.Render("Request cancelled")
+		m.dropPendingPrompt()
+	}
+	m.reply = ""
+	m.saveChat()
+	m.refresh()
+}
+
+// send posts a prompt with the chosen context attached: the context picked
+// with :Ask if any, otherwise the buffer or function the Model supplied.
+func (m *AgentModel) send(input string) tea.Cmd {
+	prompt, inlined, err := m.expandTemplate(input)
+	if err != nil {
+		m.messages = append(m.messages, m.errorStyle.Render("Error: ")+err.Error())
+		m.refresh()
+		return nil
+	}
+	userMsg := m.senderStyle.Render("You: ") + input
+	if prompt != input {
+		userMsg += "\n" + StyleDim.Render(prompt)
+	}
+	var attachments []llm.Attachment
+	switch {
+	case inlined:
+		// The template put the context in the prompt itself.
+	case m.pinned != nil:
+		attachments = append(attachments, *m.pinned)
+	case m.live != nil:
+		attachments = append(attachments, *m.live)
+	}
+	for _, a := range attachments {
+		userMsg += "\n" + StyleDim.Render(attachmentLabel(a))
+	}
+	mentioned, notes := resolveMentions(prompt, m.root, m.buffer)
+	for _, a := range mentioned {
+		// @buffer alongside the buffer as context would send it twice.
+		if len(attachments) > 0 && a.Name == attachments[0].Name && a.Label == "" && attachments[0].Label == "" {
+			continue
+		}
+		attachments = append(attachments, a)
+	}
+	attachments, cut := m.fitAttachments(prompt, attachments)
+	notes = append(notes, cut...)
+	for _, note := range notes {
+		userMsg += "\n" + StyleDim.Render(note)
+	}
+	m.pinned, m.live, m.buffer = nil, nil, nil
+	m.messages = append(m.messages, userMsg)
+	m.textarea.Reset()
+	m.suggestions = nil
+
+	if m.providerErr != nil {
+		m.messages = append(m.messages, m.errorStyle.Render("Error: ")+m.providerErr.Error())
+		m.viewport.SetContent(strings.Join(m.messages, "\n\n"))
+		m.viewport.GotoBottom()
+		return nil
+	}
+
+	// Send to the configured provider
+	m.waiting = true
+	m.steps = 0
+	m.viewport.GotoBottom()
+
+	m.conv.Add(llm.Turn{Role: llm.RoleUser, Text: prompt, Attachments: attachments})
+	m.ctx, m.cancel = context.WithCancel(context.Background())
+	return m.request()
+}
+
+// ask attaches context picked in the editor, sending the prompt that came
+// with it straight away.
+func (m *AgentModel) ask(msg askAgentMsg) tea.Cmd {
+	a := msg.Context
+	m.pinned = &a
+	m.closeHistory()
+	if msg.Prompt == "" || m.waiting {
+		if msg.Prompt != "" {
+			m.textarea.SetValue(msg.Prompt)
+		}
+		return nil
+	}
+	return m.send(msg.Prompt)
+}
+
+// request sends the conversation so far, showing a placeholder for the reply.
+func (m *AgentModel) request() tea.Cmd {
+	m.reply = ""
+	m.messages = append(m.messages, StyleDim.Render("⏳ Waiting for "+m.provider.Name()+" response..."))
+	m.replyIndex = len(m.messages) - 1
+	m.refresh()
+	m.requestID++
+	return sendPrompt(m.ctx, m.requestID, m.provider, m.config, m.conv.Clone(), m.toolDefinitions())
+}
+
+// finishRequest releases the request in flight.
+func (m *AgentModel) finishRequest() {
+	m.waiting = false
+	m.wait = nil
+	if m.cancel != nil {
+		m.cancel()
+		m.cancel = nil
+	}
+}
+
+// dropPendingPrompt forgets an unanswered prompt so the history keeps
+// alternating between user and assistant.
+func (m *AgentModel) dropPendingPrompt() {
+	if n := len(m.conv.Turns); n > 0 && m.conv.Turns[n-1].Role == llm.RoleUser {
+		m.conv.Turns = m.conv.Turns[:n-1]
+	}
+}
+
+// lastAttachments returns the attachments sent with the latest prompt.
+func (m AgentModel) lastAttachments() []llm.Attachment {
+	for i := len(m.conv.Turns) - 1; i >= 0; i-- {
+		if m.conv.Turns[i].Role == llm.RoleUser {
+			return m.conv.Turns[i].Attachments
+		}
+	}
+	return nil
+}
+
+// proposeRewrites shows the file changes found in a reply as diffs and queues
+// them for review.
+func (m *AgentModel) proposeRewrites(reply string, attachments []llm.Attachment) {
+	rewrites, errs := parseRewrites(reply, m.root, attachments)
+	for _, err := range errs {
+		m.messages = append(m.messages, m.errorStyle.Render("Warning: ")+err.Error())
+	}
+	for _, r := range rewrites {
+		r.msgIndex = len(m.messages)
+		text, _ := r.render(-1)
+		m.messages = append(m.messages, text)
+	}
+	m.rewrites = append(m.rewrites, rewrites...)
+	if m.pendingRewrite == nil {
+		m.nextRewrite()
+	}
+}
+
+// nextRewrite moves the next queued proposal up for review.
+func (m *AgentModel) nextRewrite() {
+	if r := m.pendingRewrite; r != nil {
+		m.messages[r.msgIndex], _ = r.render(-1)
+	}
+	m.pendingRewrite = nil
+	m.hunk = 0
+	m.hunkPrefix = ""
+	if len(m.rewrites) > 0 {
+		m.pendingRewrite = m.rewrites[0]
+		m.rewrites = m.rewrites[1:]
+		m.showHunk(0)
+	}
+}
+
+// showHunk makes hunk i of the pending rewrite current and scrolls to it.
+func (m *AgentModel) showHunk(i int) {
+	r := m.pendingRewrite
+	if i < 0 || i >= len(r.hunks) {
+		return
+	}
+	m.hunk = i
+	text, offsets := r.render(i)
+	m.messages[r.msgIndex] = text
+	m.viewport.SetContent(strings.Join(m.messages, "\n\n"))
+
+	line := 0
+	for _, msg := range m.messages[:r.msgIndex] {
+		line += strings.Count(msg, "\n") + 2
+	}
+	m.viewport.SetYOffset(line + offsets[i])
+}
+
+// handleRewriteKey reviews the pending rewrite: hunks are accepted, rejected
+// or edited one at a time, then the accepted set is applied together.
+func (m *AgentModel) handleRewriteKey(msg tea.KeyMsg) tea.Cmd {
+	r := m.pendingRewrite
+	key := msg.String()
+
+	if m.editingHunk {
+		if key == m.keys.AgentCancel {
+			r.editHunk(m.hunk, m.textarea.Value())
+			m.stopEditing()
+			m.showHunk(m.hunk)
+			return nil
+		}
+		var cmd tea.Cmd
+		m.textarea, cmd = m.textarea.Update(msg)
+		return cmd
+	}
+
+	if m.hunkPrefix != "" {
+		prefix := m.hunkPrefix
+		m.hunkPrefix = ""
+		switch prefix + key {
+		case "]h":
+			m.showHunk(m.hunk + 1)
+		case "[h":
+			m.showHunk(m.hunk - 1)
+		}
+		return nil
+	}
+
+	switch key {
+	case "]", "[":
+		m.hunkPrefix = key
+	case "a":
+		r.states[m.hunk] = hunkAccepted
+		m.advanceHunk()
+	case "r":
+		r.states[m.hunk] = hunkRejected
+		m.advanceHunk()
+	case "A", "R":
+		state := hunkAccepted
+		if key == "R" {
+			state = hunkRejected
+		}
+		for i, st := range r.states {
+			if st == hunkPending {
+				r.states[i] = state
+			}
+		}
+		m.showHunk(m.hunk)
+	case "e":
+		m.editingHunk = true
+		m.textarea.SetValue(strings.Join(r.hunks[m.hunk].New(), "\n"))
+		m.textarea.SetHeight(10)
+		m.SetSize(m.width, m.height)
+	case "y", "Y", "w", "W":
+		if apply, _ := r.counts(); apply == 0 {
+			m.rejectRewrite()
+			return nil
+		}
+		m.nextRewrite()
+		return applyRewriteCmd(r, key == "w" || key == "W")
+	case "n", "N", m.keys.AgentCancel:
+		m.rejectRewrite()
+	default:
+		// Let the conversation scroll while deciding.
+		var cmd tea.Cmd
+		m.viewport, cmd = m.viewport.Update(msg)
+		return cmd
+	}
+	return nil
+}
+
+// advanceHunk moves to the next undecided hunk, or redraws the current one
+// when every hunk has been decided.
+func (m *AgentModel) advanceHunk() {
+	r := m.pendingRewrite
+	for i := m.hunk + 1; i < len(r.hunks); i++ {
+		if r.states[i] == hunkPending {
+			m.showHunk(i)
+			return
+		}
+	}
+	m.showHunk(m.hunk)
+}
+
+func (m *AgentModel) rejectRewrite() {
+	m.messages = append(m.messages, m.errorStyle.Render("Change rejected: ")+m.pendingRewrite.Name())
+	m.nextRewrite()
+	m.refresh()
+}
+
+func (m *AgentModel) stopEditing() {
+	m.editingHunk = false
+	m.textarea.Reset()
+	m.textarea.SetHeight(3)
+	m.SetSize(m.width, m.height)
+}
+
+// rewriteApplied reports the outcome of an approved rewrite.
+func (m *AgentModel) rewriteApplied(note string, err error) {
+	if err != nil {
+		m.messages = append(m.messages, m.errorStyle.Render("Could not apply change: ")+err.Error())
+	} else {
+		m.messages = append(m.messages, m.aiStyle.Render(note))
+	}
+	m.refresh()
+}
+
+// aiName is the label shown before the assistant's replies.
+func (m AgentModel) aiName() string {
+	if m.config.Name == "" && m.provider != nil {
+		return m.provider.Name()
+	}
+	return m.config.Name
+}
+
+// refresh recounts the conversation's tokens and redraws it.
+func (m *AgentModel) refresh() {
+	m.countTokens()
+	m.redraw()
+}
+
+// redraw shows the conversation, following the end of it unless the user
+// has scrolled up to read something earlier.
+func (m *AgentModel) redraw() {
+	if m.history != nil {
+		m.showHistory()
+		return
+	}
+	follow := m.viewport.AtBottom()
+	m.viewport.SetContent(strings.Join(m.messages, "\n\n"))
+	if follow {
+		m.viewport.GotoBottom()
+	}
+}
+
 func (m *AgentModel) SetSize(w, h int) {
 	m.width = w
 	m.height = h
 	m.textarea.SetWidth(w)
 	m.viewport.Width = w
-	m.viewport.Height = h - m.textarea.Height() - 4
+	m.viewport.Height = h - m.textarea.Height() - 5
 }
 
 // SetCurrentFile tells the agent what file is currently open in the editor.
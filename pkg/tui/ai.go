@@ -0,0 +1,220 @@
+package tui
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/CiaranMccarthy1/boba-text/pkg/config"
+	"github.com/CiaranMccarthy1/boba-text/pkg/llm"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// keySettings are the [ai] settings the API key is looked up by. The key is
+// looked up again only when they change.
+type keySettings struct {
+	provider, command, credentials string
+}
+
+func keySettingsOf(cfg config.AI) keySettings {
+	return keySettings{provider: cfg.Provider, command: cfg.KeyCommand, credentials: cfg.CredentialsFile}
+}
+
+// keyResolvedMsg carries the API key looked up for settings, or why there is
+// none.
+type keyResolvedMsg struct {
+	settings keySettings
+	key      string
+	err      error
+}
+
+// errKeyPending stands in for the provider while its API key is looked up.
+var errKeyPending = errors.New("still looking up the API key; try again in a moment")
+
+// resolveKey looks up the API key in the environment, key_command or the
+// credentials file. It runs as a command because key_command can take a
+// while, such as when a password manager asks to be unlocked.
+func resolveKey(s keySettings) tea.Cmd {
+	return func() tea.Msg {
+		credentials := s.credentials
+		if credentials == "" {
+			credentials = config.CredentialsPath()
+		}
+		key, err := llm.ResolveKey(context.Background(), s.provider, llm.KeySource{
+			Command:         s.command,
+			CredentialsFile: credentials,
+		})
+		return keyResolvedMsg{settings: s, key: key, err: err}
+	}
+}
+
+// newProvider builds the LLM provider selected by the [ai] config section
+// with the API key resolved for it.
+func newProvider(cfg config.AI, apiKey string) (llm.Provider, error) {
+	return llm.New(llm.Config{
+		Provider:          cfg.Provider,
+		Model:             cfg.Model,
+		BaseURL:           cfg.BaseURL,
+		APIKey:            apiKey,
+		Retries:           cfg.Retries,
+		RequestsPerMinute: cfg.RequestsPerMinute,
+	})
+}
+
+// systemPrompt frames every request to the assistant unless the config
+// gives its own.
+const systemPrompt = "You are a coding assistant inside a terminal text editor. " +
+	"Respond concisely. If suggesting code changes, show the relevant diff or snippet. "
+
+// generation is the sampling configured for replies.
+func generation(cfg config.AI) llm.Generation {
+	return llm.Generation{
+		Temperature:     cfg.Temperature,
+		TopP:            cfg.TopP,
+		MaxOutputTokens: cfg.MaxOutputTokens,
+		Stop:            cfg.Stop,
+		Safety:          cfg.Safety,
+	}
+}
+
+// aiStreamMsg carries one piece of a streamed reply. The agent appends it and
+// waits on ch for the next message.
+type aiStreamMsg struct {
+	Delta string
+	id    int
+	ch    <-chan tea.Msg
+}
+
+// aiCompactedMsg reports that older turns were folded into the summary before
+// the request was sent.
+type aiCompactedMsg struct {
+	Conversation llm.Conversation
+	Folded       int
+	Err          error
+	id           int
+	ch           <-chan tea.Msg
+}
+
+// aiWaitMsg reports that the request is paused, backing off before a retry or
+// held by the per-minute limit, so the status line can count down.
+type aiWaitMsg struct {
+	Wait llm.Wait
+	id   int
+	ch   <-chan tea.Msg
+}
+
+// waitTickMsg redraws the countdown of the wait ending at until.
+type waitTickMsg struct {
+	until time.Time
+	id    int
+}
+
+func waitTick(id int, until time.Time) tea.Cmd {
+	return tea.Tick(time.Second, func(time.Time) tea.Msg { return waitTickMsg{until: until, id: id} })
+}
+
+// waitStatus describes a pause for the status line.
+func waitStatus(w llm.Wait) string {
+	left := max(time.Until(w.Until).Round(time.Second), 0)
+	if w.Attempt == 0 {
+		return fmt.Sprintf("Waiting %s for the requests_per_minute limit", left)
+	}
+	var cause string
+	switch {
+	case w.Status == http.StatusTooManyRequests:
+		cause = "Rate limited"
+	case w.Status >= 500:
+		cause = fmt.Sprintf("Server error (HTTP %d)", w.Status)
+	case w.Status != 0:
+		cause = fmt.Sprintf("HTTP %d", w.Status)
+	default:
+		cause = "Connection failed"
+	}
+	return fmt.Sprintf("%s, retrying in %s (attempt %d of %d)", cause, left, w.Attempt, w.Retries)
+}
+
+// describeError explains a failed request, with a hint suited to its cause.
+func describeError(cfg config.AI, err error) string {
+	var hint string
+	switch llm.Classify(err) {
+	case llm.ErrAuth:
+		hint = "the API key was rejected; check " + llm.KeyEnv(cfg.Provider) + ", key_command or the credentials file"
+	case llm.ErrQuota:
+		hint = "rate limit or quota exceeded"
+		var api *llm.APIError
+		if errors.As(err, &api) && api.RetryAfter > 0 {
+			hint += fmt.Sprintf("; try again in %s", api.RetryAfter.Round(time.Second))
+		}
+		if cfg.RequestsPerMinute == 0 {
+			hint += "; requests_per_minute can keep requests under the limit"
+		}
+	case llm.ErrBlocked:
+		hint = "rephrase the prompt"
+		// Only Gemini's filters can be set in the config.
+		if p := strings.ToLower(cfg.Provider); p == "" || p == "gemini" {
+			hint += ", or relax [ai.safety] in the config"
+		}
+	case llm.ErrNetwork:
+		hint = "could not reach the provider; check the connection"
+		if cfg.BaseURL != "" {
+			hint += " and base_url"
+		}
+	case llm.ErrServer:
+		hint = "the provider is having trouble; try again shortly"
+	default:
+		return err.Error()
+	}
+	return err.Error() + StyleDim.Render(" ("+hint+")")
+}
+
+// sendPrompt creates a tea.Cmd that sends the conversation to the provider
+// asynchronously. History over the configured budget, or too big for the
+// token limit, is summarised first, reported with an aiCompactedMsg. With
+// streaming enabled the reply arrives as a series of aiStreamMsgs; either way
+// it ends with a GeminiResponseMsg, which lists any tools the assistant wants
+// to call.
+// Cancelling ctx aborts the request, and every message carries id so late
+// replies to a cancelled request can be told apart and ignored.
+func sendPrompt(ctx context.Context, id int, provider llm.Provider, aiConfig config.AI, conv llm.Conversation, tools []llm.Tool) tea.Cmd {
+	ch := make(chan tea.Msg, 64)
+	ctx = llm.WithWaitNotify(ctx, func(w llm.Wait) {
+		ch <- aiWaitMsg{Wait: w, id: id, ch: ch}
+	})
+	go func() {
+		defer close(ch)
+
+		count := llm.TokenizerFor(aiConfig.Provider)
+		compacted, folded, err := llm.Compact(ctx, provider, conv, historyBudget(aiConfig, tools, count), count)
+		if folded > 0 {
+			ch <- aiCompactedMsg{Conversation: compacted.Clone(), Folded: folded, Err: err, id: id, ch: ch}
+		}
+		req := compacted.Request(requestSystem(aiConfig, tools))
+		req.Tools = tools
+		req.Generation = generation(aiConfig)
+
+		if !aiConfig.Stream {
+			resp, err := provider.Complete(ctx, req)
+			ch <- GeminiResponseMsg{Response: resp.Text, ToolCalls: resp.ToolCalls, Err: err, id: id}
+			return
+		}
+		resp, err := provider.Stream(ctx, req, func(delta string) {
+			ch <- aiStreamMsg{Delta: delta, id: id, ch: ch}
+		})
+		ch <- GeminiResponseMsg{Response: resp.Text, ToolCalls: resp.ToolCalls, Err: err, Streamed: true, id: id}
+	}()
+	return waitForStream(ch)
+}
+
+// waitForStream returns the next message from a reply in progress.
+func waitForStream(ch <-chan tea.Msg) tea.Cmd {
+	return func() tea.Msg {
+		msg, ok := <-ch
+		if !ok {
+			return nil
+		}
+		return msg
+	}
+}
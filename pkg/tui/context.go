@@ -0,0 +1,279 @@
+package tui
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/CiaranMccarthy1/boba-text/pkg/llm"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// contextMode is what part of the editor buffer goes with a prompt when no
+// context has been picked explicitly.
+type contextMode int
+
+const (
+	contextBuffer contextMode = iota
+	contextFunction
+	contextNone
+)
+
+func (c contextMode) String() string {
+	switch c {
+	case contextFunction:
+		return "function at cursor"
+	case contextNone:
+		return "no context"
+	default:
+		return "whole buffer"
+	}
+}
+
+// askAgentMsg sends part of the buffer to the agent, from :Ask and friends.
+// An empty Prompt just attaches the context and focuses the agent.
+type askAgentMsg struct {
+	Context llm.Attachment
+	Prompt  string
+}
+
+// bufferName is the name the buffer is attached under.
+func (m EditorModel) bufferName() string {
+	if m.filename == "" {
+		return "[No Name]"
+	}
+	return m.filename
+}
+
+// contextAttachment returns the live buffer, or the function under the
+// cursor, as context for a prompt. Unsaved changes are included.
+func (m EditorModel) contextAttachment(mode contextMode) *llm.Attachment {
+	text := m.textarea.Value()
+	if mode == contextNone || (m.filename == "" && text == "") {
+		return nil
+	}
+	if mode == contextFunction {
+		a, err := m.functionAttachment()
+		if err != nil {
+			return nil
+		}
+		return &a
+	}
+	return &llm.Attachment{Name: m.bufferName(), Content: text}
+}
+
+// rangeAttachment returns rows start to end (0-based, inclusive).
+func (m EditorModel) rangeAttachment(start, end int) llm.Attachment {
+	lines := strings.Split(m.textarea.Value(), "\n")
+	end = min(end, len(lines)-1)
+	start = max(0, min(start, end))
+	return llm.Attachment{
+		Name:    m.bufferName(),
+		Content: strings.Join(lines[start:end+1], "\n"),
+		Label:   lineLabel(start, end),
+	}
+}
+
+// functionAttachment returns the function enclosing the cursor.
+func (m EditorModel) functionAttachment() (llm.Attachment, error) {
+	lines := strings.Split(m.textarea.Value(), "\n")
+	start, end, name, ok := functionAt(lines, m.textarea.Line(), fileType(m.filename))
+	if !ok {
+		return llm.Attachment{}, fmt.Errorf("no function found around line %d", m.textarea.Line()+1)
+	}
+	return llm.Attachment{
+		Name:    m.bufferName(),
+		Content: strings.Join(lines[start:end+1], "\n"),
+		Label:   functionLabel(name, start, end),
+	}, nil
+}
+
+// cursorFunction labels the function enclosing the cursor, or returns ""
+// when there is none.
+func (m EditorModel) cursorFunction() string {
+	lines := strings.Split(m.textarea.Value(), "\n")
+	start, end, name, ok := functionAt(lines, m.textarea.Line(), fileType(m.filename))
+	if !ok {
+		return ""
+	}
+	return functionLabel(name, start, end)
+}
+
+func functionLabel(name string, start, end int) string {
+	return "function " + name + " (" + lineLabel(start, end) + ")"
+}
+
+func lineLabel(start, end int) string {
+	if start == end {
+		return fmt.Sprintf("line %d", start+1)
+	}
+	return fmt.Sprintf("lines %d-%d", start+1, end+1)
+}
+
+// askAgent hands context to the agent pane.
+func askAgent(a llm.Attachment, prompt string) tea.Cmd {
+	return func() tea.Msg { return askAgentMsg{Context: a, Prompt: strings.TrimSpace(prompt)} }
+}
+
+// funcPatterns match the first line of a function definition; the first
+// group is its name.
+var funcPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`^\s*func\s*(?:\([^)]*\)\s*)?(\w+)`),
+	regexp.MustCompile(`^\s*(?:export\s+)?(?:default\s+)?(?:async\s+)?function\s*\*?\s*(\w+)`),
+	regexp.MustCompile(`^\s*(?:pub(?:\([^)]*\))?\s+)?(?:const\s+)?(?:async\s+)?(?:unsafe\s+)?(?:extern\s+"\w+"\s+)?fn\s+(\w+)`),
+	regexp.MustCompile(`^\s*(?:async\s+)?def\s+(\w+)`),
+	regexp.MustCompile(`^\s*(?:export\s+)?(?:const|let|var)\s+(\w+)\s*=\s*(?:async\s+)?(?:function\b|\([^)]*\)\s*=>|\w+\s*=>)`),
+	// C-like: a return type and a name followed by a parameter list.
+	regexp.MustCompile(`^\s*(?:[\w:<>,*&\[\]]+\s+)+[*&]?(\w+)\s*\([^;]*$`),
+}
+
+// notFunctions are keywords that look like calls to the C-like pattern.
+var notFunctions = map[string]bool{
+	"if": true, "for": true, "while": true, "switch": true, "return": true,
+	"catch": true, "else": true, "do": true, "sizeof": true, "new": true,
+}
+
+// functionAt finds the function enclosing row, returning its first and last
+// rows and its name. Brace languages are matched by braces; Python by
+// indentation.
+func functionAt(lines []string, row int, filetype string) (start, end int, name string, ok bool) {
+	if row < 0 || row >= len(lines) {
+		return 0, 0, "", false
+	}
+	for i := row; i >= 0; i-- {
+		if name = functionName(lines[i]); name == "" {
+			continue
+		}
+		if filetype == "python" || strings.HasSuffix(strings.TrimSpace(lines[i]), ":") {
+			end, ok = indentBlockEnd(lines, i)
+		} else {
+			end, ok = braceBlockEnd(lines, i)
+		}
+		if ok && end >= row {
+			return i, end, name, true
+		}
+	}
+	return 0, 0, "", false
+}
+
+func functionName(line string) string {
+	for _, re := range funcPatterns {
+		if m := re.FindStringSubmatch(line); m != nil && !notFunctions[m[1]] {
+			return m[1]
+		}
+	}
+	return ""
+}
+
+// braceBlockEnd returns the row where the block opened at or after start
+// closes. A ; before the first { means a declaration without a body.
+func braceBlockEnd(lines []string, start int) (int, bool) {
+	depth, opened := 0, false
+	for i := start; i < len(lines); i++ {
+		inString := rune(0)
+		line := lines[i]
+		for j := 0; j < len(line); j++ {
+			c := rune(line[j])
+			switch {
+			case inString != 0:
+				if c == '\\' {
+					j++
+				} else if c == inString {
+					inString = 0
+				}
+			case c == '"' || c == '`':
+				inString = c
+			case c == '\'':
+				// A character literal, unless it is a Rust lifetime.
+				rest, skip := line[j+1:], 0
+				if strings.HasPrefix(rest, "\\") {
+					skip = min(2, len(rest))
+				}
+				if k := strings.IndexByte(rest[skip:], '\''); k >= 0 && k <= 3 {
+					j += skip + k + 1
+				}
+			case c == '/' && j+1 < len(line) && line[j+1] == '/':
+				j = len(line)
+			case c == '{':
+				depth++
+				opened = true
+			case c == '}':
+				depth--
+				if opened && depth == 0 {
+					return i, true
+				}
+			case c == ';' && !opened:
+				return 0, false
+			}
+		}
+		// Give up on signatures that never open a body.
+		if !opened && i-start > 10 {
+			return 0, false
+		}
+	}
+	return 0, false
+}
+
+// indentBlockEnd returns the last row indented deeper than start, skipping
+// trailing blank lines.
+func indentBlockEnd(lines []string, start int) (int, bool) {
+	base := indentOf(lines[start])
+	end := start
+	for i := start + 1; i < len(lines); i++ {
+		if strings.TrimSpace(lines[i]) == "" {
+			continue
+		}
+		if indentOf(lines[i]) <= base {
+			break
+		}
+		end = i
+	}
+	return end, end > start
+}
+
+func indentOf(line string) int {
+	n := 0
+	for _, c := range line {
+		switch c {
+		case ' ':
+			n++
+		case '\t':
+			n += 4
+		default:
+			return n
+		}
+	}
+	return n
+}
+
+// attachmentLabel names an attachment for display, e.g. "📎 main.go · lines 3-9".
+func attachmentLabel(a llm.Attachment) string {
+	label := "📎 " + filepath.Base(a.Name)
+	if a.Label != "" {
+		label += " · " + a.Label
+	}
+	return label
+}
+
+// contextChip describes the context the next prompt will carry.
+func (m AgentModel) contextChip() string {
+	if a := m.pinned; a != nil {
+		return StyleModeVisual.Render(" "+attachmentLabel(*a)+" ") + StyleDim.Render("  "+m.keys.AgentContext+" to drop")
+	}
+	label := m.contextMode.String()
+	if m.contextMode == contextFunction {
+		if m.cursorFunction == "" {
+			return StyleDim.Render("no function at cursor  (" + m.keys.AgentContext + " to change)")
+		}
+		label = m.cursorFunction
+	}
+	if m.contextMode != contextNone {
+		name := "[No Name]"
+		if m.currentFile != "" {
+			name = filepath.Base(m.currentFile)
+		}
+		label = "📎 " + name + " · " + label
+	}
+	return StyleDim.Render(label + "  (" + m.keys.AgentContext + " to change)")
+}
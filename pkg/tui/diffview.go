@@ -0,0 +1,28 @@
+package tui
+
+import (
+	"strings"
+)
+
+// renderDiff colours a unified diff for display in a viewport.
+func renderDiff(unified string) string {
+	if unified == "" {
+		return StyleDim.Render("No differences")
+	}
+	lines := strings.Split(strings.TrimSuffix(unified, "\n"), "\n")
+	for i, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+			lines[i] = StyleBold.Render(line)
+		case strings.HasPrefix(line, "@@"):
+			lines[i] = StyleDiffHunk.Render(line)
+		case strings.HasPrefix(line, "+"):
+			lines[i] = StyleDiffAdd.Render(line)
+		case strings.HasPrefix(line, "-"):
+			lines[i] = StyleDiffDelete.Render(line)
+		default:
+			lines[i] = StyleDim.Render(line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
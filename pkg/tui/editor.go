@@ -5,11 +5,16 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"unicode"
 	"unicode/utf8"
 
 	"github.com/CiaranMccarthy1/boba-text/pkg/config"
+	"github.com/CiaranMccarthy1/boba-text/pkg/diff"
+	"github.com/CiaranMccarthy1/boba-text/pkg/fileio"
+	"github.com/CiaranMccarthy1/boba-text/pkg/theme"
 	"github.com/charmbracelet/bubbles/textarea"
 	"github.com/charmbracelet/bubbles/textinput"
+	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 )
@@ -37,11 +42,51 @@ type EditorModel struct {
 	keys        config.Keys
 	yankBuffer  string
 	searchQuery string
+	// hlSearch highlights the matches of searchQuery until :noh.
+	hlSearch    bool
 	searchInput textinput.Model
+	format      fileio.Format
+	files       config.Files
+	readOnly    bool
+
+	// diskStamp records the on-disk version the buffer was loaded from or last
+	// saved to; diskConflict is set once the file has changed underneath us.
+	diskStamp    fileio.Stamp
+	diskConflict bool
+	showPager    bool
+	pager        viewport.Model
+
+	// swap is a leftover journal found when the file was opened, awaiting a
+	// recover/diff/delete decision; swapText is what we last journaled.
+	swap     *fileio.Swap
+	swapText string
+
+	// undo and redo hold snapshots of the buffer taken before each change.
+	undo []bufferState
+	redo []bufferState
+
+	// anchorRow and anchorCol are where visual mode started; lineVisual is
+	// set for linewise (V) selections. visualStart and visualEnd keep the rows
+	// of the last selection for '<,'>, visualStartCol and visualEndCol its
+	// columns and visualText its exact text.
+	anchorRow, anchorCol         int
+	lineVisual                   bool
+	visualStart, visualEnd       int
+	visualStartCol, visualEndCol int
+	visualText                   string
 }
 
+// bufferState is an undo snapshot: the text and where the cursor was.
+type bufferState struct {
+	text     string
+	row, col int
+}
+
+// maxUndo bounds the number of undo snapshots kept.
+const maxUndo = 100
+
 // NewEditor creates a new editor model with the given command configuration.
-func NewEditor(cmdConfig config.Commands, keyConfig config.Keys) EditorModel {
+func NewEditor(cmdConfig config.Commands, keyConfig config.Keys, fileConfig config.Files) EditorModel {
 	ta := textarea.New()
 	ta.Placeholder = "Empty file..."
 	ta.Focus()
@@ -53,6 +98,7 @@ func NewEditor(cmdConfig config.Commands, keyConfig config.Keys) EditorModel {
 	ti.Placeholder = ""
 	ti.CharLimit = 156
 	ti.Width = 50
+	ti.ShowSuggestions = true
 
 	si := textinput.New()
 	si.Prompt = "/"
@@ -67,6 +113,9 @@ func NewEditor(cmdConfig config.Commands, keyConfig config.Keys) EditorModel {
 		mode:        ModeNormal,
 		commands:    cmdConfig,
 		keys:        keyConfig,
+		format:      fileio.DefaultFormat(),
+		files:       fileConfig,
+		pager:       viewport.New(0, 0),
 	}
 }
 
@@ -79,13 +128,31 @@ func (m EditorModel) Update(msg tea.Msg) (EditorModel, tea.Cmd) {
 	var cmds []tea.Cmd
 
 	switch msg := msg.(type) {
+	case ConfigChangedMsg:
+		m.commands = msg.Config.Commands
+		m.keys = msg.Config.Keys
+		m.files = msg.Config.Files
 	case tea.KeyMsg:
+		if m.showPager {
+			return m, m.updatePager(msg)
+		}
+		if m.swap != nil {
+			return m, m.handleSwapPrompt(msg)
+		}
+		if m.diskConflict {
+			return m, m.handleDiskConflict(msg)
+		}
 		switch m.mode {
 		case ModeNormal:
 			cmd = m.handleNormalMode(msg)
 			if cmd != nil {
 				return m, cmd
 			}
+		case ModeVisual:
+			cmd = m.handleVisualMode(msg)
+			if cmd != nil {
+				return m, cmd
+			}
 		case ModeInsert:
 			switch msg.String() {
 			case m.keys.EditorNormalMode:
@@ -119,6 +186,7 @@ func (m EditorModel) Update(msg tea.Msg) (EditorModel, tea.Cmd) {
 				m.msg = ""
 			case "enter":
 				m.searchQuery = m.searchInput.Value()
+				m.hlSearch = m.searchQuery != ""
 				m.mode = ModeNormal
 				m.searchInput.Blur()
 				if m.searchQuery != "" {
@@ -144,6 +212,7 @@ func (m *EditorModel) handleNormalMode(msg tea.KeyMsg) tea.Cmd {
 	switch key {
 	// Enter insert mode
 	case m.keys.EditorInsertMode:
+		m.pushUndo()
 		m.mode = ModeInsert
 		m.textarea.Focus()
 		m.msg = ""
@@ -152,6 +221,13 @@ func (m *EditorModel) handleNormalMode(msg tea.KeyMsg) tea.Cmd {
 		m.mode = ModeCommand
 		m.textinput.Focus()
 		m.textinput.SetValue("")
+		m.textinput.SetSuggestions(commandSuggestions())
+		m.msg = ""
+	// Visual mode
+	case "v", "V":
+		m.anchorRow, m.anchorCol = m.currentCursor()
+		m.lineVisual = key == "V"
+		m.mode = ModeVisual
 		m.msg = ""
 	// Search mode
 	case "/":
@@ -189,6 +265,7 @@ func (m *EditorModel) handleNormalMode(msg tea.KeyMsg) tea.Cmd {
 		m.textarea.CursorStart()
 	// Insert above/below
 	case "o":
+		m.pushUndo()
 		m.textarea.CursorEnd()
 		cmd := m.applyTextareaKey(tea.KeyMsg{Type: tea.KeyEnter})
 		m.textarea.Focus()
@@ -196,6 +273,7 @@ func (m *EditorModel) handleNormalMode(msg tea.KeyMsg) tea.Cmd {
 		m.modified = true
 		return cmd
 	case "O":
+		m.pushUndo()
 		m.textarea.CursorStart()
 		cmd := m.applyTextareaKey(tea.KeyMsg{Type: tea.KeyEnter})
 		m.moveToLine(m.textarea.Line() - 1)
@@ -206,21 +284,25 @@ func (m *EditorModel) handleNormalMode(msg tea.KeyMsg) tea.Cmd {
 		return cmd
 	// Insert at start/end of line
 	case "A":
+		m.pushUndo()
 		m.textarea.CursorEnd()
 		m.textarea.Focus()
 		m.mode = ModeInsert
 	case "I":
+		m.pushUndo()
 		m.textarea.CursorStart()
 		m.textarea.Focus()
 		m.mode = ModeInsert
 	// Delete char under cursor
 	case "x":
+		m.pushUndo()
 		cmd := m.applyTextareaKey(tea.KeyMsg{Type: tea.KeyDelete})
 		m.modified = true
 		return cmd
 	// Search next/prev
 	case "n":
 		if m.searchQuery != "" {
+			m.hlSearch = true
 			if m.findNextMatch(true) {
 				m.msg = "/" + m.searchQuery
 			} else {
@@ -229,18 +311,25 @@ func (m *EditorModel) handleNormalMode(msg tea.KeyMsg) tea.Cmd {
 		}
 	case "N":
 		if m.searchQuery != "" {
+			m.hlSearch = true
 			if m.findNextMatch(false) {
 				m.msg = "/" + m.searchQuery
 			} else {
 				m.msg = "No match: /" + m.searchQuery
 			}
 		}
+	// Undo/redo
+	case "u":
+		m.undoChange()
+	case "ctrl+r":
+		m.redoChange()
 	// Yank current line
 	case "y":
 		m.yankCurrentLine()
 	// Paste
 	case "p":
 		if m.yankBuffer != "" {
+			m.pushUndo()
 			m.textarea.Focus()
 			m.textarea.InsertString(m.yankBuffer)
 			m.modified = true
@@ -250,11 +339,159 @@ func (m *EditorModel) handleNormalMode(msg tea.KeyMsg) tea.Cmd {
 	return nil
 }
 
+// handleVisualMode extends the selection with the normal-mode motions. y
+// yanks it and : starts a command on its lines, as in Vim.
+func (m *EditorModel) handleVisualMode(msg tea.KeyMsg) tea.Cmd {
+	switch key := msg.String(); key {
+	case "v", "V":
+		if m.lineVisual != (key == "V") {
+			m.lineVisual = key == "V"
+			return nil
+		}
+		m.endVisual()
+	case m.keys.EditorNormalMode:
+		m.endVisual()
+	case "y":
+		m.endVisual()
+		m.yankBuffer = m.visualText
+		m.msg = "Yanked selection"
+	case m.keys.EditorCommandMode:
+		m.endVisual()
+		m.mode = ModeCommand
+		m.textinput.Focus()
+		m.textinput.SetValue("'<,'>")
+		m.textinput.CursorEnd()
+		m.textinput.SetSuggestions(commandSuggestions())
+	case "j", "down", "k", "up", "h", "left", "l", "right", "w", "b", "0", "home", "$", "end", "G":
+		return m.handleNormalMode(msg)
+	}
+	return nil
+}
+
+// endVisual leaves visual mode, remembering the selection for '<,'>.
+func (m *EditorModel) endVisual() {
+	m.visualStart, m.visualStartCol, m.visualEnd, m.visualEndCol = m.selection()
+	m.visualText = m.selectedText()
+	m.mode = ModeNormal
+	m.msg = ""
+}
+
+// selection returns the visual selection's start and end, in order. Columns
+// are inclusive rune offsets; a linewise selection spans whole lines.
+func (m *EditorModel) selection() (startRow, startCol, endRow, endCol int) {
+	row, col := m.currentCursor()
+	startRow, startCol, endRow, endCol = m.anchorRow, m.anchorCol, row, col
+	if endRow < startRow || (endRow == startRow && endCol < startCol) {
+		startRow, startCol, endRow, endCol = endRow, endCol, startRow, startCol
+	}
+	return startRow, startCol, endRow, endCol
+}
+
+// selectedText returns the text under the visual selection.
+func (m *EditorModel) selectedText() string {
+	lines := strings.Split(m.textarea.Value(), "\n")
+	startRow, startCol, endRow, endCol := m.selection()
+	endRow = min(endRow, len(lines)-1)
+	if startRow > endRow {
+		return ""
+	}
+	if m.lineVisual {
+		return strings.Join(lines[startRow:endRow+1], "\n")
+	}
+	first := []rune(lines[startRow])
+	last := []rune(lines[endRow])
+	endCol = min(endCol+1, len(last))
+	if startRow == endRow {
+		return string(first[min(startCol, endCol):endCol])
+	}
+	out := []string{string(first[min(startCol, len(first)):])}
+	out = append(out, lines[startRow+1:endRow]...)
+	return strings.Join(append(out, string(last[:endCol])), "\n")
+}
+
+// parseRange splits a leading line range such as '<,'>, %, 3,12 or .,$ off a
+// command, returning 0-based rows. A bare number is left for :<line>.
+func (m *EditorModel) parseRange(val string) (start, end int, rest string, ok bool) {
+	last := max(m.textarea.LineCount()-1, 0)
+	if strings.HasPrefix(val, "%") {
+		return 0, last, val[1:], len(val) > 1
+	}
+	address := func(s string) (int, string, bool) {
+		switch {
+		case strings.HasPrefix(s, "'<"):
+			return m.visualStart, s[2:], true
+		case strings.HasPrefix(s, "'>"):
+			return m.visualEnd, s[2:], true
+		case strings.HasPrefix(s, "."):
+			return m.textarea.Line(), s[1:], true
+		case strings.HasPrefix(s, "$"):
+			return last, s[1:], true
+		}
+		n := 0
+		for n < len(s) && s[n] >= '0' && s[n] <= '9' {
+			n++
+		}
+		if n == 0 {
+			return 0, s, false
+		}
+		line, _ := strconv.Atoi(s[:n])
+		return line - 1, s[n:], true
+	}
+
+	start, rest, ok = address(val)
+	if !ok {
+		return 0, 0, val, false
+	}
+	end = start
+	if strings.HasPrefix(rest, ",") {
+		if end, rest, ok = address(rest[1:]); !ok {
+			return 0, 0, val, false
+		}
+	}
+	// Without a command after it, this is a jump such as :12.
+	if rest == "" || !unicode.IsLetter(rune(rest[0])) {
+		return 0, 0, val, false
+	}
+	if end < start {
+		start, end = end, start
+	}
+	return max(start, 0), min(end, last), rest, true
+}
+
+// rangeCommand runs a command given a line range: :Ask asks about the lines
+// and :insert replaces them with a code block from the agent pane. visual is
+// set when the range is the last selection.
+func (m *EditorModel) rangeCommand(start, end int, cmd string, visual bool) tea.Cmd {
+	name, arg, _ := strings.Cut(cmd, " ")
+	// The exact text of a characterwise selection, not its whole lines.
+	charwise := visual && !m.lineVisual && m.visualText != ""
+	switch name {
+	case "Ask":
+		a := m.rangeAttachment(start, end)
+		if charwise {
+			a.Content = m.visualText
+		}
+		return askAgent(a, arg)
+	case "insert":
+		cmd, err := snippetCmd(arg, snippetMsg{Replace: true, Start: start, End: end, Visual: charwise})
+		if err != nil {
+			m.msg = err.Error()
+		}
+		return cmd
+	}
+	m.msg = "No range allowed: " + name
+	return nil
+}
+
 // executeCommand processes a command-mode command string.
 func (m *EditorModel) executeCommand(val string) tea.Cmd {
 	m.mode = ModeNormal
 	m.textinput.Blur()
 
+	if start, end, rest, ok := m.parseRange(val); ok {
+		return m.rangeCommand(start, end, rest, strings.HasPrefix(val, "'<,'>"))
+	}
+
 	// Handle line number jump :<number>
 	if len(val) > 0 && val[0] >= '0' && val[0] <= '9' {
 		lineNum, err := strconv.Atoi(val)
@@ -269,14 +506,68 @@ func (m *EditorModel) executeCommand(val string) tea.Cmd {
 
 	switch {
 	case contains(m.commands.Save, val):
-		return m.saveFile()
+		m.saveFile(false)
+	case strings.HasSuffix(val, "!") && contains(m.commands.Save, strings.TrimSuffix(val, "!")):
+		m.saveFile(true)
 	case contains(m.commands.Quit, val):
-		return tea.Quit
+		return m.quit()
 	case val == "wq" || val == "x":
-		m.saveFile()
-		return tea.Quit
+		if m.saveFile(false) {
+			return m.quit()
+		}
+	case val == "wq!":
+		if m.saveFile(true) {
+			return m.quit()
+		}
 	case val == "q!":
-		return tea.Quit
+		return m.quit()
+	case val == "source" || val == "reload" || strings.HasPrefix(val, "source "):
+		return func() tea.Msg { return reloadConfigMsg{} }
+	case val == "colorscheme" || val == "colo" ||
+		strings.HasPrefix(val, "colorscheme ") || strings.HasPrefix(val, "colo "):
+		_, name, _ := strings.Cut(val, " ")
+		name = strings.TrimSpace(name)
+		return func() tea.Msg { return colorschemeMsg{name: name} }
+	case val == "config":
+		return func() tea.Msg { return showConfigMsg{} }
+	case val == "n" || val == "next" || val == "n!" || val == "next!":
+		force := strings.HasSuffix(val, "!")
+		return func() tea.Msg { return argMsg{delta: 1, force: force} }
+	case val == "N" || val == "prev" || val == "previous" ||
+		val == "N!" || val == "prev!" || val == "previous!":
+		force := strings.HasSuffix(val, "!")
+		return func() tea.Msg { return argMsg{delta: -1, force: force} }
+	case val == "noh" || val == "nohlsearch":
+		m.hlSearch = false
+	case strings.HasPrefix(val, "set "):
+		m.setOption(strings.TrimSpace(strings.TrimPrefix(val, "set ")))
+	case val == "Ask" || strings.HasPrefix(val, "Ask "):
+		if a := m.contextAttachment(contextBuffer); a != nil {
+			return askAgent(*a, strings.TrimPrefix(val, "Ask"))
+		}
+		m.msg = "Nothing to ask about: the buffer is empty"
+	case val == "AskFunc" || strings.HasPrefix(val, "AskFunc "):
+		a, err := m.functionAttachment()
+		if err != nil {
+			m.msg = err.Error()
+			return nil
+		}
+		return askAgent(a, strings.TrimPrefix(val, "AskFunc"))
+	case val == "yank" || strings.HasPrefix(val, "yank "),
+		val == "insert" || strings.HasPrefix(val, "insert "):
+		name, arg, _ := strings.Cut(val, " ")
+		cmd, err := snippetCmd(arg, snippetMsg{Yank: name == "yank"})
+		if err != nil {
+			m.msg = err.Error()
+		}
+		return cmd
+	case val == "AgentHistory":
+		return func() tea.Msg { return agentHistoryMsg{} }
+	case val == "AgentExport" || val == "AgentExport!" ||
+		strings.HasPrefix(val, "AgentExport ") || strings.HasPrefix(val, "AgentExport! "):
+		name, path, _ := strings.Cut(val, " ")
+		msg := agentExportMsg{Path: strings.TrimSpace(path), Force: strings.HasSuffix(name, "!")}
+		return func() tea.Msg { return msg }
 	case strings.HasPrefix(val, "e "):
 		path := strings.TrimPrefix(val, "e ")
 		path = strings.TrimSpace(path)
@@ -290,22 +581,282 @@ func (m *EditorModel) executeCommand(val string) tea.Cmd {
 	return nil
 }
 
-// saveFile writes the editor content to disk.
-func (m *EditorModel) saveFile() tea.Cmd {
-	if m.filename != "" {
-		err := os.WriteFile(m.filename, []byte(m.textarea.Value()), 0644)
+// commandSuggestions lists command-line completions offered with tab.
+func commandSuggestions() []string {
+	suggestions := []string{
+		"set fileformat=unix", "set fileformat=dos", "set fileformat=mac",
+		"set fileencoding=utf-8", "set fileencoding=utf-16le", "set fileencoding=utf-16be", "set fileencoding=latin1",
+		"config", "source", "reload", "Ask ", "AskFunc ", "'<,'>Ask ", "yank ", "insert ", "'<,'>insert ",
+		"AgentHistory", "AgentExport ", "noh",
+	}
+	for _, name := range theme.Names() {
+		suggestions = append(suggestions, "colorscheme "+name)
+	}
+	return suggestions
+}
+
+// setOption handles :set fileformat= and :set fileencoding= (and their short forms).
+func (m *EditorModel) setOption(opt string) {
+	name, value, hasValue := strings.Cut(opt, "=")
+	switch name {
+	case "fileformat", "ff":
+		if !hasValue {
+			m.msg = "fileformat=" + string(m.format.LineEnding)
+			return
+		}
+		le, err := fileio.ParseLineEnding(value)
 		if err != nil {
-			m.msg = "Error saving: " + err.Error()
-		} else {
-			m.msg = "Saved: " + m.filename
-			m.modified = false
+			m.msg = err.Error()
+			return
 		}
-	} else {
+		if le != m.format.LineEnding {
+			m.format.LineEnding = le
+			m.modified = true
+		}
+		m.msg = "fileformat=" + string(le)
+	case "fileencoding", "fenc":
+		if !hasValue {
+			m.msg = "fileencoding=" + string(m.format.Encoding)
+			return
+		}
+		enc, err := fileio.ParseEncoding(value)
+		if err != nil {
+			m.msg = err.Error()
+			return
+		}
+		if enc != m.format.Encoding {
+			m.format.Encoding = enc
+			m.modified = true
+		}
+		m.msg = "fileencoding=" + string(enc)
+	case "bomb", "nobomb":
+		bom := name == "bomb"
+		if bom != m.format.BOM {
+			m.format.BOM = bom
+			m.modified = true
+		}
+		m.msg = name
+	default:
+		m.msg = "Unknown option: " + opt
+	}
+}
+
+// encodedContent returns the buffer converted to the file's on-disk format.
+func (m *EditorModel) encodedContent() ([]byte, error) {
+	return fileio.Encode(m.textarea.Value(), m.format)
+}
+
+// saveFile writes the editor content to disk and reports whether it succeeded.
+// Unless force is set it refuses to overwrite a file that changed on disk
+// since it was read.
+func (m *EditorModel) saveFile(force bool) bool {
+	if m.filename == "" {
 		m.msg = "No filename set!"
+		return false
+	}
+	if m.readOnly && !force {
+		m.msg = "'readonly' option is set (add ! to override)"
+		return false
+	}
+	if !force && !m.diskStamp.IsZero() {
+		if changed, _, err := m.diskStamp.Changed(m.filename); err == nil && changed {
+			m.diskConflict = true
+			m.msg = "File changed on disk since it was read (add ! to override)"
+			return false
+		}
+	}
+
+	data, err := m.encodedContent()
+	if err == nil {
+		err = fileio.Save(m.filename, data, fileio.SaveOptions{
+			Backup:    m.files.Backup,
+			BackupDir: m.files.BackupDir,
+		})
+	}
+	if err != nil {
+		m.msg = "Error saving: " + err.Error()
+		return false
+	}
+	m.msg = "Saved: " + m.filename
+	m.modified = false
+	m.diskConflict = false
+	m.diskStamp, _ = fileio.StampFile(m.filename)
+	m.clearSwap()
+	return true
+}
+
+// quit removes the buffer's swap file and exits. Swaps only survive when the
+// editor dies without going through here.
+func (m *EditorModel) quit() tea.Cmd {
+	m.clearSwap()
+	return tea.Quit
+}
+
+// loadFile reads path from disk, detecting its format, and replaces the buffer.
+func (m *EditorModel) loadFile(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	text, format, err := fileio.Decode(content)
+	if err != nil {
+		return err
+	}
+	m.SetContent(text, path)
+	m.format = format
+	m.diskStamp, _ = fileio.StampFile(path)
+	if format.Mixed {
+		m.msg = fmt.Sprintf("Mixed line endings: saving writes them all as %s (:set ff= to choose)", format.LineEnding)
+	}
+
+	if swap, err := fileio.ReadSwap(path); err == nil {
+		if swap.Content == text {
+			fileio.RemoveSwap(path)
+		} else {
+			m.swap = &swap
+			m.msg = fmt.Sprintf("Swap file found (%s): [r]ecover, [d]iff, [x] delete, [i]gnore",
+				swap.Modified.Format("2006-01-02 15:04"))
+		}
+	}
+	return nil
+}
+
+// writeSwap journals unsaved changes so they can be recovered after a crash.
+func (m *EditorModel) writeSwap() {
+	if m.filename == "" || !m.modified || m.swap != nil {
+		return
+	}
+	content := m.textarea.Value()
+	if content == m.swapText {
+		return
+	}
+	if err := fileio.WriteSwap(m.filename, content); err == nil {
+		m.swapText = content
+	}
+}
+
+// clearSwap deletes the journal once the buffer is safely on disk or discarded.
+func (m *EditorModel) clearSwap() {
+	if m.filename == "" || m.swap != nil {
+		return
+	}
+	fileio.RemoveSwap(m.filename)
+	m.swapText = ""
+}
+
+// handleSwapPrompt processes the recover/diff/delete/ignore prompt shown when a
+// file is opened with a leftover swap.
+func (m *EditorModel) handleSwapPrompt(msg tea.KeyMsg) tea.Cmd {
+	switch msg.String() {
+	case "r", "R":
+		m.pushUndo()
+		m.textarea.SetValue(m.swap.Content)
+		m.textarea.CursorStart()
+		m.modified = true
+		m.swap = nil
+		m.msg = "Recovered from swap; :w to keep the changes"
+	case "d", "D":
+		m.openDiff(m.filename, m.filename+" (swap)", m.textarea.Value(), m.swap.Content)
+	case "x", "X":
+		m.swap = nil
+		m.clearSwap()
+		m.msg = "Swap file deleted"
+	case "i", "I", "esc":
+		m.swap = nil
+		m.msg = ""
+	}
+	return nil
+}
+
+// checkDisk compares the open file against the version it was loaded from and
+// prompts the user when another program has changed it. It reports false when
+// the check is put off until the user leaves insert mode.
+func (m *EditorModel) checkDisk() bool {
+	if m.mode == ModeInsert {
+		return false
+	}
+	if m.filename == "" || m.diskStamp.IsZero() || m.diskConflict {
+		return true
+	}
+	changed, _, err := m.diskStamp.Changed(m.filename)
+	if err != nil || !changed {
+		return true
+	}
+	m.diskConflict = true
+	m.msg = "File changed on disk: [r]eload, [k]eep buffer, [d]iff"
+	return true
+}
+
+// handleDiskConflict processes the reload/keep/diff prompt and the diff view.
+func (m *EditorModel) handleDiskConflict(msg tea.KeyMsg) tea.Cmd {
+	switch msg.String() {
+	case "r", "R":
+		row := m.textarea.Line()
+		// Loading clears the history, so keep the buffer as it was to undo to.
+		prev, undo := m.snapshot(), m.undo
+		if err := m.loadFile(m.filename); err != nil {
+			m.msg = "Error reloading: " + err.Error()
+			return nil
+		}
+		if prev.text != m.textarea.Value() {
+			m.undo = append(undo, prev)
+			if len(m.undo) > maxUndo {
+				m.undo = m.undo[1:]
+			}
+		}
+		m.moveToLine(row)
+		m.msg = "Reloaded: " + m.filename
+		if len(m.undo) > 0 {
+			m.msg += " (u to get the buffer back)"
+		}
+	case "k", "K", "esc":
+		// Adopt the disk version as the base so :w overwrites it without asking again.
+		m.diskStamp, _ = fileio.StampFile(m.filename)
+		m.diskConflict = false
+		m.modified = true
+		m.msg = "Kept buffer; :w will overwrite the file on disk"
+	case "d", "D":
+		content, err := os.ReadFile(m.filename)
+		if err != nil {
+			m.msg = "Error reading: " + err.Error()
+			return nil
+		}
+		disk, _, err := fileio.Decode(content)
+		if err != nil {
+			m.msg = "Error reading: " + err.Error()
+			return nil
+		}
+		m.openDiff(m.filename+" (disk)", m.filename+" (buffer)", disk, m.textarea.Value())
 	}
 	return nil
 }
 
+// openDiff shows a coloured unified diff in place of the buffer until closed with q.
+func (m *EditorModel) openDiff(oldName, newName, oldText, newText string) {
+	m.openPager(renderDiff(diff.Unified(oldName, newName, diff.Compute(oldText, newText, 3))))
+}
+
+// openPager shows read-only content in place of the buffer until closed with q.
+func (m *EditorModel) openPager(content string) {
+	m.pager.Width = m.width - 2
+	m.pager.Height = m.height - 4
+	m.pager.SetContent(content)
+	m.pager.GotoTop()
+	m.showPager = true
+}
+
+// updatePager scrolls the pager, returning to any pending prompt on q/esc.
+func (m *EditorModel) updatePager(msg tea.KeyMsg) tea.Cmd {
+	switch msg.String() {
+	case "q", "esc":
+		m.showPager = false
+		return nil
+	}
+	var cmd tea.Cmd
+	m.pager, cmd = m.pager.Update(msg)
+	return cmd
+}
+
 // applyTextareaKey sends a key message through the textarea to reuse its motion logic.
 func (m *EditorModel) applyTextareaKey(msg tea.KeyMsg) tea.Cmd {
 	ta, cmd := m.textarea.Update(msg)
@@ -488,6 +1039,11 @@ func contains(slice []string, val string) bool {
 
 // View renders the editor as a string.
 func (m EditorModel) View() string {
+	body := m.highlight(m.textarea.View())
+	if m.showPager {
+		body = m.pager.View()
+	}
+
 	// Build the status line (Neovim lualine-style)
 	var barContent string
 	var modeStyle lipgloss.Style
@@ -515,24 +1071,31 @@ func (m EditorModel) View() string {
 			fname = "[No Name]"
 		}
 		modifiedMark := ""
+		if m.readOnly {
+			modifiedMark += " [RO]"
+		}
 		if m.modified {
-			modifiedMark = " [+]"
+			modifiedMark += " [+]"
 		}
 
 		msgInfo := ""
 		if m.msg != "" {
 			msgInfo = "  " + m.msg
 		}
+		if m.mode == ModeVisual {
+			start, _, end, _ := m.selection()
+			msgInfo = "  " + lineLabel(start, end) + " selected"
+		}
 
 		// Lualine-style: MODE | filename [+] | message
-		statusRight := StyleDim.Render(fmt.Sprintf(" %s ", fileType(m.filename)))
+		statusRight := StyleDim.Render(fmt.Sprintf(" %s | %s ", m.format, fileType(m.filename)))
 		barContent = fmt.Sprintf("%s %s%s%s%s",
 			modeStyle.Render(" "+modeTxt+" "),
 			fname, modifiedMark, msgInfo, statusRight)
 		// Return early with full status bar
 		statusBar := lipgloss.NewStyle().
 			Foreground(ColorText).
-			Background(lipgloss.Color("#2A2A2A")).
+			Background(ColorStatusLine).
 			Width(m.width).
 			Padding(0, 0).
 			Render(barContent)
@@ -543,7 +1106,7 @@ func (m EditorModel) View() string {
 			Render(
 				lipgloss.JoinVertical(
 					lipgloss.Left,
-					m.textarea.View(),
+					body,
 					statusBar,
 				),
 			)
@@ -552,7 +1115,7 @@ func (m EditorModel) View() string {
 	// Command/Search mode status bar
 	statusBar := lipgloss.NewStyle().
 		Foreground(ColorText).
-		Background(lipgloss.Color("#2A2A2A")).
+		Background(ColorStatusLine).
 		Width(m.width).
 		Padding(0, 0).
 		Render(barContent)
@@ -563,7 +1126,7 @@ func (m EditorModel) View() string {
 		Render(
 			lipgloss.JoinVertical(
 				lipgloss.Left,
-				m.textarea.View(),
+				body,
 				statusBar,
 			),
 		)
@@ -629,4 +1192,80 @@ func (m *EditorModel) SetContent(content string, filename string) {
 	m.filename = filename
 	m.modified = false
 	m.msg = ""
+	m.format = fileio.DefaultFormat()
+	m.diskStamp = fileio.Stamp{}
+	m.diskConflict = false
+	m.showPager = false
+	m.swap = nil
+	m.swapText = ""
+	m.undo = nil
+	m.redo = nil
+}
+
+// snapshot captures the buffer for undo.
+func (m *EditorModel) snapshot() bufferState {
+	row, col := m.currentCursor()
+	return bufferState{text: m.textarea.Value(), row: row, col: col}
+}
+
+// restore puts a snapshot back into the buffer. The buffer counts as modified
+// afterwards even if it matches a saved version, so a change is never lost.
+func (m *EditorModel) restore(s bufferState) {
+	m.textarea.SetValue(s.text)
+	m.moveCursorTo(s.row, s.col)
+	m.modified = true
+}
+
+// pushUndo records the buffer before a change. Consecutive snapshots of the
+// same text, such as entering insert mode without typing, collapse into one.
+func (m *EditorModel) pushUndo() {
+	s := m.snapshot()
+	if n := len(m.undo); n > 0 && m.undo[n-1].text == s.text {
+		return
+	}
+	m.undo = append(m.undo, s)
+	if len(m.undo) > maxUndo {
+		m.undo = m.undo[1:]
+	}
+	m.redo = nil
+}
+
+func (m *EditorModel) undoChange() {
+	cur := m.snapshot()
+	// Skip snapshots identical to the buffer, left by an insert that typed nothing.
+	for len(m.undo) > 0 && m.undo[len(m.undo)-1].text == cur.text {
+		m.undo = m.undo[:len(m.undo)-1]
+	}
+	if len(m.undo) == 0 {
+		m.msg = "Already at oldest change"
+		return
+	}
+	prev := m.undo[len(m.undo)-1]
+	m.undo = m.undo[:len(m.undo)-1]
+	m.redo = append(m.redo, cur)
+	m.restore(prev)
+	m.msg = fmt.Sprintf("Undo (%d more)", len(m.undo))
+}
+
+func (m *EditorModel) redoChange() {
+	if len(m.redo) == 0 {
+		m.msg = "Already at newest change"
+		return
+	}
+	next := m.redo[len(m.redo)-1]
+	m.redo = m.redo[:len(m.redo)-1]
+	m.undo = append(m.undo, m.snapshot())
+	m.restore(next)
+	m.msg = fmt.Sprintf("Redo (%d more)", len(m.redo))
+}
+
+// replaceContent swaps in new buffer text as a single undoable change, keeping
+// the cursor on the same line where possible.
+func (m *EditorModel) replaceContent(text string) {
+	m.pushUndo()
+	row, _ := m.currentCursor()
+	m.textarea.SetValue(text)
+	m.moveToLine(row)
+	m.textarea.CursorStart()
+	m.modified = true
 }
@@ -48,18 +48,24 @@ func NewFileTree(startPath string, keyConfig config.Keys) FileTreeModel {
 	return m
 }
 
-// loadFiles loads directory entries from the current path and adds parent entry if not at root.
-// Sorts directories first, then files alphabetically.
-func (m *FileTreeModel) loadFiles() {
-	entries, _ := os.ReadDir(m.path)
+// readDirSorted lists a directory with directories first, then files,
+// alphabetical within each group.
+func readDirSorted(path string) []os.DirEntry {
+	entries, _ := os.ReadDir(path)
 
-	// Sort: directories first, then files, alphabetical within each group
 	sort.Slice(entries, func(i, j int) bool {
 		if entries[i].IsDir() != entries[j].IsDir() {
 			return entries[i].IsDir()
 		}
 		return strings.ToLower(entries[i].Name()) < strings.ToLower(entries[j].Name())
 	})
+	return entries
+}
+
+// loadFiles loads directory entries from the current path and adds parent entry if not at root.
+// Sorts directories first, then files alphabetically.
+func (m *FileTreeModel) loadFiles() {
+	entries := readDirSorted(m.path)
 
 	parent := filepath.Dir(m.path)
 	if parent != m.path {
@@ -79,6 +85,8 @@ func (m FileTreeModel) Init() tea.Cmd {
 // Update handles messages and updates the file tree state.
 func (m FileTreeModel) Update(msg tea.Msg) (FileTreeModel, tea.Cmd) {
 	switch msg := msg.(type) {
+	case ConfigChangedMsg:
+		m.keys = msg.Config.Keys
 	case tea.KeyMsg:
 		switch msg.String() {
 		case m.keys.TreeUp, m.keys.TreeUpAlt:
@@ -229,4 +237,6 @@ func (m FileTreeModel) View() string {
 
 type OpenFileMsg struct {
 	Path string
+	Line int
+	Col  int
 }
@@ -0,0 +1,305 @@
+package tui
+
+import (
+	"strings"
+)
+
+// language is enough of a language's lexical syntax to colour it.
+type language struct {
+	keywords  map[string]bool
+	types     map[string]bool
+	constants map[string]bool
+
+	lineComments []string
+	blockComment [2]string
+	// quotes open strings that end on the same line; multiline delimiters
+	// open strings that may run on to later lines.
+	quotes    string
+	multiline []string
+	// charQuote treats ' as a character literal only when it closes within
+	// a few bytes, so Rust lifetimes are left alone.
+	charQuote bool
+	// ignoreCase matches keywords in any case, as SQL does.
+	ignoreCase bool
+}
+
+func words(s string) map[string]bool {
+	m := map[string]bool{}
+	for _, w := range strings.Fields(s) {
+		m[w] = true
+	}
+	return m
+}
+
+var cLike = &language{
+	keywords: words(`auto break case const continue default do else enum extern for goto if inline register
+		return signed sizeof static struct switch typedef union unsigned volatile while class public private
+		protected virtual override namespace using new delete template typename this throw try catch finally
+		import package extends implements final abstract interface fun val var when object companion`),
+	types:        words(`int char float double void bool boolean long short byte String size_t uint8_t int32_t int64_t uint32_t uint64_t`),
+	constants:    words(`true false null nullptr NULL`),
+	lineComments: []string{"//"},
+	blockComment: [2]string{"/*", "*/"},
+	quotes:       `"'`,
+	charQuote:    true,
+}
+
+// languages are the languages code blocks are coloured for, by name.
+var languages = map[string]*language{
+	"go": {
+		keywords: words(`break case chan const continue default defer else fallthrough for func go goto if
+			import interface map package range return select struct switch type var`),
+		types: words(`any bool byte comparable complex64 complex128 error float32 float64 int int8 int16 int32 int64
+			rune string uint uint8 uint16 uint32 uint64 uintptr`),
+		constants:    words(`true false nil iota`),
+		lineComments: []string{"//"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       `"'`,
+		multiline:    []string{"`"},
+		charQuote:    true,
+	},
+	"python": {
+		keywords: words(`and as assert async await break class continue def del elif else except finally for
+			from global if import in is lambda nonlocal not or pass raise return try while with yield match case`),
+		types:        words(`int float str bool list dict set tuple bytes object`),
+		constants:    words(`True False None self cls`),
+		lineComments: []string{"#"},
+		quotes:       `"'`,
+		multiline:    []string{`"""`, `'''`},
+	},
+	"javascript": {
+		keywords: words(`break case catch class const continue debugger default delete do else export extends
+			finally for from function if import in instanceof let new of return static super switch throw try
+			typeof var void while with yield async await interface type enum implements private public protected
+			readonly as`),
+		types:        words(`string number boolean any unknown never object bigint symbol`),
+		constants:    words(`true false null undefined this NaN Infinity`),
+		lineComments: []string{"//"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       `"'`,
+		multiline:    []string{"`"},
+	},
+	"rust": {
+		keywords: words(`as async await break const continue crate dyn else enum extern fn for if impl in let
+			loop match mod move mut pub ref return static struct super trait type unsafe use where while`),
+		types: words(`i8 i16 i32 i64 i128 isize u8 u16 u32 u64 u128 usize f32 f64 bool char str String Vec
+			Option Result Box Self`),
+		constants:    words(`true false None Some Ok Err self`),
+		lineComments: []string{"//"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       `"'`,
+		charQuote:    true,
+	},
+	"c": cLike,
+	"shell": {
+		keywords:     words(`if then else elif fi for while until do done case esac function in return export local readonly`),
+		constants:    words(`true false`),
+		lineComments: []string{"#"},
+		quotes:       `"'`,
+	},
+	"json": {
+		constants: words(`true false null`),
+		quotes:    `"`,
+	},
+	"yaml": {
+		constants:    words(`true false null yes no on off`),
+		lineComments: []string{"#"},
+		quotes:       `"'`,
+	},
+	"sql": {
+		keywords: words(`select from where insert into values update set delete create table drop alter join
+			left right inner outer on group by order having limit offset as and or not is in like between
+			distinct union all case when then else end primary key references index`),
+		types:        words(`int integer bigint text varchar char boolean date timestamp real float numeric`),
+		constants:    words(`null true false`),
+		lineComments: []string{"--"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       `'"`,
+		ignoreCase:   true,
+	},
+}
+
+// languageAliases maps the names used in fence info strings to languages.
+var languageAliases = map[string]string{
+	"golang": "go", "py": "python", "python3": "python",
+	"js": "javascript", "jsx": "javascript", "mjs": "javascript", "ts": "javascript", "tsx": "javascript", "typescript": "javascript",
+	"rs": "rust", "h": "c", "cpp": "c", "c++": "c", "cc": "c", "hpp": "c", "java": "c", "cs": "c", "csharp": "c",
+	"kotlin": "c", "kt": "c", "swift": "c",
+	"sh": "shell", "bash": "shell", "zsh": "shell", "console": "shell",
+	"jsonc": "json", "yml": "yaml", "toml": "yaml", "ini": "yaml",
+}
+
+// languageFor picks the language named by a fence's info string, which may
+// be a language ("go"), a file ("main.go") or both ("go main.go").
+func languageFor(info string) *language {
+	fields := strings.Fields(strings.ToLower(info))
+	if len(fields) == 0 {
+		return nil
+	}
+	name := fields[0]
+	if strings.Contains(name, ".") {
+		name = fileType(name)
+	}
+	if alias, ok := languageAliases[name]; ok {
+		name = alias
+	}
+	return languages[name]
+}
+
+// highlightCode colours code line by line. Comments and strings may span
+// lines; without a language the code is left plain.
+func highlightCode(code string, lang *language) []string {
+	lines := strings.Split(code, "\n")
+	if lang == nil {
+		return lines
+	}
+	open := "" // the delimiter closing a comment or string left open
+	openStyle := ""
+	for i, line := range lines {
+		var b strings.Builder
+		emit := func(text, class string) {
+			if text == "" {
+				return
+			}
+			if style, ok := SyntaxStyles[class]; ok {
+				b.WriteString(style.Render(text))
+			} else {
+				b.WriteString(text)
+			}
+		}
+		j := 0
+		if open != "" {
+			k := strings.Index(line, open)
+			if k < 0 {
+				emit(line, openStyle)
+				lines[i] = b.String()
+				continue
+			}
+			j = k + len(open)
+			emit(line[:j], openStyle)
+			open = ""
+		}
+
+		plain := j
+		flush := func(to int) {
+			emit(line[plain:to], "")
+		}
+		for j < len(line) {
+			rest := line[j:]
+			c := line[j]
+			start := j
+			class := ""
+
+			switch {
+			case hasAnyPrefix(rest, lang.lineComments):
+				j = len(line)
+				class = "comment"
+			case lang.blockComment[0] != "" && strings.HasPrefix(rest, lang.blockComment[0]):
+				end := strings.Index(rest[len(lang.blockComment[0]):], lang.blockComment[1])
+				if end < 0 {
+					open, openStyle = lang.blockComment[1], "comment"
+					j = len(line)
+				} else {
+					j += len(lang.blockComment[0]) + end + len(lang.blockComment[1])
+				}
+				class = "comment"
+			case prefixIn(rest, lang.multiline) != "":
+				delim := prefixIn(rest, lang.multiline)
+				end := strings.Index(rest[len(delim):], delim)
+				if end < 0 {
+					open, openStyle = delim, "string"
+					j = len(line)
+				} else {
+					j += len(delim) + end + len(delim)
+				}
+				class = "string"
+			case strings.IndexByte(lang.quotes, c) >= 0:
+				end, ok := closingQuote(rest)
+				if c == '\'' && lang.charQuote && (!ok || end > 4) {
+					j++
+					continue
+				}
+				j += end
+				class = "string"
+			case isDigit(c) && (j == 0 || !isIdent(line[j-1])):
+				for j < len(line) && (isIdent(line[j]) || line[j] == '.') {
+					j++
+				}
+				class = "number"
+			case isIdentStart(c):
+				for j < len(line) && isIdent(line[j]) {
+					j++
+				}
+				class = wordClass(lang, line[start:j], strings.HasPrefix(strings.TrimLeft(line[j:], " "), "("))
+			case strings.IndexByte("+-*/%=<>!&|^~?:", c) >= 0:
+				j++
+				class = "operator"
+			case strings.IndexByte("(){}[];,.", c) >= 0:
+				j++
+				class = "punctuation"
+			default:
+				j++
+				continue
+			}
+			flush(start)
+			emit(line[start:j], class)
+			plain = j
+		}
+		flush(len(line))
+		lines[i] = b.String()
+	}
+	return lines
+}
+
+// wordClass classifies an identifier; call is set when a ( follows it.
+func wordClass(lang *language, word string, call bool) string {
+	key := word
+	if lang.ignoreCase {
+		key = strings.ToLower(word)
+	}
+	switch {
+	case lang.keywords[key]:
+		return "keyword"
+	case lang.constants[key]:
+		return "constant"
+	case lang.types[key]:
+		return "type"
+	case call:
+		return "function"
+	}
+	return ""
+}
+
+// closingQuote returns the length of the quoted string at the start of s,
+// reporting false if it runs to the end of the line unclosed.
+func closingQuote(s string) (int, bool) {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case s[0]:
+			return i + 1, true
+		}
+	}
+	return len(s), false
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	return prefixIn(s, prefixes) != ""
+}
+
+// prefixIn returns the first of prefixes that s starts with.
+func prefixIn(s string, prefixes []string) string {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return p
+		}
+	}
+	return ""
+}
+
+func isDigit(c byte) bool { return '0' <= c && c <= '9' }
+func isIdentStart(c byte) bool {
+	return c == '_' || c == '$' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+func isIdent(c byte) bool { return isIdentStart(c) || isDigit(c) }
@@ -0,0 +1,356 @@
+package tui
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/CiaranMccarthy1/boba-text/pkg/chats"
+	"github.com/CiaranMccarthy1/boba-text/pkg/llm"
+	"github.com/CiaranMccarthy1/boba-text/pkg/tools"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// agentHistoryMsg opens the chat history in the agent pane (:AgentHistory).
+type agentHistoryMsg struct{}
+
+// agentExportMsg asks for the conversation to be written out as markdown
+// (:AgentExport). Force overwrites an existing file.
+type agentExportMsg struct {
+	Path  string
+	Force bool
+}
+
+// historyBrowser lists the project's saved chats in the agent pane. The
+// input searches them, or holds the new title while one is renamed.
+type historyBrowser struct {
+	store   chats.Store
+	chats   []chats.Chat
+	matches []int
+	// selected indexes matches.
+	selected int
+	renaming bool
+	deleting bool
+	// query is the search put aside while renaming; draft and placeholder
+	// are the input's own, given back when the browser closes.
+	query       string
+	draft       string
+	placeholder string
+	err         error
+}
+
+// saveChat writes the conversation to the project's chat history, starting
+// a new saved chat if this one has not been saved yet.
+func (m *AgentModel) saveChat() {
+	if !m.config.SaveChats || len(m.conv.Turns) == 0 {
+		return
+	}
+	store, err := chats.ForProject(m.root)
+	if err == nil {
+		c := m.chat
+		c.Provider, c.Model = m.config.Provider, m.config.Model
+		c.Conversation = m.conv.Clone()
+		err = store.Save(&c)
+		c.Conversation = llm.Conversation{}
+		m.chat = c
+	}
+	if err != nil {
+		m.messages = append(m.messages, m.errorStyle.Render("Warning: ")+"could not save chat: "+err.Error())
+	}
+}
+
+// openHistory shows the saved chats in place of the conversation.
+func (m *AgentModel) openHistory() error {
+	switch {
+	case m.waiting:
+		return errors.New("Wait for the reply to finish before opening the chat history")
+	case m.pendingRewrite != nil:
+		return errors.New("Finish reviewing the proposed change before opening the chat history")
+	}
+	if m.history != nil {
+		return nil
+	}
+	store, err := chats.ForProject(m.root)
+	if err != nil {
+		return err
+	}
+	h := &historyBrowser{store: store, draft: m.textarea.Value(), placeholder: m.textarea.Placeholder}
+	h.chats, h.err = store.List()
+	m.history = h
+	m.suggestions = nil
+	m.textarea.Reset()
+	m.textarea.Placeholder = "Search chats..."
+	m.filterHistory()
+	return nil
+}
+
+// closeHistory goes back to the conversation.
+func (m *AgentModel) closeHistory() {
+	h := m.history
+	if h == nil {
+		return
+	}
+	m.history = nil
+	m.textarea.Placeholder = h.placeholder
+	m.textarea.SetValue(h.draft)
+	if len(m.messages) == 0 {
+		m.viewport.SetContent(m.welcome)
+		m.viewport.GotoTop()
+		return
+	}
+	m.viewport.SetContent(strings.Join(m.messages, "\n\n"))
+	m.viewport.GotoBottom()
+}
+
+// filterHistory keeps the chats matching the search and redraws the list.
+func (m *AgentModel) filterHistory() {
+	h := m.history
+	h.matches = h.matches[:0]
+	for i, c := range h.chats {
+		if c.Matches(m.textarea.Value()) {
+			h.matches = append(h.matches, i)
+		}
+	}
+	h.selected = max(0, min(h.selected, len(h.matches)-1))
+	m.showHistory()
+}
+
+// current returns the highlighted chat.
+func (h *historyBrowser) current() (*chats.Chat, bool) {
+	if h.selected >= len(h.matches) {
+		return nil, false
+	}
+	return &h.chats[h.matches[h.selected]], true
+}
+
+// showHistory draws the list, keeping the highlighted chat in view.
+func (m *AgentModel) showHistory() {
+	h := m.history
+	lines := []string{StyleBold.Render(fmt.Sprintf("Chat history (%d)", len(h.chats)))}
+	if h.err != nil {
+		lines = append(lines, m.errorStyle.Render("Error: ")+h.err.Error())
+	}
+	switch {
+	case len(h.chats) == 0:
+		lines = append(lines, StyleDim.Render("No saved chats for this project yet."))
+	case len(h.matches) == 0:
+		lines = append(lines, StyleDim.Render("No chats match the search."))
+	}
+	top := len(lines)
+	for i, idx := range h.matches {
+		c := h.chats[idx]
+		marker := "  "
+		if i == h.selected {
+			marker = m.senderStyle.Render("> ")
+		}
+		title := c.Title
+		if i == h.selected {
+			title = StyleBold.Render(title)
+		}
+		info := fmt.Sprintf(" · %s · %d prompt(s)", c.Updated.Format("2006-01-02 15:04"), prompts(c.Conversation))
+		if c.ID == m.chat.ID {
+			info += " · open"
+		}
+		lines = append(lines, marker+title+StyleDim.Render(info))
+	}
+	m.viewport.SetContent(strings.Join(lines, "\n"))
+
+	line := top + h.selected
+	if h.selected == 0 {
+		m.viewport.GotoTop()
+	} else if line < m.viewport.YOffset {
+		m.viewport.SetYOffset(line)
+	} else if line >= m.viewport.YOffset+m.viewport.Height {
+		m.viewport.SetYOffset(line - m.viewport.Height + 1)
+	}
+}
+
+// historyStatus is the status line while the history browser is open.
+func (m AgentModel) historyStatus(h *historyBrowser) string {
+	c, ok := h.current()
+	switch {
+	case h.deleting && ok:
+		return StyleModeCommand.Render(" DELETE ") + " " + c.Title + "?" + StyleDim.Render("  [y]es [n]o")
+	case h.renaming:
+		return StyleModeInsert.Render(" RENAME ") + " " +
+			StyleDim.Render(m.keys.AgentSend+" to save  "+m.keys.AgentCancel+" to cancel")
+	}
+	return StyleModeCommand.Render(" HISTORY ") + " " +
+		StyleDim.Render("↑/↓ select  "+m.keys.AgentSend+" open  ctrl+r rename  ctrl+d delete  "+m.keys.AgentCancel+" close")
+}
+
+// prompts counts the user's prompts in a conversation.
+func prompts(conv llm.Conversation) int {
+	n := 0
+	for _, t := range conv.Turns {
+		if t.Role == llm.RoleUser {
+			n++
+		}
+	}
+	return n
+}
+
+// handleHistoryKey drives the history browser: up and down pick a chat,
+// enter reopens it, ctrl+r renames it, ctrl+d deletes it and anything else
+// edits the search.
+func (m *AgentModel) handleHistoryKey(msg tea.KeyMsg) tea.Cmd {
+	h := m.history
+	key := msg.String()
+
+	if h.deleting {
+		h.deleting = false
+		if key == "y" || key == "Y" {
+			m.deleteChat()
+		}
+		m.showHistory()
+		return nil
+	}
+
+	switch key {
+	case m.keys.AgentCancel:
+		if h.renaming {
+			h.renaming = false
+			m.textarea.SetValue(h.query)
+			m.showHistory()
+			return nil
+		}
+		m.closeHistory()
+	case "up", "ctrl+p":
+		h.selected = max(h.selected-1, 0)
+		m.showHistory()
+	case "down", "ctrl+n":
+		h.selected = max(0, min(h.selected+1, len(h.matches)-1))
+		m.showHistory()
+	case m.keys.AgentSend:
+		if h.renaming {
+			m.renameChat()
+			return nil
+		}
+		if c, ok := h.current(); ok {
+			chat := *c
+			m.closeHistory()
+			m.loadChat(chat)
+		}
+	case "ctrl+r":
+		if c, ok := h.current(); ok && !h.renaming {
+			h.renaming = true
+			h.query = m.textarea.Value()
+			m.textarea.SetValue(c.Title)
+		}
+	case "ctrl+d":
+		if _, ok := h.current(); ok && !h.renaming {
+			h.deleting = true
+		}
+	default:
+		var cmd tea.Cmd
+		m.textarea, cmd = m.textarea.Update(msg)
+		if !h.renaming {
+			m.filterHistory()
+		}
+		return cmd
+	}
+	return nil
+}
+
+// renameChat gives the highlighted chat the title typed in the input.
+func (m *AgentModel) renameChat() {
+	h := m.history
+	c, _ := h.current()
+	title := strings.TrimSpace(m.textarea.Value())
+	if h.err = h.store.Rename(c.ID, title); h.err == nil {
+		c.Title = title
+		if c.ID == m.chat.ID {
+			m.chat.Title = title
+		}
+	}
+	h.renaming = false
+	m.textarea.SetValue(h.query)
+	m.filterHistory()
+}
+
+// deleteChat removes the highlighted chat. If it is the one open, the
+// conversation stays but will be saved as a new chat.
+func (m *AgentModel) deleteChat() {
+	h := m.history
+	c, _ := h.current()
+	if h.err = h.store.Delete(c.ID); h.err != nil {
+		return
+	}
+	if c.ID == m.chat.ID {
+		m.chat = chats.Chat{}
+	}
+	idx := h.matches[h.selected]
+	h.chats = append(h.chats[:idx], h.chats[idx+1:]...)
+	m.filterHistory()
+}
+
+// loadChat replaces the conversation with a saved one and shows it as it
+// was: prompts with their attachments, replies, tool calls and results.
+func (m *AgentModel) loadChat(c chats.Chat) {
+	m.conv = c.Conversation
+	c.Conversation = llm.Conversation{}
+	m.chat = c
+	m.messages = []string{StyleDim.Render("── " + c.Title + " · " + c.Updated.Format("2006-01-02 15:04") + " ──")}
+	m.snippets = nil
+	m.rewrites = nil
+	if m.conv.Summary != "" {
+		m.messages = append(m.messages, StyleDim.Render("(earlier turns were summarised to stay within the history budget)"))
+	}
+	for _, t := range m.conv.Turns {
+		switch t.Role {
+		case llm.RoleUser:
+			msg := m.senderStyle.Render("You: ") + t.Text
+			for _, a := range t.Attachments {
+				msg += "\n" + StyleDim.Render(attachmentLabel(a))
+			}
+			m.messages = append(m.messages, msg)
+		case llm.RoleAssistant:
+			if t.Text != "" {
+				m.messages = append(m.messages, m.renderReply(t.Text, true))
+			}
+			for _, call := range t.ToolCalls {
+				m.messages = append(m.messages, StyleBold.Render("⚙ ")+StyleDim.Render(tools.Describe(call)))
+			}
+		case llm.RoleTool:
+			m.messages = append(m.messages, m.renderToolResult(t.Text))
+		}
+	}
+	m.countTokens()
+	m.viewport.SetContent(strings.Join(m.messages, "\n\n"))
+	m.viewport.GotoBottom()
+}
+
+// export writes the conversation as markdown, by default to a new file in
+// the project root, and returns where it went.
+func (m AgentModel) export(path string, force bool) (string, error) {
+	if len(m.conv.Turns) == 0 {
+		return "", errors.New("Nothing to export: the conversation is empty")
+	}
+	if path == "" {
+		path = "chat-" + time.Now().Format("20060102-150405") + ".md"
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(m.root, path)
+	}
+	if _, err := os.Stat(path); err == nil && !force {
+		return "", fmt.Errorf("%s exists (add ! to override)", relPath(m.root, path))
+	}
+
+	c := m.chat
+	c.Conversation = m.conv
+	c.Provider, c.Model = m.config.Provider, m.config.Model
+	c.Project, _ = filepath.Abs(m.root)
+	if c.Title == "" {
+		c.Title = chats.DefaultTitle(m.conv)
+	}
+	if c.Created.IsZero() {
+		c.Created = time.Now()
+	}
+	if err := os.WriteFile(path, []byte(chats.Markdown(c)), 0644); err != nil {
+		return "", err
+	}
+	return relPath(m.root, path), nil
+}
@@ -0,0 +1,141 @@
+package tui
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+var (
+	headingPattern = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+	bulletPattern  = regexp.MustCompile(`^(\s*)[-*+]\s+(.*)$`)
+	orderedPattern = regexp.MustCompile(`^(\s*)(\d+[.)])\s+(.*)$`)
+	quotePattern   = regexp.MustCompile(`^\s*>\s?(.*)$`)
+	rulePattern    = regexp.MustCompile(`^\s*([-*_])(\s*[-*_]){2,}\s*$`)
+	linkPattern    = regexp.MustCompile(`^\[([^\]]+)\]\(([^)\s]+)\)`)
+)
+
+// renderMarkdown styles a reply for the agent pane: headings, lists, quotes,
+// rules and inline emphasis, code and links. Fenced code blocks are syntax
+// coloured under a header numbering them from first; their bodies are
+// returned in order, including one still open while a reply streams in.
+func renderMarkdown(text string, first int) (string, []string) {
+	var out, body []string
+	var blocks []string
+	var lang *language
+	fence := ""
+
+	closeBlock := func() {
+		code := strings.Join(body, "\n")
+		blocks = append(blocks, code)
+		for _, line := range highlightCode(code, lang) {
+			out = append(out, StyleDim.Render("│ ")+line)
+		}
+	}
+
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if fence != "" {
+			if strings.HasPrefix(trimmed, fence) && strings.TrimLeft(trimmed, fence[:1]) == "" {
+				closeBlock()
+				out = append(out, StyleDim.Render("└"))
+				fence = ""
+				continue
+			}
+			body = append(body, line)
+			continue
+		}
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			n := len(trimmed) - len(strings.TrimLeft(trimmed, trimmed[:1]))
+			fence = trimmed[:n]
+			info := strings.TrimSpace(trimmed[n:])
+			lang, body = languageFor(info), nil
+			header := StyleBold.Render(fmt.Sprintf("┌ [%d]", first+len(blocks)))
+			if info != "" {
+				header += StyleDim.Render(" " + info)
+			}
+			out = append(out, header)
+			continue
+		}
+		out = append(out, renderMarkdownLine(line))
+	}
+	if fence != "" {
+		closeBlock()
+	}
+	return strings.Join(out, "\n"), blocks
+}
+
+// renderMarkdownLine styles one line outside a code block.
+func renderMarkdownLine(line string) string {
+	if m := headingPattern.FindStringSubmatch(line); m != nil {
+		return SyntaxStyles["heading"].Render(m[2])
+	}
+	if rulePattern.MatchString(line) {
+		return StyleDim.Render(strings.Repeat("─", 24))
+	}
+	if m := quotePattern.FindStringSubmatch(line); m != nil {
+		return StyleDim.Render("│ ") + lipgloss.NewStyle().Italic(true).Render(renderInline(m[1]))
+	}
+	if m := bulletPattern.FindStringSubmatch(line); m != nil {
+		return m[1] + StyleDim.Render("• ") + renderInline(m[2])
+	}
+	if m := orderedPattern.FindStringSubmatch(line); m != nil {
+		return m[1] + StyleDim.Render(m[2]) + " " + renderInline(m[3])
+	}
+	return renderInline(line)
+}
+
+// renderInline styles `code`, **bold**, *italic* and [links](url). An _ only
+// starts emphasis at the start of a word, so snake_case names are left alone.
+func renderInline(s string) string {
+	var b strings.Builder
+	italic := lipgloss.NewStyle().Italic(true)
+	plain := 0
+	for i := 0; i < len(s); {
+		rest := s[i:]
+		styled, n := "", 0
+		switch {
+		case rest[0] == '`':
+			ticks := len(rest) - len(strings.TrimLeft(rest, "`"))
+			if end := strings.Index(rest[ticks:], rest[:ticks]); end >= 0 {
+				styled = SyntaxStyles["string"].Render(rest[ticks : ticks+end])
+				n = ticks + end + ticks
+			}
+		case strings.HasPrefix(rest, "**") || strings.HasPrefix(rest, "__"):
+			if end := strings.Index(rest[2:], rest[:2]); end > 0 && wordStart(s, i) {
+				styled = StyleBold.Render(renderInline(rest[2 : 2+end]))
+				n = end + 4
+			}
+		case rest[0] == '*' || rest[0] == '_':
+			if end := strings.IndexByte(rest[1:], rest[0]); end > 0 && rest[1] != ' ' && wordStart(s, i) {
+				styled = italic.Render(rest[1 : 1+end])
+				n = end + 2
+			}
+		case rest[0] == '[':
+			if m := linkPattern.FindStringSubmatch(rest); m != nil {
+				styled = SyntaxStyles["link"].Render(m[1])
+				if m[2] != m[1] {
+					styled += StyleDim.Render(" (" + m[2] + ")")
+				}
+				n = len(m[0])
+			}
+		}
+		if n == 0 {
+			i++
+			continue
+		}
+		b.WriteString(s[plain:i])
+		b.WriteString(styled)
+		i += n
+		plain = i
+	}
+	b.WriteString(s[plain:])
+	return b.String()
+}
+
+// wordStart reports whether position i of s is not inside a word.
+func wordStart(s string, i int) bool {
+	return i == 0 || !isIdent(s[i-1])
+}
@@ -0,0 +1,179 @@
+package tui
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
+)
+
+// mark is a styled run of a buffer line, in runes.
+type mark struct {
+	start, end int
+	style      lipgloss.Style
+}
+
+// marks lists the highlighted runs of each buffer row: the visual selection
+// and, until :noh, the matches of the last search.
+func (m EditorModel) marks(lines []string) map[int][]mark {
+	marks := map[int][]mark{}
+	if m.hlSearch && m.searchQuery != "" {
+		for row, line := range lines {
+			for i := 0; ; {
+				idx := strings.Index(line[i:], m.searchQuery)
+				if idx < 0 {
+					break
+				}
+				start := byteIndexToRuneIndex(line, i+idx)
+				end := start + len([]rune(m.searchQuery))
+				marks[row] = append(marks[row], mark{start, end, StyleSearch})
+				i += idx + max(len(m.searchQuery), 1)
+			}
+		}
+	}
+	if m.mode == ModeVisual {
+		startRow, startCol, endRow, endCol := m.selection()
+		for row := startRow; row <= min(endRow, len(lines)-1); row++ {
+			n := len([]rune(lines[row]))
+			from, to := 0, n
+			if !m.lineVisual {
+				if row == startRow {
+					from = startCol
+				}
+				if row == endRow {
+					to = min(endCol+1, n)
+				}
+			}
+			// The selection is drawn over search matches.
+			var kept []mark
+			for _, k := range marks[row] {
+				if k.end <= from || k.start >= to {
+					kept = append(kept, k)
+				}
+			}
+			marks[row] = append(kept, mark{from, to, StyleSelection})
+		}
+	}
+	return marks
+}
+
+// highlight draws marks over the textarea's view. The view is read back line
+// by line: a line number starts each buffer row, and rows too long for the
+// pane continue on unnumbered lines below. The cursor cell is left as the
+// textarea drew it.
+func (m EditorModel) highlight(view string) string {
+	lines := strings.Split(m.textarea.Value(), "\n")
+	marks := m.marks(lines)
+	if len(marks) == 0 {
+		return view
+	}
+
+	prompt := lipgloss.Width(m.textarea.Prompt)
+	digits := len(strconv.Itoa(m.textarea.MaxHeight))
+	cursorRow, info := m.textarea.Line(), m.textarea.LineInfo()
+
+	out := strings.Split(view, "\n")
+	row, segment, offset := -1, 0, 0
+	for i, line := range out {
+		plain := []rune(ansi.Strip(line))
+		if len(plain) < prompt {
+			continue
+		}
+		rest := plain[prompt:]
+		gutter := len(fmt.Sprintf(" %*v ", digits, " "))
+		if n, w, ok := lineNumber(rest, digits); ok && n-1 < len(lines) {
+			row, segment, offset, gutter = n-1, 0, 0, w
+		} else if row < 0 || offset >= len([]rune(lines[row])) {
+			// Past the end of the buffer, or continuing a row scrolled off
+			// the top.
+			row = -1
+			continue
+		} else {
+			segment++
+		}
+		if gutter > len(rest) {
+			continue
+		}
+		text := []rune(lines[row])
+		shown := strings.TrimRight(string(rest[gutter:]), " ")
+		segStart := offset
+		offset += len([]rune(shown))
+		for offset < len(text) && text[offset] == ' ' {
+			offset++
+		}
+		cursor := -1
+		if row == cursorRow && segment == info.RowOffset {
+			cursor = left(prompt+gutter, text[segStart:min(segStart+info.ColumnOffset, len(text))])
+		}
+		var runs []cellRun
+		for _, k := range marks[row] {
+			from, to := max(k.start, segStart), min(k.end, offset)
+			if from >= to {
+				continue
+			}
+			a := left(prompt+gutter, text[segStart:from])
+			b := a + ansi.StringWidth(string(text[from:to]))
+			if cursor >= a && cursor < b {
+				runs = append(runs, cellRun{a, cursor, k.style}, cellRun{cursor + 1, b, k.style})
+			} else {
+				runs = append(runs, cellRun{a, b, k.style})
+			}
+		}
+		if len(runs) > 0 {
+			out[i] = overlay(line, runs)
+		}
+	}
+	return strings.Join(out, "\n")
+}
+
+// lineNumber reads the line number at the start of a view line, returning
+// it with the width of the gutter. Numbers are right-aligned to digits with
+// one space before, so text on a continuation line, which starts after a
+// blank gutter, is never taken for one.
+func lineNumber(rest []rune, digits int) (int, int, bool) {
+	i := 0
+	for i < len(rest) && rest[i] == ' ' {
+		i++
+	}
+	j := i
+	for j < len(rest) && unicode.IsDigit(rest[j]) {
+		j++
+	}
+	if j == i || j >= len(rest) || rest[j] != ' ' || i != 1+max(digits-(j-i), 0) {
+		return 0, 0, false
+	}
+	n, err := strconv.Atoi(string(rest[i:j]))
+	return n, j + 1, err == nil
+}
+
+// left is the cell a buffer segment's text starts at after its prefix.
+func left(gutter int, prefix []rune) int {
+	return gutter + ansi.StringWidth(string(prefix))
+}
+
+// cellRun is a range of screen cells to draw in a style.
+type cellRun struct {
+	a, b  int
+	style lipgloss.Style
+}
+
+// overlay restyles ranges of a rendered line, keeping the rest as it was.
+func overlay(line string, runs []cellRun) string {
+	sort.Slice(runs, func(i, j int) bool { return runs[i].a < runs[j].a })
+	var b strings.Builder
+	pos := 0
+	for _, r := range runs {
+		if r.a >= r.b || r.a < pos {
+			continue
+		}
+		b.WriteString(ansi.Cut(line, pos, r.a))
+		b.WriteString(r.style.Render(ansi.Strip(ansi.Cut(line, r.a, r.b))))
+		pos = r.b
+	}
+	b.WriteString(ansi.Cut(line, pos, ansi.StringWidth(line)))
+	return b.String()
+}
@@ -0,0 +1,376 @@
+package tui
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/CiaranMccarthy1/boba-text/pkg/fileio"
+	"github.com/CiaranMccarthy1/boba-text/pkg/llm"
+	"github.com/CiaranMccarthy1/boba-text/pkg/tools"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Limits on what @-mentions attach to a prompt.
+const (
+	maxMentionFile     = 64 * 1024
+	maxMentionTotal    = 256 * 1024
+	maxMentionDirFiles = 40
+
+	// maxProjectFiles caps the paths offered for completion.
+	maxProjectFiles = 5000
+	// mentionRefresh is how long the completion list is reused before the
+	// project is walked again.
+	mentionRefresh = 10 * time.Second
+	maxSuggestions = 5
+)
+
+// bufferMention is the @-mention for the live editor buffer.
+const bufferMention = "buffer"
+
+// mentionPattern finds @mentions: an @ at the start of the input or after
+// whitespace, so e-mail addresses are left alone.
+var mentionPattern = regexp.MustCompile(`(?:^|\s)@(\S+)`)
+
+// projectFiles lists the project's files and directories (with a trailing
+// slash) relative to root, in the order the file tree shows them.
+func projectFiles(root string) []string {
+	var out []string
+	var walk func(dir, rel string)
+	walk = func(dir, rel string) {
+		for _, e := range readDirSorted(dir) {
+			if len(out) >= maxProjectFiles {
+				return
+			}
+			name := rel + e.Name()
+			if e.IsDir() {
+				if tools.SkipDir(e.Name()) {
+					continue
+				}
+				out = append(out, name+"/")
+				walk(filepath.Join(dir, e.Name()), name+"/")
+				continue
+			}
+			out = append(out, name)
+		}
+	}
+	walk(root, "")
+	return out
+}
+
+// fuzzyScore matches query against candidate as a subsequence, scoring runs
+// of consecutive characters and matches at the start of a path segment or
+// word higher. Shorter candidates win ties.
+func fuzzyScore(query, candidate string) (int, bool) {
+	q, c := strings.ToLower(query), strings.ToLower(candidate)
+	score, qi, prev := 0, 0, -2
+	for ci := 0; ci < len(c) && qi < len(q); ci++ {
+		if c[ci] != q[qi] {
+			continue
+		}
+		score++
+		if ci == prev+1 {
+			score += 3
+		}
+		if ci == 0 || strings.IndexByte("/_-. ", c[ci-1]) >= 0 {
+			score += 2
+		}
+		prev = ci
+		qi++
+	}
+	if qi < len(q) {
+		return 0, false
+	}
+	return score*8 - len(c), true
+}
+
+// suggestMentions returns the best completions for query.
+func suggestMentions(query string, files []string) []string {
+	type match struct {
+		path  string
+		score int
+	}
+	var matches []match
+	for _, f := range append([]string{bufferMention}, files...) {
+		if score, ok := fuzzyScore(query, f); ok {
+			matches = append(matches, match{f, score})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].score > matches[j].score })
+	var out []string
+	for _, m := range matches[:min(len(matches), maxSuggestions)] {
+		out = append(out, m.path)
+	}
+	return out
+}
+
+// mentionAt finds the @mention being typed at col of line, returning the
+// rune offsets of its @ and end, and the text after the @.
+func mentionAt(line string, col int) (start, end int, query string, ok bool) {
+	runes := []rune(line)
+	col = min(col, len(runes))
+	start = col
+	for start > 0 && runes[start-1] != ' ' && runes[start-1] != '\t' {
+		start--
+	}
+	end = col
+	for end < len(runes) && runes[end] != ' ' && runes[end] != '\t' {
+		end++
+	}
+	if start == end || runes[start] != '@' {
+		return 0, 0, "", false
+	}
+	return start, end, string(runes[start+1 : end]), true
+}
+
+// mentions lists the distinct @mentions in a prompt.
+func mentions(input string) []string {
+	var out []string
+	seen := map[string]bool{}
+	for _, m := range mentionPattern.FindAllStringSubmatch(input, -1) {
+		name := strings.TrimRight(m[1], ".,;:!?)")
+		if name != "" && !seen[name] {
+			seen[name] = true
+			out = append(out, name)
+		}
+	}
+	return out
+}
+
+// resolveMentions reads the files, directories and buffer mentioned in a
+// prompt, within the size limits. It returns the attachments and one line per
+// mention describing what was attached or why not.
+func resolveMentions(input, root string, buffer *llm.Attachment) ([]llm.Attachment, []string) {
+	var attachments []llm.Attachment
+	var notes []string
+	total := 0
+	ws := tools.Workspace{Root: root}
+
+	// room is how much of the next file may be attached.
+	room := func() int { return min(maxMentionFile, maxMentionTotal-total) }
+
+	// add attaches a file's text, size bytes in full, truncating it to the
+	// per-file limit and refusing it once the total is used up.
+	add := func(name, text string, size int) (int, bool) {
+		if total >= maxMentionTotal {
+			return 0, false
+		}
+		a := llm.Attachment{Name: name, Content: text, Mentioned: true}
+		limit := room()
+		if len(text) > limit || size > limit {
+			a.Content = fileio.TruncateUTF8(text, limit)
+			a.Label = fmt.Sprintf("first %d KB of %d KB", limit/1024, (max(size, len(text))+1023)/1024)
+		}
+		total += len(a.Content)
+		attachments = append(attachments, a)
+		return len(a.Content), true
+	}
+
+	for _, name := range mentions(input) {
+		if name == bufferMention {
+			if buffer == nil {
+				notes = append(notes, "⚠ @buffer: no file is open")
+				continue
+			}
+			if _, ok := add(buffer.Name, buffer.Content, len(buffer.Content)); !ok {
+				notes = append(notes, "⚠ @buffer: size limit reached")
+				continue
+			}
+			attachments[len(attachments)-1].Mentioned = false
+			notes = append(notes, attachmentLabel(attachments[len(attachments)-1]))
+			continue
+		}
+
+		path, err := ws.Resolve(filepath.FromSlash(name))
+		if err != nil {
+			notes = append(notes, "⚠ @"+name+": "+err.Error())
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			notes = append(notes, "⚠ @"+name+": not found")
+			continue
+		}
+
+		if !info.IsDir() {
+			if total >= maxMentionTotal {
+				notes = append(notes, "⚠ @"+name+": size limit reached")
+				continue
+			}
+			text, size, err := mentionText(path, buffer, room())
+			if err != nil {
+				notes = append(notes, "⚠ @"+name+": "+err.Error())
+				continue
+			}
+			add(path, text, size)
+			notes = append(notes, attachmentLabel(attachments[len(attachments)-1]))
+			continue
+		}
+
+		// A directory attaches its text files, shallowest first.
+		var entries []string
+		for _, rel := range projectFiles(path) {
+			if !strings.HasSuffix(rel, "/") {
+				entries = append(entries, rel)
+			}
+		}
+		sort.SliceStable(entries, func(i, j int) bool {
+			return strings.Count(entries[i], "/") < strings.Count(entries[j], "/")
+		})
+		files, size, skipped := 0, 0, 0
+		for i, rel := range entries {
+			if files == maxMentionDirFiles || total >= maxMentionTotal {
+				skipped = len(entries) - i
+				break
+			}
+			full := filepath.Join(path, filepath.FromSlash(rel))
+			text, fileSize, err := mentionText(full, buffer, room())
+			if err != nil {
+				continue
+			}
+			n, _ := add(full, text, fileSize)
+			files++
+			size += n
+		}
+		note := fmt.Sprintf("📎 %s/ · %d file(s), %d KB", strings.TrimSuffix(name, "/"), files, (size+1023)/1024)
+		if skipped > 0 {
+			note += fmt.Sprintf(" (%d more not attached: size limit)", skipped)
+		}
+		notes = append(notes, note)
+	}
+	return attachments, notes
+}
+
+// mentionText reads at most limit bytes of a mentioned file, preferring the
+// live buffer when it is the file being edited, and returns them with the
+// file's full size. Binary files are refused.
+func mentionText(path string, buffer *llm.Attachment, limit int) (string, int, error) {
+	if buffer != nil && samePath(buffer.Name, path) {
+		return buffer.Content, len(buffer.Content), nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", 0, err
+	}
+	data, err := io.ReadAll(io.LimitReader(f, int64(limit)+1))
+	if err != nil {
+		return "", 0, err
+	}
+	if bytes.IndexByte(data, 0) >= 0 {
+		return "", 0, fmt.Errorf("binary file")
+	}
+	size := max(int(info.Size()), len(data))
+	if len(data) > limit {
+		// Cut before decoding, so a character split by the limit does not
+		// make the text look like Latin-1.
+		data = []byte(fileio.TruncateUTF8(string(data), limit))
+	}
+	text, _, err := fileio.Decode(data)
+	return text, size, err
+}
+
+// updateSuggestions offers completions for a slash command being typed or the
+// @mention under the cursor.
+func (m *AgentModel) updateSuggestions() {
+	m.suggestions, m.suggestion = nil, 0
+	if m.suggestions = m.slashSuggestions(); m.suggestions != nil {
+		return
+	}
+	if m.root == "" {
+		return
+	}
+	lines := strings.Split(m.textarea.Value(), "\n")
+	row := m.textarea.Line()
+	if row >= len(lines) {
+		return
+	}
+	li := m.textarea.LineInfo()
+	_, _, query, ok := mentionAt(lines[row], li.StartColumn+li.ColumnOffset)
+	if !ok {
+		return
+	}
+	if time.Since(m.filesLoaded) > mentionRefresh {
+		m.projectFiles = projectFiles(m.root)
+		m.filesLoaded = time.Now()
+	}
+	for _, s := range suggestMentions(query, m.projectFiles) {
+		m.suggestions = append(m.suggestions, "@"+s)
+	}
+}
+
+// acceptSuggestion replaces the @mention under the cursor with the
+// highlighted completion. Completing a directory keeps completing inside it;
+// completing a slash command fills in the input.
+func (m *AgentModel) acceptSuggestion() {
+	choice := m.suggestions[m.suggestion]
+	if strings.HasPrefix(choice, "/") {
+		m.textarea.SetValue(choice + " ")
+		m.suggestions = nil
+		return
+	}
+	lines := strings.Split(m.textarea.Value(), "\n")
+	row := m.textarea.Line()
+	li := m.textarea.LineInfo()
+	start, end, _, ok := mentionAt(lines[row], li.StartColumn+li.ColumnOffset)
+	if !ok {
+		return
+	}
+	insert := choice
+	if !strings.HasSuffix(choice, "/") {
+		insert += " "
+	}
+	runes := []rune(lines[row])
+	lines[row] = string(runes[:start]) + insert + string(runes[end:])
+	m.textarea.SetValue(strings.Join(lines, "\n"))
+	for m.textarea.Line() > row {
+		m.textarea.CursorUp()
+	}
+	m.textarea.SetCursor(start + len([]rune(insert)))
+	m.updateSuggestions()
+}
+
+// handleSuggestionKey moves through and accepts completions, reporting
+// whether it used the key.
+func (m *AgentModel) handleSuggestionKey(msg tea.KeyMsg) bool {
+	switch msg.String() {
+	case "tab":
+		m.acceptSuggestion()
+	case "down", "ctrl+n":
+		m.suggestion = (m.suggestion + 1) % len(m.suggestions)
+	case "up", "ctrl+p":
+		m.suggestion = (m.suggestion + len(m.suggestions) - 1) % len(m.suggestions)
+	case "esc":
+		m.suggestions = nil
+	default:
+		return false
+	}
+	return true
+}
+
+// suggestionLine shows the completions in place of the context chip.
+func (m AgentModel) suggestionLine() string {
+	var b strings.Builder
+	for i, s := range m.suggestions {
+		if i == m.suggestion {
+			b.WriteString(StyleSelected.Render(" " + s + " "))
+		} else {
+			b.WriteString(StyleDim.Render(" " + s + " "))
+		}
+	}
+	if desc := m.commandDescription(m.suggestions[m.suggestion]); desc != "" {
+		b.WriteString(StyleDim.Render("  " + desc))
+	}
+	b.WriteString(StyleDim.Render("  tab to complete"))
+	return b.String()
+}
@@ -1,11 +1,15 @@
 package tui
 
 import (
-	"os"
+	"errors"
+	"fmt"
+	"io/fs"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/CiaranMccarthy1/boba-text/pkg/config"
+	"github.com/CiaranMccarthy1/boba-text/pkg/theme"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 )
@@ -29,25 +33,111 @@ type Model struct {
 	height      int
 	keys        config.Keys
 	startPath   string
+	args        []FileArg
+	argIndex    int
+	notices     []string
+	configInfo  config.Loaded
+	configOpts  config.LoadOptions
+	// configFiles holds config file mtimes for the live-reload watcher.
+	configFiles map[string]time.Time
+	// watcher reports changes to the open file; watching says whether it
+	// covers the file, which is otherwise polled. diskPending is a change
+	// that arrived in insert mode and is still to be checked.
+	watcher     *diskWatcher
+	watching    bool
+	diskPending bool
 }
 
-// InitialModel creates the initial application model with the given configuration.
-func InitialModel(startPath string, cfg config.Config) Model {
-	InitStyles(cfg.Colors)
-	return Model{
+// InitialModel creates the initial application model with the given configuration
+// and command-line startup options.
+func InitialModel(startPath string, cfg config.Config, opts StartupOptions) Model {
+	themeErr := InitStyles(cfg)
+	m := Model{
 		fileTree:    NewFileTree(startPath, cfg.Keys),
-		editor:      NewEditor(cfg.Commands, cfg.Keys),
+		editor:      NewEditor(cfg.Commands, cfg.Keys, cfg.Files),
 		agent:       NewAgent(cfg.AI, cfg.Keys),
 		focus:       FocusFileTree,
 		showTree:    true,
 		showWelcome: true,
 		keys:        cfg.Keys,
 		startPath:   startPath,
+		args:        opts.Files,
+		configInfo:  opts.Config,
+		configOpts:  opts.ConfigOptions,
+		configFiles: configStamps(opts.ConfigOptions),
+		watcher:     newDiskWatcher(),
 	}
+	m.editor.readOnly = opts.ReadOnly
+	m.agent.root = startPath
+	m.agent.countTokens()
+	for _, issue := range opts.Config.Issues {
+		m.notices = append(m.notices, issue.String())
+	}
+	if themeErr != nil {
+		m.notices = append(m.notices, themeErr.Error())
+	}
+	if len(m.notices) > 0 {
+		m.editor.msg = fmt.Sprintf("Config: %d problem(s), run boba-text --check-config", len(m.notices))
+	}
+
+	switch {
+	case opts.Stdin != nil:
+		m.editor.SetContent(*opts.Stdin, "")
+		m.editor.modified = *opts.Stdin != ""
+		m.showWelcome = false
+		m.focus = FocusEditor
+	case len(m.args) > 0:
+		m.openFile(m.args[0])
+	}
+	return m
+}
+
+// openFile loads a file into the editor, creating an empty buffer for paths
+// that do not exist yet, and places the cursor at the requested position.
+func (m *Model) openFile(arg FileArg) {
+	m.showWelcome = false
+	if err := m.editor.loadFile(arg.Path); err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			m.editor.msg = "Error reading: " + err.Error()
+			return
+		}
+		m.editor.SetContent("", arg.Path)
+		m.editor.msg = "[New File] " + arg.Path
+	}
+	if arg.Line > 0 {
+		m.editor.jumpToLine(arg.Line)
+		if arg.Col > 0 {
+			m.editor.textarea.SetCursor(arg.Col - 1)
+		}
+	}
+	m.agent.SetCurrentFile(arg.Path)
+	m.focus = FocusEditor
+	m.watching = m.watcher != nil && m.watcher.watch(arg.Path)
+	m.diskPending = false
+}
+
+// diskCheckMsg triggers a periodic check of the open file for external
+// changes, where filesystem notifications do not cover it.
+type diskCheckMsg struct{}
+
+func checkDiskCmd() tea.Cmd {
+	return tea.Tick(2*time.Second, func(time.Time) tea.Msg { return diskCheckMsg{} })
+}
+
+// swapTickMsg triggers journaling of unsaved changes to the swap file.
+type swapTickMsg struct{}
+
+func swapTickCmd() tea.Cmd {
+	return tea.Tick(4*time.Second, func(time.Time) tea.Msg { return swapTickMsg{} })
 }
 
 func (m Model) Init() tea.Cmd {
-	return tea.Batch(m.fileTree.Init(), m.editor.Init(), m.agent.Init())
+	cmds := []tea.Cmd{m.fileTree.Init(), m.editor.Init(), m.agent.Init(),
+		checkDiskCmd(), swapTickCmd(), configWatchCmd()}
+	if m.watcher != nil {
+		cmds = append(cmds, m.watcher.next())
+	}
+	return tea.Batch(cmds...)
 }
 
 func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
@@ -64,25 +154,27 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 		switch msg.String() {
 		case m.keys.Quit:
-			return m, tea.Quit
+			// In the agent pane the quit key first cancels a reply in progress.
+			if m.focus == FocusAgent && m.agent.waiting {
+				m.agent.Cancel()
+				return m, nil
+			}
+			m.agent.Cancel()
+			return m, m.editor.quit()
 		case m.keys.CycleFocus:
-			if m.focus == FocusEditor && m.editor.mode == ModeInsert {
+			// Insert mode types the key and command mode uses it for completion.
+			if m.focus == FocusEditor && m.editor.mode != ModeNormal {
+				break
+			}
+			// The agent uses it to complete @mentions.
+			if m.focus == FocusAgent && len(m.agent.suggestions) > 0 {
 				break
 			}
 			m.focus = (m.focus + 1) % 3
 			return m, nil
 
 		case m.keys.Save:
-			if m.editor.filename != "" {
-				err := os.WriteFile(m.editor.filename, []byte(m.editor.textarea.Value()), 0644)
-				if err != nil {
-					m.editor.msg = "Error saving: " + err.Error()
-				} else {
-					m.editor.msg = "Saved: " + m.editor.filename
-				}
-			} else {
-				m.editor.msg = "No filename set!"
-			}
+			m.editor.saveFile(false)
 			return m, nil
 
 		case m.keys.ToggleTree:
@@ -104,6 +196,8 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 		case m.keys.FocusAgent:
 			if m.focus == FocusAgent {
+				// Closing the agent abandons any reply in progress.
+				m.agent.Cancel()
 				m.focus = FocusEditor
 			} else {
 				m.focus = FocusAgent
@@ -117,11 +211,132 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	case OpenFileMsg:
 		m.showWelcome = false
-		content, err := os.ReadFile(msg.Path)
-		if err == nil {
-			m.editor.SetContent(string(content), msg.Path)
-			m.focus = FocusEditor
+		m.openFile(FileArg{Path: msg.Path, Line: msg.Line, Col: msg.Col})
+
+	case argMsg:
+		m.nextArg(msg)
+		return m, nil
+
+	case showConfigMsg:
+		m.editor.openPager(m.renderConfig())
+		return m, nil
+
+	case configWatchMsg:
+		cur := configStamps(m.configOpts)
+		if configFilesChanged(m.configFiles, cur) {
+			m.configFiles = cur
+			return m, tea.Batch(loadConfigCmd(m.configOpts), configWatchCmd())
+		}
+		return m, configWatchCmd()
+
+	case reloadConfigMsg:
+		return m, loadConfigCmd(m.configOpts)
+
+	case configLoadedMsg:
+		if msg.err != nil {
+			m.editor.msg = "Config reload failed: " + msg.err.Error()
+			return m, nil
+		}
+		m.configInfo = msg.loaded
+		m.configFiles = configStamps(m.configOpts)
+		m.notices = nil
+		for _, issue := range msg.loaded.Issues {
+			m.notices = append(m.notices, issue.String())
+		}
+		cfg := msg.loaded.Config
+		return m, func() tea.Msg { return ConfigChangedMsg{Config: cfg} }
+
+	case ConfigChangedMsg:
+		cmd, err := m.applyConfig(msg)
+		if err != nil {
+			m.notices = append(m.notices, err.Error())
+		}
+		m.editor.msg = "Config reloaded"
+		if len(m.notices) > 0 {
+			m.editor.msg += fmt.Sprintf(" (%d problem(s), see :config)", len(m.notices))
+		}
+		return m, cmd
+
+	case colorschemeMsg:
+		if msg.name == "" {
+			m.editor.msg = "colorscheme " + ActiveTheme + "  (available: " + strings.Join(theme.Names(), ", ") + ")"
+			return m, nil
 		}
+		if _, err := theme.Load(msg.name); err != nil {
+			m.editor.msg = err.Error()
+			return m, nil
+		}
+		m.configInfo.Config.Theme = msg.name
+		if m.configInfo.Sources == nil {
+			m.configInfo.Sources = map[string]string{}
+		}
+		m.configInfo.Sources["theme"] = ":colorscheme"
+		cmd, _ := m.applyConfig(ConfigChangedMsg{Config: m.configInfo.Config})
+		m.editor.msg = "colorscheme " + msg.name
+		return m, cmd
+
+	case aiStreamMsg, aiCompactedMsg, aiWaitMsg, waitTickMsg, GeminiResponseMsg, toolResultMsg, keyResolvedMsg:
+		// Replies go to the agent whichever pane has focus, so a stream
+		// keeps flowing while the user is in the editor.
+		m.agent, cmd = m.agent.Update(msg)
+		return m, cmd
+
+	case toolEditMsg:
+		if !m.agent.waiting || msg.id != m.agent.requestID {
+			return m, nil
+		}
+		note, err := m.applyToolEdit(msg)
+		m.agent, cmd = m.agent.Update(toolResultMsg{Call: msg.Call, Result: note, Err: err, id: msg.id})
+		return m, cmd
+
+	case askAgentMsg:
+		m.focus = FocusAgent
+		return m, m.agent.ask(msg)
+
+	case snippetMsg:
+		m.handleSnippet(msg)
+		return m, nil
+
+	case agentHistoryMsg:
+		if err := m.agent.openHistory(); err != nil {
+			m.editor.msg = err.Error()
+			return m, nil
+		}
+		m.focus = FocusAgent
+		return m, nil
+
+	case agentExportMsg:
+		path, err := m.agent.export(msg.Path, msg.Force)
+		if err != nil {
+			m.editor.msg = err.Error()
+		} else {
+			m.editor.msg = "Exported chat to " + path
+		}
+		return m, nil
+
+	case applyRewriteMsg:
+		note, err := m.applyRewrite(msg)
+		m.agent.rewriteApplied(note, err)
+		return m, nil
+
+	case diskEventMsg:
+		if msg.concerns(m.editor.filename) {
+			m.diskPending = !m.editor.checkDisk()
+		}
+		return m, m.watcher.next()
+
+	case diskCheckMsg:
+		if !m.watching || m.diskPending {
+			m.diskPending = !m.editor.checkDisk()
+		}
+		return m, checkDiskCmd()
+
+	case swapTickMsg:
+		m.editor.writeSwap()
+		return m, swapTickCmd()
+
+	case tea.FocusMsg:
+		m.editor.checkDisk()
 	}
 
 	switch m.focus {
@@ -134,6 +349,10 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.editor, cmd = m.editor.Update(msg)
 		cmds = append(cmds, cmd)
 	case FocusAgent:
+		if key, ok := msg.(tea.KeyMsg); ok && key.String() == m.keys.AgentSend {
+			m.agent.live = m.editor.contextAttachment(m.agent.contextMode)
+			m.agent.buffer = m.editor.contextAttachment(contextBuffer)
+		}
 		m.agent, cmd = m.agent.Update(msg)
 		cmds = append(cmds, cmd)
 	}
@@ -141,6 +360,49 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, tea.Batch(cmds...)
 }
 
+// Close cancels any AI request still in flight and stops watching the open
+// file. Call it once the program exits.
+func (m Model) Close() {
+	m.agent.Cancel()
+	if m.watcher != nil {
+		m.watcher.close()
+	}
+}
+
+// applyConfig rebuilds the styles and hands the new configuration to every
+// pane, returning the agent's command to look up a changed API key.
+func (m *Model) applyConfig(msg ConfigChangedMsg) (tea.Cmd, error) {
+	err := InitStyles(msg.Config)
+	m.keys = msg.Config.Keys
+	m.fileTree, _ = m.fileTree.Update(msg)
+	m.editor, _ = m.editor.Update(msg)
+	var cmd tea.Cmd
+	m.agent, cmd = m.agent.Update(msg)
+	return cmd, err
+}
+
+// nextArg moves through the command-line file list like Vim's :next/:prev.
+func (m *Model) nextArg(msg argMsg) {
+	if len(m.args) == 0 {
+		m.editor.msg = "No file list given on the command line"
+		return
+	}
+	idx := m.argIndex + msg.delta
+	if idx < 0 || idx >= len(m.args) {
+		m.editor.msg = fmt.Sprintf("No more files (%d of %d)", m.argIndex+1, len(m.args))
+		return
+	}
+	if m.editor.modified && !msg.force {
+		m.editor.msg = "No write since last change (add ! to override)"
+		return
+	}
+	m.argIndex = idx
+	m.openFile(m.args[idx])
+	if m.editor.msg == "" {
+		m.editor.msg = fmt.Sprintf("%s (%d of %d)", m.args[idx].Path, idx+1, len(m.args))
+	}
+}
+
 func (m *Model) resizePanes() {
 	treeWidth := 0
 	if m.showTree {
@@ -168,6 +430,42 @@ func (m *Model) resizePanes() {
 	m.agent.SetSize(contentWidth, contentHeight)
 }
 
+// renderConfig lists every effective setting with the layer that supplied it.
+func (m Model) renderConfig() string {
+	var b strings.Builder
+	b.WriteString(StyleBold.Render("Effective configuration") + "\n")
+	for _, layer := range []string{config.LayerSystem, config.LayerUser, config.LayerProject} {
+		if path, ok := m.configInfo.Files[layer]; ok {
+			b.WriteString(StyleDim.Render(fmt.Sprintf("  %-8s %s", layer, path)) + "\n")
+		}
+	}
+	b.WriteString("\n")
+
+	settings := m.configInfo.Settings()
+	width := 0
+	for _, s := range settings {
+		if len(s.Key) > width {
+			width = len(s.Key)
+		}
+	}
+	for _, s := range settings {
+		line := fmt.Sprintf("%-*s = %s", width, s.Key, s.Value)
+		source := StyleDim.Render("  [" + s.Source + "]")
+		if s.Source != config.LayerDefault {
+			source = StyleDiffHunk.Render("  [" + s.Source + "]")
+		}
+		b.WriteString(line + source + "\n")
+	}
+
+	if len(m.configInfo.Issues) > 0 {
+		b.WriteString("\n" + StyleModified.Render("Problems") + "\n")
+		for _, issue := range m.configInfo.Issues {
+			b.WriteString("  " + issue.String() + "\n")
+		}
+	}
+	return b.String()
+}
+
 // renderWelcome renders the alpha.nvim-style welcome screen.
 func (m Model) renderWelcome() string {
 	logo := []string{
@@ -202,6 +500,12 @@ func (m Model) renderWelcome() string {
 	for _, line := range actions {
 		b.WriteString(StyleWelcomeDim.Render(line) + "\n")
 	}
+	if len(m.notices) > 0 {
+		b.WriteString(StyleModified.Render("  Configuration problems:") + "\n")
+		for _, notice := range m.notices {
+			b.WriteString(StyleDim.Render("  • "+notice) + "\n")
+		}
+	}
 
 	return b.String()
 }
@@ -212,6 +516,9 @@ func (m Model) View() string {
 	if m.showWelcome {
 		content = m.renderWelcome()
 	} else if m.focus == FocusAgent {
+		if m.agent.contextMode == contextFunction {
+			m.agent.cursorFunction = m.editor.cursorFunction()
+		}
 		content = m.agent.View()
 	} else {
 		content = m.editor.View()
@@ -0,0 +1,73 @@
+package tui
+
+import (
+	"os"
+	"time"
+
+	"github.com/CiaranMccarthy1/boba-text/pkg/config"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// ConfigChangedMsg carries a freshly loaded configuration to every pane.
+type ConfigChangedMsg struct {
+	Config config.Config
+}
+
+// colorschemeMsg switches to the named theme, or reports the current one when name is empty.
+type colorschemeMsg struct {
+	name string
+}
+
+// reloadConfigMsg asks the model to reload configuration from disk.
+type reloadConfigMsg struct{}
+
+// configLoadedMsg is the result of reloading configuration in the background.
+type configLoadedMsg struct {
+	loaded config.Loaded
+	err    error
+}
+
+// configWatchMsg triggers a check of the config files for changes.
+type configWatchMsg struct{}
+
+func configWatchCmd() tea.Cmd {
+	return tea.Tick(2*time.Second, func(time.Time) tea.Msg { return configWatchMsg{} })
+}
+
+// loadConfigCmd reloads the configuration layers. Project configs that are not
+// already trusted are skipped, since there is no terminal to ask on.
+func loadConfigCmd(opts config.LoadOptions) tea.Cmd {
+	opts.TrustProject = nil
+	return func() tea.Msg {
+		loaded, err := config.Load(opts)
+		return configLoadedMsg{loaded: loaded, err: err}
+	}
+}
+
+// configStamps records the modification time of each config file, using the
+// zero time for files that do not exist.
+func configStamps(opts config.LoadOptions) map[string]time.Time {
+	stamps := map[string]time.Time{}
+	for _, path := range opts.Paths() {
+		if info, err := os.Stat(path); err == nil {
+			stamps[path] = info.ModTime()
+		} else {
+			stamps[path] = time.Time{}
+		}
+	}
+	return stamps
+}
+
+// configFilesChanged reports whether any watched config file was created,
+// removed or modified since the stamps were taken.
+func configFilesChanged(old, cur map[string]time.Time) bool {
+	if len(old) != len(cur) {
+		return true
+	}
+	for path, t := range cur {
+		if prev, ok := old[path]; !ok || !prev.Equal(t) {
+			return true
+		}
+	}
+	return false
+}
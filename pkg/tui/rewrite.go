@@ -0,0 +1,400 @@
+package tui
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/CiaranMccarthy1/boba-text/pkg/diff"
+	"github.com/CiaranMccarthy1/boba-text/pkg/fileio"
+	"github.com/CiaranMccarthy1/boba-text/pkg/llm"
+	"github.com/CiaranMccarthy1/boba-text/pkg/tools"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/x/ansi"
+)
+
+// rewriteInstructions tells the model how to phrase file changes so they can
+// be picked out of the reply and offered for approval.
+const rewriteInstructions = "To propose changes to a file, give either a unified diff in a ```diff block " +
+	"with --- and +++ headers naming the file, or the complete new file in a fenced block whose info " +
+	"string is the language followed by the file path, e.g. ```go main.go. Paths are relative to the project root."
+
+// applyRewriteMsg asks the model to apply an approved rewrite to the editor
+// buffer or straight to disk.
+type applyRewriteMsg struct {
+	Rewrite *PendingRewrite
+	ToDisk  bool
+}
+
+// codeBlock is a fenced block from a markdown reply, with the non-blank line
+// before it, which often names the file.
+type codeBlock struct {
+	Info   string
+	Body   string
+	Before string
+}
+
+// codeBlocks extracts the fenced code blocks from markdown text.
+func codeBlocks(text string) []codeBlock {
+	var blocks []codeBlock
+	var cur *codeBlock
+	var fence, before string
+	var body []string
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if cur == nil {
+			if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+				n := len(trimmed) - len(strings.TrimLeft(trimmed, trimmed[:1]))
+				fence = trimmed[:n]
+				cur = &codeBlock{Info: strings.TrimSpace(trimmed[n:]), Before: before}
+				body = nil
+			} else if trimmed != "" {
+				before = trimmed
+			}
+			continue
+		}
+		if strings.HasPrefix(trimmed, fence) && strings.TrimLeft(trimmed, fence[:1]) == "" {
+			cur.Body = strings.Join(body, "\n")
+			if len(body) > 0 {
+				cur.Body += "\n"
+			}
+			blocks = append(blocks, *cur)
+			cur, before = nil, ""
+			continue
+		}
+		body = append(body, line)
+	}
+	return blocks
+}
+
+// parseRewrites finds proposed file changes in a reply: unified diffs and
+// whole-file blocks labelled with a path. attachments supply the text the
+// model saw for files sent with the prompt; other files are read from disk.
+func parseRewrites(reply, root string, attachments []llm.Attachment) ([]*PendingRewrite, []error) {
+	var rewrites []*PendingRewrite
+	var errs []error
+
+	original := func(path string) (string, error) {
+		for _, a := range attachments {
+			// Only a whole-file attachment is the text the change applies to.
+			if samePath(a.Name, path) && a.Label == "" {
+				return a.Content, nil
+			}
+		}
+		data, err := os.ReadFile(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", nil
+		}
+		if err != nil {
+			return "", err
+		}
+		text, _, err := fileio.Decode(data)
+		return text, err
+	}
+	add := func(name, content string, hunks []diff.Hunk) {
+		path, err := resolveRewritePath(name, root, attachments)
+		if err != nil {
+			errs = append(errs, err)
+			return
+		}
+		orig, err := original(path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			return
+		}
+		if hunks != nil {
+			if content, err = diff.Apply(orig, hunks); err != nil {
+				errs = append(errs, fmt.Errorf("proposed diff for %s does not apply: %w", name, err))
+				return
+			}
+		}
+		if content == orig {
+			return
+		}
+		rewrites = append(rewrites, newPendingRewrite(path, orig, content, root))
+	}
+
+	for _, b := range codeBlocks(reply) {
+		lang, _, _ := strings.Cut(b.Info, " ")
+		if lang == "diff" || lang == "patch" || strings.HasPrefix(b.Body, "--- ") {
+			files, err := diff.Parse(b.Body)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("could not read proposed diff: %w", err))
+				continue
+			}
+			for _, f := range files {
+				name := f.NewName
+				if name == "" {
+					name = f.OldName
+				}
+				add(name, "", f.Hunks)
+			}
+			continue
+		}
+		if name := blockPath(b); name != "" {
+			add(name, b.Body, nil)
+		}
+	}
+	return rewrites, errs
+}
+
+// blockPath returns the file a whole-file block is labelled with: a path in
+// its info string ("go main.go", "path=main.go") or on the line before it
+// ("**main.go**:").
+func blockPath(b codeBlock) string {
+	for _, field := range strings.Fields(b.Info) {
+		if _, v, ok := strings.Cut(field, "="); ok {
+			field = strings.Trim(v, `"'`)
+		}
+		if looksLikePath(field) {
+			return field
+		}
+	}
+	before := strings.TrimSuffix(strings.Trim(b.Before, "*`_ "), ":")
+	before = strings.Trim(before, "*`_ ")
+	for _, prefix := range []string{"File:", "file:", "Path:", "path:"} {
+		before = strings.TrimSpace(strings.TrimPrefix(before, prefix))
+	}
+	before = strings.Trim(before, "*`_ ")
+	if looksLikePath(before) {
+		return before
+	}
+	return ""
+}
+
+func looksLikePath(s string) bool {
+	return s != "" && !strings.ContainsAny(s, " \t") && strings.ContainsAny(s, "./") && !strings.HasSuffix(s, ".")
+}
+
+// resolveRewritePath turns a path named by the model into an absolute path,
+// refusing anything outside the project root, or linking out of it, other
+// than attached files.
+func resolveRewritePath(name, root string, attachments []llm.Attachment) (string, error) {
+	for _, a := range attachments {
+		if samePath(a.Name, name) {
+			return a.Name, nil
+		}
+	}
+	path, err := tools.Workspace{Root: root}.Resolve(name)
+	if err != nil {
+		return "", fmt.Errorf("ignoring proposed change: %w", err)
+	}
+	return path, nil
+}
+
+func samePath(a, b string) bool {
+	if a == b {
+		return true
+	}
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
+
+// Name returns the rewrite's path relative to the project root when possible.
+func (r *PendingRewrite) Name() string {
+	if rel, err := filepath.Rel(r.root, r.FilePath); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return rel
+	}
+	return r.FilePath
+}
+
+// hunkState is the reviewer's decision on one hunk of a proposal.
+type hunkState int
+
+const (
+	// hunkPending hunks have not been looked at; they are applied like accepted ones.
+	hunkPending hunkState = iota
+	hunkAccepted
+	hunkRejected
+)
+
+func newPendingRewrite(path, original, content, root string) *PendingRewrite {
+	hunks := diff.Compute(original, content, 3)
+	return &PendingRewrite{
+		FilePath: path,
+		Content:  content,
+		Original: original,
+		root:     root,
+		hunks:    hunks,
+		states:   make([]hunkState, len(hunks)),
+		edited:   make([]bool, len(hunks)),
+	}
+}
+
+// Diff returns the proposal as a unified diff.
+func (r *PendingRewrite) Diff() string {
+	return diff.Unified("a/"+r.Name(), "b/"+r.Name(), r.hunks)
+}
+
+// selected returns the hunks that will be applied.
+func (r *PendingRewrite) selected() []diff.Hunk {
+	var hunks []diff.Hunk
+	for i, h := range r.hunks {
+		if r.states[i] != hunkRejected {
+			hunks = append(hunks, h)
+		}
+	}
+	return hunks
+}
+
+// counts returns how many hunks are accepted (or undecided) and rejected.
+func (r *PendingRewrite) counts() (apply, reject int) {
+	for _, st := range r.states {
+		if st == hunkRejected {
+			reject++
+		} else {
+			apply++
+		}
+	}
+	return apply, reject
+}
+
+// editHunk replaces the new side of hunk i with text and accepts it.
+func (r *PendingRewrite) editHunk(i int, text string) {
+	h := r.hunks[i]
+	h.Lines = diff.Lines(h.Old(), diff.SplitLines(text))
+	h.NewLines = len(diff.SplitLines(text))
+	r.hunks[i] = h
+	r.states[i] = hunkAccepted
+	r.edited[i] = true
+}
+
+// render draws the proposal with each hunk's decision, highlighting hunk cur
+// (-1 for none). It also returns the line each hunk starts on.
+func (r *PendingRewrite) render(cur int) (string, []int) {
+	var b strings.Builder
+	b.WriteString(StyleBold.Render("Proposed change to "+r.Name()+":") + "\n")
+	b.WriteString(StyleBold.Render("--- a/"+r.Name()) + "\n" + StyleBold.Render("+++ b/"+r.Name()) + "\n")
+	line := 3
+	offsets := make([]int, len(r.hunks))
+	for i, h := range r.hunks {
+		offsets[i] = line
+		marker := "·"
+		switch r.states[i] {
+		case hunkAccepted:
+			marker = StyleDiffAdd.Render("✓")
+		case hunkRejected:
+			marker = StyleDiffDelete.Render("✗")
+		}
+		label := fmt.Sprintf("%s %s  hunk %d/%d", marker, h.Header(), i+1, len(r.hunks))
+		if r.edited[i] {
+			label += " (edited)"
+		}
+		if i == cur {
+			label = StyleModeCommand.Render(" ▶ ") + " " + label
+		}
+		b.WriteString(label + "\n")
+		body := renderDiff(diff.Unified("", "", []diff.Hunk{h}))
+		// Drop the file and hunk headers renderDiff was given.
+		bodyLines := strings.Split(body, "\n")[3:]
+		if r.states[i] == hunkRejected {
+			for j, l := range bodyLines {
+				bodyLines[j] = StyleDim.Render(ansi.Strip(l))
+			}
+		}
+		b.WriteString(strings.Join(bodyLines, "\n") + "\n")
+		line += 1 + len(bodyLines)
+	}
+	return strings.TrimSuffix(b.String(), "\n"), offsets
+}
+
+// applyTo returns the accepted hunks applied to current, and whether current
+// had moved on from the text the proposal was made against. Hunks are matched
+// by content, so edits elsewhere in the file do not get in the way.
+func (r *PendingRewrite) applyTo(current string) (string, bool, error) {
+	changed := current != r.Original
+	text, err := diff.Apply(current, r.selected())
+	if err != nil {
+		if changed {
+			return "", true, fmt.Errorf("%s changed since the proposal and it no longer applies: %w", r.Name(), err)
+		}
+		return "", false, err
+	}
+	return text, changed, nil
+}
+
+// applyRewrite carries out an approved rewrite, returning a note for the agent.
+func (m *Model) applyRewrite(msg applyRewriteMsg) (string, error) {
+	r := msg.Rewrite
+	open := m.editor.filename != "" && samePath(m.editor.filename, r.FilePath)
+	const changedNote = " (the file had changed since the proposal; the edits were merged into it)"
+
+	if msg.ToDisk {
+		if m.editor.readOnly {
+			return "", errors.New("read-only mode, not writing to disk")
+		}
+		if open && m.editor.modified {
+			return "", errors.New("the open buffer has unsaved changes; apply to the buffer instead")
+		}
+		current, format := "", fileio.DefaultFormat()
+		data, err := os.ReadFile(r.FilePath)
+		switch {
+		case err == nil:
+			if current, format, err = fileio.Decode(data); err != nil {
+				return "", err
+			}
+		case !errors.Is(err, fs.ErrNotExist):
+			return "", err
+		}
+		text, changed, err := r.applyTo(current)
+		if err != nil {
+			return "", err
+		}
+		out, err := fileio.Encode(text, format)
+		if err != nil {
+			return "", err
+		}
+		if err := os.MkdirAll(filepath.Dir(r.FilePath), 0755); err != nil {
+			return "", err
+		}
+		err = fileio.Save(r.FilePath, out, fileio.SaveOptions{
+			Backup:    m.editor.files.Backup,
+			BackupDir: m.editor.files.BackupDir,
+		})
+		if err != nil {
+			return "", err
+		}
+		if open {
+			if err := m.editor.loadFile(r.FilePath); err != nil {
+				return "", err
+			}
+		}
+		note := "Written to disk: " + r.Name()
+		if changed {
+			note += changedNote
+		}
+		return note, nil
+	}
+
+	if !open {
+		if m.editor.modified {
+			return "", fmt.Errorf("no write since last change to %s; save it before applying to another file", m.editor.filename)
+		}
+		focus := m.focus
+		m.openFile(FileArg{Path: r.FilePath})
+		m.focus = focus
+		if !samePath(m.editor.filename, r.FilePath) {
+			return "", errors.New(m.editor.msg)
+		}
+	}
+	text, changed, err := r.applyTo(m.editor.textarea.Value())
+	if err != nil {
+		return "", err
+	}
+	m.editor.replaceContent(text)
+	m.editor.msg = "Applied AI change (u to undo)"
+	note := "Applied to buffer: " + r.Name() + " — press u in the editor to undo, :w to save"
+	if changed {
+		note += changedNote
+	}
+	return note, nil
+}
+
+// applyRewriteCmd hands an approved rewrite to the model.
+func applyRewriteCmd(r *PendingRewrite, toDisk bool) tea.Cmd {
+	return func() tea.Msg { return applyRewriteMsg{Rewrite: r, ToDisk: toDisk} }
+}
@@ -0,0 +1,107 @@
+package tui
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/x/ansi"
+)
+
+// sessionKeys are the [ai] settings /set can change for the session.
+var sessionKeys = []string{"system_prompt", "temperature", "top_p", "max_output_tokens", "stop"}
+
+// set handles /set. Without arguments it lists the settings; "/set key value"
+// overrides one until boba-text exits, and "/set key" goes back to the
+// configured value.
+func (m *AgentModel) set(arg string) {
+	key, value, _ := strings.Cut(strings.TrimSpace(arg), " ")
+	value = strings.TrimSpace(value)
+	switch {
+	case key == "":
+		m.messages = append(m.messages, m.sessionSettings())
+	case !contains(sessionKeys, key):
+		m.messages = append(m.messages, m.errorStyle.Render("Error: ")+
+			fmt.Sprintf("/set cannot change %q (try %s)", key, strings.Join(sessionKeys, ", ")))
+	case value == "":
+		delete(m.overrides, key)
+		m.applyOverrides()
+		m.messages = append(m.messages, StyleDim.Render(key+" = "+m.sessionValue(key)+" (from the config)"))
+	default:
+		next := m.config
+		if err := next.Set(key, value); err != nil {
+			m.messages = append(m.messages, m.errorStyle.Render("Error: ")+err.Error())
+			break
+		}
+		if m.overrides == nil {
+			m.overrides = map[string]string{}
+		}
+		m.overrides[key] = value
+		m.config = next
+		m.messages = append(m.messages, StyleDim.Render(key+" = "+m.sessionValue(key)+" for this session"))
+	}
+	m.textarea.Reset()
+	m.refresh()
+}
+
+// applyOverrides puts the session's /set values on top of the configured
+// settings, dropping any the current config no longer accepts.
+func (m *AgentModel) applyOverrides() {
+	cfg := m.configured
+	for _, key := range sessionKeys {
+		if value, ok := m.overrides[key]; ok {
+			if err := cfg.Set(key, value); err != nil {
+				delete(m.overrides, key)
+			}
+		}
+	}
+	m.config = cfg
+}
+
+// sessionSettings lists the settings /set changes with their values.
+func (m AgentModel) sessionSettings() string {
+	lines := []string{StyleBold.Render("Settings") + StyleDim.Render("  (/set key value to change, /set key to reset)")}
+	for _, key := range sessionKeys {
+		line := "  " + key + " = " + m.sessionValue(key)
+		if _, ok := m.overrides[key]; ok {
+			line += StyleDim.Render("  [this session]")
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// sessionValue formats a setting for display.
+func (m AgentModel) sessionValue(key string) string {
+	cfg := m.config
+	switch key {
+	case "system_prompt":
+		if cfg.SystemPrompt == "" {
+			return "(built-in)"
+		}
+		return strconv.Quote(ansi.Truncate(strings.Join(strings.Fields(cfg.SystemPrompt), " "), 60, "…"))
+	case "temperature":
+		return optionalFloat(cfg.Temperature)
+	case "top_p":
+		return optionalFloat(cfg.TopP)
+	case "max_output_tokens":
+		if cfg.MaxOutputTokens == 0 {
+			return "(provider default)"
+		}
+		return strconv.Itoa(cfg.MaxOutputTokens)
+	case "stop":
+		quoted := make([]string, len(cfg.Stop))
+		for i, s := range cfg.Stop {
+			quoted[i] = strconv.Quote(s)
+		}
+		return "[" + strings.Join(quoted, ", ") + "]"
+	}
+	return ""
+}
+
+func optionalFloat(f *float64) string {
+	if f == nil {
+		return "(provider default)"
+	}
+	return strconv.FormatFloat(*f, 'g', -1, 64)
+}
@@ -0,0 +1,133 @@
+package tui
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// snippetMsg asks for code block N of the agent pane to be yanked or put in
+// the editor. 0 means the latest block.
+type snippetMsg struct {
+	N    int
+	Yank bool
+	// Replace puts the block in place of rows Start to End (0-based,
+	// inclusive) instead of at the cursor; Visual narrows that to the
+	// exact text of the last characterwise selection.
+	Replace    bool
+	Start, End int
+	Visual     bool
+}
+
+// snippetCmd parses the block number of :yank N or :insert N.
+func snippetCmd(arg string, msg snippetMsg) (tea.Cmd, error) {
+	if arg = strings.TrimSpace(arg); arg != "" {
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("not a code block number: %s", arg)
+		}
+		msg.N = n
+	}
+	return func() tea.Msg { return msg }, nil
+}
+
+// showReply renders a reply in the pane, numbering its code blocks after
+// those of earlier replies. Once final, the blocks can be used by number.
+func (m *AgentModel) showReply(text string, final bool) {
+	m.messages[m.replyIndex] = m.renderReply(text, final)
+}
+
+// renderReply styles a reply as showReply does, without placing it.
+func (m *AgentModel) renderReply(text string, final bool) string {
+	body, blocks := renderMarkdown(text, len(m.snippets)+1)
+	if final {
+		m.snippets = append(m.snippets, blocks...)
+	}
+	return m.aiStyle.Render(m.aiName()+": ") + body
+}
+
+// snippet returns code block n, or the latest one for 0.
+func (m AgentModel) snippet(n int) (string, error) {
+	if len(m.snippets) == 0 {
+		return "", fmt.Errorf("no code blocks in the agent pane")
+	}
+	if n == 0 {
+		n = len(m.snippets)
+	}
+	if n > len(m.snippets) {
+		return "", fmt.Errorf("no code block %d (the pane has %d)", n, len(m.snippets))
+	}
+	return m.snippets[n-1], nil
+}
+
+// insertSnippet asks for the latest code block, or the one whose number is
+// typed in the input, to be inserted in the editor.
+func (m *AgentModel) insertSnippet() tea.Cmd {
+	msg := snippetMsg{}
+	if n, err := strconv.Atoi(strings.TrimSpace(m.textarea.Value())); err == nil {
+		msg.N = n
+		m.textarea.Reset()
+	}
+	return func() tea.Msg { return msg }
+}
+
+// putSnippet puts text in the buffer as one undoable change: at the cursor,
+// or in place of the requested rows or selection.
+func (m *EditorModel) putSnippet(text string, msg snippetMsg) {
+	if !msg.Replace {
+		m.pushUndo()
+		m.textarea.InsertString(text)
+		m.modified = true
+		return
+	}
+
+	lines := strings.Split(m.textarea.Value(), "\n")
+	end := min(msg.End, len(lines)-1)
+	start := max(0, min(msg.Start, end))
+	var replaced []string
+	if msg.Visual {
+		first, last := []rune(lines[start]), []rune(lines[end])
+		sc := min(m.visualStartCol, len(first))
+		ec := min(m.visualEndCol+1, len(last))
+		replaced = strings.Split(string(first[:sc])+text+string(last[ec:]), "\n")
+	} else {
+		replaced = strings.Split(text, "\n")
+	}
+	lines = append(lines[:start], append(replaced, lines[end+1:]...)...)
+	m.replaceContent(strings.Join(lines, "\n"))
+	m.moveToLine(start)
+}
+
+// handleSnippet yanks a code block from the agent pane or puts it in the
+// editor. Inserting while a selection is active replaces it.
+func (m *Model) handleSnippet(msg snippetMsg) {
+	text, err := m.agent.snippet(msg.N)
+	if err != nil {
+		m.editor.msg = err.Error()
+		return
+	}
+	n := msg.N
+	if n == 0 {
+		n = len(m.agent.snippets)
+	}
+	lines := strings.Count(text, "\n") + 1
+	if msg.Yank {
+		m.editor.yankBuffer = text
+		m.editor.msg = fmt.Sprintf("Yanked code block %d (%d line(s))", n, lines)
+		return
+	}
+	if m.editor.mode == ModeVisual {
+		m.editor.endVisual()
+		msg.Replace, msg.Start, msg.End, msg.Visual = true, m.editor.visualStart, m.editor.visualEnd, !m.editor.lineVisual
+	}
+	m.editor.putSnippet(text, msg)
+	m.editor.msg = fmt.Sprintf("Inserted code block %d (%d line(s))", n, lines)
+	switch {
+	case msg.Visual:
+		m.editor.msg = fmt.Sprintf("Replaced the selection with code block %d", n)
+	case msg.Replace:
+		m.editor.msg = fmt.Sprintf("Replaced %s with code block %d", lineLabel(msg.Start, msg.End), n)
+	}
+}
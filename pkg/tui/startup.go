@@ -0,0 +1,36 @@
+package tui
+
+import "github.com/CiaranMccarthy1/boba-text/pkg/config"
+
+// FileArg is a file named on the command line, with an optional 1-based
+// line and column to place the cursor at.
+type FileArg struct {
+	Path string
+	Line int
+	Col  int
+}
+
+// StartupOptions carries command-line settings into the initial model.
+type StartupOptions struct {
+	// Files are opened in order; the first is shown and the rest can be
+	// reached with :next and :prev.
+	Files []FileArg
+	// ReadOnly refuses to write buffers unless the save is forced with !.
+	ReadOnly bool
+	// Stdin, when set, is opened in an unnamed scratch buffer.
+	Stdin *string
+	// Config describes where the configuration came from; its issues are
+	// shown as a notification on startup and :config lists its sources.
+	Config config.Loaded
+	// ConfigOptions are reused to reload the configuration when its files change.
+	ConfigOptions config.LoadOptions
+}
+
+// showConfigMsg asks the model to list the effective configuration.
+type showConfigMsg struct{}
+
+// argMsg moves through the command-line file list by delta entries.
+type argMsg struct {
+	delta int
+	force bool
+}
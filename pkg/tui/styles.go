@@ -1,7 +1,11 @@
 package tui
 
 import (
+	"os"
+
 	"github.com/CiaranMccarthy1/boba-text/pkg/config"
+	"github.com/CiaranMccarthy1/boba-text/pkg/theme"
+	"github.com/charmbracelet/colorprofile"
 	"github.com/charmbracelet/lipgloss"
 )
 
@@ -16,6 +20,12 @@ var (
 	ColorError     lipgloss.Color
 	ColorDark      lipgloss.Color
 
+	// Chrome colours that used to be hard-coded
+	ColorStatusLine lipgloss.Color
+	ColorTabBar     lipgloss.Color
+	ColorCursorLine lipgloss.Color
+	ColorModeFg     lipgloss.Color
+
 	StyleNormal         lipgloss.Style
 	StyleDim            lipgloss.Style
 	StyleBold           lipgloss.Style
@@ -43,19 +53,49 @@ var (
 	StyleFileIcon    lipgloss.Style
 	StyleDirIcon     lipgloss.Style
 	StyleModified    lipgloss.Style
+
+	// Diff views
+	StyleDiffAdd    lipgloss.Style
+	StyleDiffDelete lipgloss.Style
+	StyleDiffHunk   lipgloss.Style
+
+	StyleSelection lipgloss.Style
+
+	// SyntaxStyles maps token classes ("keyword", "string", ...) to styles.
+	SyntaxStyles map[string]lipgloss.Style
+
+	// ActiveTheme is the name of the theme the styles were built from.
+	ActiveTheme string
 )
 
-// InitStyles initializes all global styles with the given color configuration.
-func InitStyles(c config.Colors) {
-	ColorText = lipgloss.Color(c.Text)
-	ColorSubText = lipgloss.Color(c.SubText)
-	ColorPrimary = lipgloss.Color(c.Primary)
-	ColorSecondary = lipgloss.Color(c.Secondary)
-	ColorAccent = lipgloss.Color(c.Accent)
-	ColorSuccess = lipgloss.Color(c.Success)
-	ColorWarning = lipgloss.Color(c.Warning)
-	ColorError = lipgloss.Color(c.Error)
-	ColorDark = lipgloss.Color(c.Dark)
+// colorProfile is detected once; theme colours are degraded to what it supports.
+var colorProfile = colorprofile.Detect(os.Stdout, os.Environ())
+
+// InitStyles initializes all global styles from the configured theme, with the
+// [colors] section overriding individual theme colours. An unknown theme falls
+// back to the default one and is reported as an error.
+func InitStyles(cfg config.Config) error {
+	t, err := theme.Load(cfg.Theme)
+	overrideColors(&t.UI, cfg.Colors)
+	ActiveTheme = t.Name
+
+	c := func(hex string) lipgloss.Color {
+		return lipgloss.Color(theme.Adapt(colorProfile, hex))
+	}
+
+	ColorText = c(t.UI.Text)
+	ColorSubText = c(t.UI.SubText)
+	ColorPrimary = c(t.UI.Primary)
+	ColorSecondary = c(t.UI.Secondary)
+	ColorAccent = c(t.UI.Accent)
+	ColorSuccess = c(t.UI.Success)
+	ColorWarning = c(t.UI.Warning)
+	ColorError = c(t.UI.Error)
+	ColorDark = c(t.UI.Dark)
+	ColorStatusLine = c(t.UI.StatusLine)
+	ColorTabBar = c(t.UI.TabBar)
+	ColorCursorLine = c(t.UI.CursorLine)
+	ColorModeFg = c(t.Modes.Foreground)
 
 	StyleNormal = lipgloss.NewStyle().Foreground(ColorText)
 	StyleDim = lipgloss.NewStyle().Foreground(ColorSubText)
@@ -91,50 +131,55 @@ func InitStyles(c config.Colors) {
 
 	StyleTabInactive = lipgloss.NewStyle().
 		Foreground(ColorSubText).
-		Background(lipgloss.Color("#2A2A2A")).
+		Background(ColorStatusLine).
 		Padding(0, 2)
 
 	StyleTabBar = lipgloss.NewStyle().
-		Background(lipgloss.Color("#1A1A1A")).
+		Background(ColorTabBar).
 		Padding(0, 0)
 
 	// Status line
 	StyleStatusLine = lipgloss.NewStyle().
 		Foreground(ColorText).
-		Background(lipgloss.Color("#2A2A2A")).
+		Background(ColorStatusLine).
 		Padding(0, 1)
 
 	// Mode indicators (Neovim-style lualine colors)
 	StyleModeNormal = lipgloss.NewStyle().
-		Foreground(lipgloss.Color("#1E1E1E")).
-		Background(ColorAccent).
+		Foreground(ColorModeFg).
+		Background(c(t.Modes.Normal)).
 		Bold(true).
 		Padding(0, 1)
 
 	StyleModeInsert = lipgloss.NewStyle().
-		Foreground(lipgloss.Color("#1E1E1E")).
-		Background(ColorSuccess).
+		Foreground(ColorModeFg).
+		Background(c(t.Modes.Insert)).
 		Bold(true).
 		Padding(0, 1)
 
 	StyleModeVisual = lipgloss.NewStyle().
-		Foreground(lipgloss.Color("#1E1E1E")).
-		Background(ColorSecondary).
+		Foreground(ColorModeFg).
+		Background(c(t.Modes.Visual)).
 		Bold(true).
 		Padding(0, 1)
 
 	StyleModeCommand = lipgloss.NewStyle().
-		Foreground(lipgloss.Color("#1E1E1E")).
-		Background(ColorWarning).
+		Foreground(ColorModeFg).
+		Background(c(t.Modes.Command)).
 		Bold(true).
 		Padding(0, 1)
 
 	// Search highlight
 	StyleSearch = lipgloss.NewStyle().
-		Background(ColorWarning).
-		Foreground(lipgloss.Color("#1E1E1E")).
+		Background(c(t.Search.Bg)).
+		Foreground(c(t.Search.Fg)).
 		Bold(true)
 
+	// Visual selection
+	StyleSelection = lipgloss.NewStyle().
+		Background(c(t.Selection.Bg)).
+		Foreground(c(t.Selection.Fg))
+
 	// Welcome screen
 	StyleWelcome = lipgloss.NewStyle().
 		Foreground(ColorPrimary).
@@ -152,7 +197,7 @@ func InitStyles(c config.Colors) {
 
 	// Cursor line highlight
 	StyleCursorLine = lipgloss.NewStyle().
-		Background(lipgloss.Color("#2A2A2A"))
+		Background(ColorCursorLine)
 
 	// File tree icons
 	StyleFileIcon = lipgloss.NewStyle().
@@ -165,4 +210,49 @@ func InitStyles(c config.Colors) {
 	StyleModified = lipgloss.NewStyle().
 		Foreground(ColorWarning).
 		Bold(true)
+
+	// Diff lines
+	StyleDiffAdd = lipgloss.NewStyle().
+		Foreground(ColorSuccess)
+
+	StyleDiffDelete = lipgloss.NewStyle().
+		Foreground(ColorError)
+
+	StyleDiffHunk = lipgloss.NewStyle().
+		Foreground(ColorAccent)
+
+	// Syntax token classes
+	SyntaxStyles = map[string]lipgloss.Style{
+		"keyword":     lipgloss.NewStyle().Foreground(c(t.Syntax.Keyword)),
+		"string":      lipgloss.NewStyle().Foreground(c(t.Syntax.String)),
+		"number":      lipgloss.NewStyle().Foreground(c(t.Syntax.Number)),
+		"comment":     lipgloss.NewStyle().Foreground(c(t.Syntax.Comment)).Italic(true),
+		"function":    lipgloss.NewStyle().Foreground(c(t.Syntax.Function)),
+		"type":        lipgloss.NewStyle().Foreground(c(t.Syntax.Type)),
+		"constant":    lipgloss.NewStyle().Foreground(c(t.Syntax.Constant)),
+		"operator":    lipgloss.NewStyle().Foreground(c(t.Syntax.Operator)),
+		"punctuation": lipgloss.NewStyle().Foreground(c(t.Syntax.Punctuation)),
+		"heading":     lipgloss.NewStyle().Foreground(c(t.Syntax.Heading)).Bold(true),
+		"link":        lipgloss.NewStyle().Foreground(c(t.Syntax.Link)).Underline(true),
+	}
+
+	return err
+}
+
+// overrideColors applies the non-empty [colors] settings on top of a theme.
+func overrideColors(ui *theme.UI, c config.Colors) {
+	set := func(dst *string, v string) {
+		if v != "" {
+			*dst = v
+		}
+	}
+	set(&ui.Text, c.Text)
+	set(&ui.SubText, c.SubText)
+	set(&ui.Primary, c.Primary)
+	set(&ui.Secondary, c.Secondary)
+	set(&ui.Accent, c.Accent)
+	set(&ui.Success, c.Success)
+	set(&ui.Warning, c.Warning)
+	set(&ui.Error, c.Error)
+	set(&ui.Dark, c.Dark)
 }
@@ -0,0 +1,156 @@
+package tui
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/CiaranMccarthy1/boba-text/pkg/config"
+)
+
+// builtinTemplates are the slash commands every agent pane has; templates in
+// the config with the same name replace them.
+var builtinTemplates = []config.Template{
+	{
+		Name:        "explain",
+		Description: "explain how the code works",
+		Prompt:      "Explain what this {{filetype}} code from {{file}} does and how it works, step by step.",
+	},
+	{
+		Name:        "tests",
+		Description: "write unit tests",
+		Prompt: "Write unit tests for this {{filetype}} code from {{file}}, following the conventions of the " +
+			"project's existing tests. Cover the edge cases and failure paths.",
+	},
+	{
+		Name:        "doc",
+		Description: "add doc comments",
+		Prompt: "Add documentation comments to this {{filetype}} code from {{file}} in the usual style for the " +
+			"language. Keep the code itself unchanged.",
+	},
+	{
+		Name:        "fix",
+		Description: "find and fix bugs",
+		Prompt: "Find and fix the bugs in this {{filetype}} code from {{file}}. Explain each problem briefly, " +
+			"then give the corrected code.",
+	},
+	{
+		Name:        "review",
+		Description: "review the code",
+		Prompt: "Review this {{filetype}} code from {{file}}: point out bugs, unclear code, missing error " +
+			"handling and performance problems, most important first.",
+	},
+}
+
+// agentCommands are the slash commands built into the pane itself.
+var agentCommands = []config.Template{
+	{Name: "new", Description: "start a new conversation"},
+	{Name: "clear", Description: "start a new conversation and clear the pane"},
+	{Name: "history", Description: "browse saved chats"},
+	{Name: "set", Description: "show or change temperature, top_p and the like for this session"},
+}
+
+// slashCommands lists the pane's commands and templates for completion.
+func (m AgentModel) slashCommands() []config.Template {
+	commands := append([]config.Template(nil), agentCommands...)
+	return append(commands, m.templates()...)
+}
+
+// templates merges the configured templates into the built-in ones.
+func (m AgentModel) templates() []config.Template {
+	templates := append([]config.Template(nil), builtinTemplates...)
+	for _, t := range m.config.Templates {
+		replaced := false
+		for i := range templates {
+			if templates[i].Name == t.Name {
+				templates[i], replaced = t, true
+			}
+		}
+		if !replaced {
+			templates = append(templates, t)
+		}
+	}
+	return templates
+}
+
+// expandTemplate turns "/name text" into the template's prompt, reporting
+// whether the prompt took the context in with {{selection}} so it need not
+// also be attached. Other input is returned as it is.
+func (m AgentModel) expandTemplate(input string) (string, bool, error) {
+	command, ok := strings.CutPrefix(strings.TrimSpace(input), "/")
+	if !ok {
+		return input, false, nil
+	}
+	name, rest, _ := strings.Cut(command, " ")
+	var tmpl *config.Template
+	for _, t := range m.templates() {
+		if t.Name == name {
+			tmpl = &t
+			break
+		}
+	}
+	if tmpl == nil {
+		return input, false, nil
+	}
+
+	attached := m.pinned
+	if attached == nil {
+		attached = m.live
+	}
+	file := m.currentFile
+	if attached != nil {
+		file = attached.Name
+	}
+	if strings.Contains(tmpl.Prompt, "{{diagnostics}}") {
+		return "", false, fmt.Errorf("/%s uses {{diagnostics}}, which is not supported: the editor does not collect diagnostics", name)
+	}
+	if strings.Contains(tmpl.Prompt, "{{selection}}") && attached == nil {
+		return "", false, fmt.Errorf("/%s needs context: select code and use :'<,'>Ask, or pick some with %s", name, m.keys.AgentContext)
+	}
+
+	shown, selection := "[No Name]", ""
+	if file != "" && file != "[No Name]" {
+		shown = filepath.ToSlash(relPath(m.root, file))
+	}
+	if attached != nil {
+		selection = attached.Content
+	}
+	rest = strings.TrimSpace(rest)
+	prompt := strings.NewReplacer(
+		"{{file}}", shown,
+		"{{filetype}}", fileType(file),
+		"{{selection}}", selection,
+		"{{input}}", rest,
+	).Replace(tmpl.Prompt)
+	if rest != "" && !strings.Contains(tmpl.Prompt, "{{input}}") {
+		prompt += "\n\n" + rest
+	}
+	return prompt, strings.Contains(tmpl.Prompt, "{{selection}}"), nil
+}
+
+// slashSuggestions completes a slash command typed at the start of the input.
+func (m AgentModel) slashSuggestions() []string {
+	value := m.textarea.Value()
+	prefix, ok := strings.CutPrefix(value, "/")
+	if !ok || strings.ContainsAny(prefix, " \n") {
+		return nil
+	}
+	var suggestions []string
+	for _, c := range m.slashCommands() {
+		if strings.HasPrefix(c.Name, prefix) && !contains(suggestions, "/"+c.Name) {
+			suggestions = append(suggestions, "/"+c.Name)
+		}
+	}
+	return suggestions
+}
+
+// commandDescription describes a suggested slash command.
+func (m AgentModel) commandDescription(suggestion string) string {
+	name := strings.TrimPrefix(suggestion, "/")
+	for _, c := range m.slashCommands() {
+		if c.Name == name {
+			return c.Description
+		}
+	}
+	return ""
+}
@@ -0,0 +1,123 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/CiaranMccarthy1/boba-text/pkg/config"
+	"github.com/CiaranMccarthy1/boba-text/pkg/llm"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// maxReplyReserve is the most of the token limit kept free for the reply,
+// unless max_output_tokens asks for more.
+const maxReplyReserve = 4096
+
+// tokenLimit is how many tokens a request may use, reply included.
+func tokenLimit(cfg config.AI) int {
+	if cfg.TokenLimit > 0 {
+		return cfg.TokenLimit
+	}
+	return llm.DefaultTokenLimit(cfg.Provider)
+}
+
+// inputLimit is the part of the token limit a request may fill, leaving
+// room for the reply.
+func inputLimit(cfg config.AI) int {
+	limit := tokenLimit(cfg)
+	reserve := maxReplyReserve
+	if cfg.MaxOutputTokens > 0 {
+		reserve = cfg.MaxOutputTokens
+	}
+	return limit - min(reserve, limit/4)
+}
+
+// requestSystem is the system prompt sent with the given tools: the
+// configured one, or the built-in one, followed by how to propose changes.
+func requestSystem(cfg config.AI, tools []llm.Tool) string {
+	system := systemPrompt
+	if cfg.SystemPrompt != "" {
+		system = strings.TrimSpace(cfg.SystemPrompt) + " "
+	}
+	system += rewriteInstructions
+	if len(tools) > 0 {
+		system += toolInstructions
+	}
+	return system
+}
+
+// historyBudget is the size the conversation is compacted to: the configured
+// history budget, but never more than what the system prompt and tools
+// leave of the token limit.
+func historyBudget(cfg config.AI, tools []llm.Tool, count llm.Tokenizer) int {
+	fixed := llm.RequestTokens(llm.Request{System: requestSystem(cfg, tools), Tools: tools}, count)
+	room := max(inputLimit(cfg)-fixed, 1)
+	if cfg.HistoryBudget > 0 {
+		return min(cfg.HistoryBudget, room)
+	}
+	return room
+}
+
+// fitAttachments cuts the attachments for a prompt down to what the token
+// limit leaves once the system prompt, tools, history and the prompt itself
+// are counted. It returns a note for each one cut or left out.
+func (m AgentModel) fitAttachments(input string, attachments []llm.Attachment) ([]llm.Attachment, []string) {
+	count := llm.TokenizerFor(m.config.Provider)
+	tools := m.toolDefinitions()
+	next := m.conv.Clone()
+	next.Add(llm.Turn{Role: llm.RoleUser, Text: input})
+	history := next.Tokens(count)
+	if b := m.config.HistoryBudget; b > 0 {
+		// Older turns will be summarised down to the budget.
+		history = min(history, b)
+	}
+	fixed := llm.RequestTokens(llm.Request{System: requestSystem(m.config, tools), Tools: tools}, count)
+
+	fitted := llm.FitAttachments(attachments, inputLimit(m.config)-fixed-history, count)
+	var notes []string
+	for i, a := range fitted {
+		name := strings.TrimPrefix(attachmentLabel(a), "📎 ")
+		switch {
+		case a.Omitted:
+			notes = append(notes, "⚠ "+name+" left out to fit the token limit")
+		case a.Label != attachments[i].Label:
+			notes = append(notes, "✂ "+name+" to fit the token limit")
+		}
+	}
+	return fitted, notes
+}
+
+// countTokens measures the request the conversation would make now.
+func (m *AgentModel) countTokens() {
+	tools := m.toolDefinitions()
+	req := m.conv.Request(requestSystem(m.config, tools))
+	req.Tools = tools
+	m.tokens = llm.RequestTokens(req, llm.TokenizerFor(m.config.Provider))
+}
+
+// tokenIndicator shows the estimated size of the next request against the
+// token limit, coloured as it nears the part of it left after the reply.
+func (m AgentModel) tokenIndicator() string {
+	limit := inputLimit(m.config)
+	text := fmt.Sprintf("~%s / %s tokens", formatTokens(m.tokens), formatTokens(tokenLimit(m.config)))
+	switch {
+	case m.tokens > limit:
+		return lipgloss.NewStyle().Foreground(ColorError).Render(text)
+	case m.tokens*5 > limit*4:
+		return lipgloss.NewStyle().Foreground(ColorWarning).Render(text)
+	}
+	return StyleDim.Render(text)
+}
+
+// formatTokens abbreviates a token count: 950, 12.3k, 128k, 1M.
+func formatTokens(n int) string {
+	switch {
+	case n >= 1000000:
+		return strings.TrimSuffix(fmt.Sprintf("%.1f", float64(n)/1000000), ".0") + "M"
+	case n >= 100000:
+		return fmt.Sprintf("%dk", n/1000)
+	case n >= 1000:
+		return strings.TrimSuffix(fmt.Sprintf("%.1f", float64(n)/1000), ".0") + "k"
+	}
+	return fmt.Sprint(n)
+}
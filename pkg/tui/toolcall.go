@@ -0,0 +1,224 @@
+package tui
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/CiaranMccarthy1/boba-text/pkg/diff"
+	"github.com/CiaranMccarthy1/boba-text/pkg/fileio"
+	"github.com/CiaranMccarthy1/boba-text/pkg/llm"
+	"github.com/CiaranMccarthy1/boba-text/pkg/tools"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// toolResultLines is how much of a tool's output the conversation shows; the
+// assistant always gets all of it.
+const toolResultLines = 8
+
+// toolInstructions is added to the system prompt when tools are offered.
+const toolInstructions = " You can use tools to read, search and edit files in the project and to run commands " +
+	"in its root; look things up rather than guessing. Prefer apply_edit for small, targeted changes. " +
+	"The user approves each edit and command, and may decline."
+
+// toolResultMsg reports a finished tool call.
+type toolResultMsg struct {
+	Call   llm.ToolCall
+	Result string
+	Err    error
+	id     int
+}
+
+// toolEditMsg asks the editor to write an approved apply_edit, so the open
+// buffer can be checked and reloaded around it.
+type toolEditMsg struct {
+	Call llm.ToolCall
+	Edit tools.Edit
+	id   int
+}
+
+// toolApproval is a tool call waiting for the user to allow or decline it.
+type toolApproval struct {
+	call llm.ToolCall
+	edit *tools.Edit
+}
+
+// runTool runs a tool call that needs no approval, or one that has been
+// approved, off the UI goroutine.
+func runTool(ctx context.Context, id int, ws tools.Workspace, call llm.ToolCall) tea.Cmd {
+	return func() tea.Msg {
+		result, err := ws.Run(ctx, call)
+		return toolResultMsg{Call: call, Result: result, Err: err, id: id}
+	}
+}
+
+// workspace is where the assistant's tools may look and write.
+func (m AgentModel) workspace() tools.Workspace {
+	return tools.Workspace{Root: m.root}
+}
+
+// toolDefinitions lists the tools offered to the assistant, if enabled.
+func (m AgentModel) toolDefinitions() []llm.Tool {
+	if !m.config.Tools || m.root == "" {
+		return nil
+	}
+	return m.workspace().Definitions()
+}
+
+// nextTool starts the next queued tool call. Calls that change files or run
+// commands wait for approval; once every call is answered the results go
+// back to the assistant, unless the step limit was hit.
+func (m *AgentModel) nextTool() tea.Cmd {
+	ws := m.workspace()
+	for len(m.toolCalls) > 0 {
+		call := m.toolCalls[0]
+		m.toolCalls = m.toolCalls[1:]
+		m.messages = append(m.messages, StyleBold.Render("⚙ ")+StyleDim.Render(tools.Describe(call)))
+
+		m.steps++
+		if m.steps > m.config.MaxSteps {
+			m.recordTool(call, "", fmt.Errorf("not run: the limit of %d tool step(s) for this prompt was reached", m.config.MaxSteps))
+			continue
+		}
+		if !tools.NeedsApproval(call.Name) {
+			m.running = &call
+			return runTool(m.ctx, m.requestID, ws, call)
+		}
+
+		a := &toolApproval{call: call}
+		if call.Name == tools.ApplyEdit {
+			edit, err := ws.PrepareEdit(call)
+			if err != nil {
+				m.recordTool(call, "", err)
+				continue
+			}
+			a.edit = &edit
+			name := filepath.ToSlash(relPath(m.root, edit.Path))
+			hunks := diff.Compute(edit.Before, edit.After, 3)
+			m.messages = append(m.messages, renderDiff(diff.Unified("a/"+name, "b/"+name, hunks)))
+		}
+		m.approval = a
+		return nil
+	}
+
+	if m.steps > m.config.MaxSteps {
+		m.finishRequest()
+		m.messages = append(m.messages, StyleDim.Render(fmt.Sprintf(
+			"(stopped after %d tool step(s); send another message to let it continue)", m.config.MaxSteps)))
+		return nil
+	}
+	if m.providerErr != nil {
+		// The config changed under the request, leaving no provider to ask.
+		m.finishRequest()
+		m.messages = append(m.messages, m.errorStyle.Render("Error: ")+m.providerErr.Error())
+		return nil
+	}
+	return m.request()
+}
+
+// recordTool adds a tool's result to the conversation and shows the start of it.
+func (m *AgentModel) recordTool(call llm.ToolCall, result string, err error) {
+	if err != nil {
+		result = "Error: " + err.Error()
+	}
+	m.messages = append(m.messages, m.renderToolResult(result))
+	m.conv.Add(llm.Turn{Role: llm.RoleTool, Text: result, ToolCallID: call.ID, ToolName: call.Name})
+}
+
+// renderToolResult shows a failure in full and the start of any other result.
+func (m AgentModel) renderToolResult(result string) string {
+	if msg, ok := strings.CutPrefix(result, "Error: "); ok {
+		return m.errorStyle.Render("Error: ") + msg
+	}
+	lines := strings.Split(strings.TrimRight(result, "\n"), "\n")
+	if len(lines) > toolResultLines {
+		result = strings.Join(lines[:toolResultLines], "\n") +
+			fmt.Sprintf("\n… %d more lines", len(lines)-toolResultLines)
+	}
+	return StyleDim.Render(result)
+}
+
+// handleApprovalKey answers the pending tool approval: y runs it, n declines
+// it and carries on, and the cancel key stops the whole turn.
+func (m *AgentModel) handleApprovalKey(msg tea.KeyMsg) tea.Cmd {
+	a := m.approval
+	switch key := msg.String(); key {
+	case "y", "Y":
+		m.approval = nil
+		m.running = &a.call
+		if a.edit != nil {
+			id := m.requestID
+			return func() tea.Msg { return toolEditMsg{Call: a.call, Edit: *a.edit, id: id} }
+		}
+		return runTool(m.ctx, m.requestID, m.workspace(), a.call)
+	case "n", "N":
+		m.approval = nil
+		m.recordTool(a.call, "", errors.New("the user declined this action"))
+		cmd := m.nextTool()
+		m.refresh()
+		return cmd
+	case m.keys.AgentCancel:
+		m.Cancel()
+		return nil
+	default:
+		// Let the conversation scroll while deciding.
+		var cmd tea.Cmd
+		m.viewport, cmd = m.viewport.Update(msg)
+		return cmd
+	}
+}
+
+// cancelTools answers the current and queued tool calls as cancelled, so
+// every call in the history keeps a result.
+func (m *AgentModel) cancelTools() {
+	var calls []llm.ToolCall
+	if m.approval != nil {
+		calls = append(calls, m.approval.call)
+	}
+	if m.running != nil {
+		calls = append(calls, *m.running)
+	}
+	calls = append(calls, m.toolCalls...)
+	for _, call := range calls {
+		m.conv.Add(llm.Turn{Role: llm.RoleTool, Text: "Error: cancelled by the user", ToolCallID: call.ID, ToolName: call.Name})
+	}
+	m.approval = nil
+	m.running = nil
+	m.toolCalls = nil
+}
+
+// relPath shows path relative to root when it is inside it.
+func relPath(root, path string) string {
+	if rel, err := filepath.Rel(root, path); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return rel
+	}
+	return path
+}
+
+// applyToolEdit writes an approved apply_edit and reloads the file if it is
+// open, refusing when the open buffer has unsaved changes.
+func (m *Model) applyToolEdit(msg toolEditMsg) (string, error) {
+	e := msg.Edit
+	open := m.editor.filename != "" && samePath(m.editor.filename, e.Path)
+	if m.editor.readOnly {
+		return "", errors.New("read-only mode, not writing to disk")
+	}
+	if open && m.editor.modified {
+		return "", errors.New("the file is open in the editor with unsaved changes")
+	}
+	err := m.agent.workspace().WriteEdit(e, fileio.SaveOptions{
+		Backup:    m.editor.files.Backup,
+		BackupDir: m.editor.files.BackupDir,
+	})
+	if err != nil {
+		return "", err
+	}
+	if open {
+		if err := m.editor.loadFile(e.Path); err != nil {
+			return "", err
+		}
+	}
+	return "Edited " + filepath.ToSlash(relPath(m.startPath, e.Path)), nil
+}
@@ -0,0 +1,88 @@
+package tui
+
+import (
+	"path/filepath"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/fsnotify/fsnotify"
+)
+
+// diskWatcher is told by the filesystem when the open file changes, so a
+// conflict shows up at once rather than at the next poll. It watches the
+// file's directory, since many programs save by writing a new file and
+// renaming it over the old one.
+type diskWatcher struct {
+	w   *fsnotify.Watcher
+	dir string
+}
+
+// diskEventMsg reports a change to a file in the watched directory.
+type diskEventMsg struct {
+	name string
+}
+
+// newDiskWatcher starts a watcher, or returns nil where notifications are
+// unavailable and the open file must be polled instead.
+func newDiskWatcher() *diskWatcher {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil
+	}
+	return &diskWatcher{w: w}
+}
+
+// watch switches to the directory of path, reporting whether notifications
+// cover it.
+func (d *diskWatcher) watch(path string) bool {
+	dir := filepath.Dir(path)
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	if dir == d.dir {
+		return true
+	}
+	if d.dir != "" {
+		d.w.Remove(d.dir)
+		d.dir = ""
+	}
+	if err := d.w.Add(dir); err != nil {
+		return false
+	}
+	d.dir = dir
+	return true
+}
+
+// next waits for the next change. Watcher errors, such as an overflowing
+// event queue, are reported as a change of unknown name so the open file is
+// checked anyway.
+func (d *diskWatcher) next() tea.Cmd {
+	return func() tea.Msg {
+		select {
+		case ev, ok := <-d.w.Events:
+			if !ok {
+				return nil
+			}
+			return diskEventMsg{name: ev.Name}
+		case _, ok := <-d.w.Errors:
+			if !ok {
+				return nil
+			}
+			return diskEventMsg{}
+		}
+	}
+}
+
+// close stops the watcher.
+func (d *diskWatcher) close() {
+	d.w.Close()
+}
+
+// concerns reports whether an event may be about path.
+func (e diskEventMsg) concerns(path string) bool {
+	if e.name == "" {
+		return true
+	}
+	a, err1 := filepath.Abs(e.name)
+	b, err2 := filepath.Abs(path)
+	return err1 != nil || err2 != nil || a == b
+}